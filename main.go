@@ -18,15 +18,76 @@ func main() {
 	fps := flag.Int("fps", 30, "Output framerate override: 30 or 60")
 	fitAudio := flag.Bool("fit-audio", false, "Auto-fit image and transition durations to fill the music length")
 	includeVideos := flag.Bool("include-videos", false, "Include supported video files (mp4, mov, mkv, avi, webm, m4v) together with pictures")
-	keepVideoAudio := flag.Bool("keep-video-audio", false, "Keep input video audio and blend it with MP3 background audio")
+	keepVideoAudio := flag.Bool("keep-video-audio", false, "Keep input video audio and blend it with the background music")
 	orderMode := flag.String("order", "metadata", "Timeline order: metadata, filename, or random")
 	orderByFilename := flag.Bool("order-by-filename", false, "Order timeline by filename instead of metadata time")
 	randomOrder := flag.Bool("random-order", false, "Order timeline randomly")
 	fullHD := flag.Bool("fullhd", false, "Generate Full HD (1920x1080) video instead of 4K UHD (3840x2160)")
 	effectsMode := flag.String("effects", "disabled", "Image motion effects: disabled, low, medium, or high")
 	debug := flag.Bool("debug", false, "Show environment detection and optimization info")
-	exifOverlay := flag.Bool("exif-overlay", false, "Add camera info overlay to video (bottom center)")
+	exifOverlay := flag.Bool("exif-overlay", false, "Add camera info overlay to photos and video clips (bottom center)")
 	overlayFontSize := flag.Int("overlay-font-size", 48, "Font size for EXIF overlay (default: 48)")
+	overlayTemplate := flag.String("overlay-template", "", "Go text/template for the EXIF overlay text (default: camera model, settings and date)")
+	overlayPosition := flag.String("overlay-position", "bottom", "EXIF overlay position: top-left, top, top-right, left, center, right, bottom-left, bottom, or bottom-right")
+	overlayMarginX := flag.Int("overlay-margin-x", 0, "Horizontal EXIF overlay margin in pixels (0 = default)")
+	overlayMarginY := flag.Int("overlay-margin-y", 0, "Vertical EXIF overlay margin in pixels (0 = default)")
+	overlayFont := flag.String("overlay-font", "", "Font file (TTF/OTF) for the EXIF overlay")
+	overlayColor := flag.String("overlay-color", "white", "EXIF overlay text colour")
+	overlayBoxColor := flag.String("overlay-box-color", "black", "EXIF overlay background box colour")
+	overlayBoxOpacity := flag.Float64("overlay-box-opacity", 0.5, "EXIF overlay background box opacity (0 disables the box)")
+	overlayShadow := flag.Bool("overlay-shadow", false, "Draw a drop shadow behind the EXIF overlay text")
+	overlayFade := flag.Bool("overlay-fade", false, "Fade the EXIF overlay in and out with the crossfade")
+	overlayRenderer := flag.String("overlay-renderer", "drawtext", "EXIF overlay renderer: drawtext (FFmpeg) or image (PNG captions rendered in Go)")
+	captions := flag.Bool("captions", false, "Show per-item captions from sidecar .txt files, XMP dc:description or IPTC caption (lower third)")
+	captionFontSize := flag.Int("caption-font-size", 0, "Font size for captions (0 = 64 UHD, 40 Full HD)")
+	title := flag.String("title", "", "Opening title card text")
+	subtitle := flag.String("subtitle", "", "Subtitle shown below the title on the opening card")
+	credits := flag.String("credits", "", "Closing credits card text, or \"auto\" to list cameras, dates and music")
+	watermark := flag.String("watermark", "", "Logo image (PNG) overlaid on the whole video")
+	watermarkPosition := flag.String("watermark-position", "bottom-right", "Watermark position: top-left, top, top-right, left, center, right, bottom-left, bottom, or bottom-right")
+	watermarkScale := flag.Float64("watermark-scale", 0.15, "Watermark width as a fraction of the video width")
+	watermarkOpacity := flag.Float64("watermark-opacity", 0.8, "Watermark opacity (0-1)")
+	watermarkMargin := flag.Int("watermark-margin", 0, "Watermark margin in pixels (0 = default)")
+	watermarkFade := flag.Bool("watermark-fade", false, "Fade the watermark in and out with the video")
+	chapters := flag.String("chapters", "none", "MP4 chapter boundaries: none, folder, day, cluster, or every")
+	chapterEvery := flag.Int("chapter-every", 10, "Items per chapter with -chapters every")
+	chapterGap := flag.Duration("chapter-gap", 2*time.Hour, "Capture time gap that starts a new chapter with -chapters cluster")
+	subtitleTrack := flag.Bool("subtitle-track", false, "Write SRT/WebVTT subtitles with each item's caption/EXIF text and mux them as a toggleable track")
+	subtitleTrackText := flag.String("subtitle-track-text", "both", "Subtitle track text: caption, exif, or both")
+	musicCrossfade := flag.Float64("music-crossfade", 0, "Seconds of crossfade between consecutive music tracks (0 = back to back)")
+	musicDucking := flag.String("music-ducking", "mute", "How music makes room for clip audio with -keep-video-audio: mute or duck")
+	duckLevel := flag.Float64("duck-level", -18, "Music level in dB while ducked with -music-ducking duck")
+	duckAttack := flag.Float64("duck-attack", 20, "Milliseconds for the music to dip when clip audio starts")
+	duckRelease := flag.Float64("duck-release", 400, "Milliseconds for the music to recover when clip audio stops")
+	fitTrimClips := flag.Bool("fit-audio-trim-clips", false, "Let -fit-audio shorten video clips when photos alone cannot absorb the difference")
+	fitMinClip := flag.Float64("fit-audio-min-clip", 3, "Shortest length in seconds -fit-audio-trim-clips may cut a clip to")
+	loudness := flag.Float64("loudness", -16, "Integrated loudness target of the final mix in LUFS (e.g. -14 YouTube, -23 broadcast)")
+	loudnessTwoPass := flag.Bool("loudness-two-pass", false, "Measure the mix in an extra audio-only ffmpeg pass and normalize it linearly (slower)")
+	musicGain := flag.Float64("music-gain", 0, "Gain in dB applied to the music before mixing")
+	clipGain := flag.Float64("clip-gain", 0, "Gain in dB applied to kept clip audio before mixing")
+	videoCodec := flag.String("codec", "h264", "Video codec: h264, hevc, av1, or vp9 (hardware encoders are used when detected)")
+	container := flag.String("container", "", "Output container: mp4, mkv, or webm (default: mp4, or webm/mkv for vp9)")
+	audioCodec := flag.String("audio-codec", "aac", "Audio codec: aac, opus, or flac")
+	audioBitrate := flag.String("audio-bitrate", "", "Audio bitrate such as 192k (default: from the source for AAC, 160k for Opus)")
+	audioSampleRate := flag.Int("audio-sample-rate", 48000, "Audio sample rate: 44100, 48000, or 96000")
+	silentAudio := flag.Bool("silent-audio", false, "Add a silent stereo audio track when there is no music or clip audio")
+	beatSync := flag.Bool("beat-sync", false, "Snap transitions to beats detected in the music")
+	beatEvery := flag.Int("beat-every", 1, "Cut on every Nth beat with -beat-sync")
+	beatMin := flag.Float64("beat-min", 0, "Shortest photo duration in seconds -beat-sync may use (default half of -d)")
+	beatMax := flag.Float64("beat-max", 0, "Longest photo duration in seconds -beat-sync may use (default 1.5 × -d)")
+	narration := flag.String("narration", "", "Voice-over track mixed over the music, which ducks under it")
+	narrationOffset := flag.Float64("narration-offset", 0, "Seconds into the video where -narration starts")
+	narrationPerItem := flag.Bool("narration-per-item", false, "Play <item basename>.wav (e.g. IMG_0042.wav) over each item, holding photos until it ends")
+	musicTracks := flag.String("music-tracks", "", "Comma-separated [ITEMS=]FILE[@START[-END]] music list replacing the files found in the folder (file names without , = @)")
+	musicFit := flag.String("music-fit", "", "When the music is shorter than the video: loop, truncate-video, silence, or fit-audio")
+	musicTrimSilence := flag.Bool("music-trim-silence", false, "Trim leading and trailing silence from each music track before joining")
+	preview := flag.Bool("preview", false, "Render a fast 640x360 30 fps preview (video_preview.mp4) to review order and pacing")
+	previewRange := flag.String("preview-range", "", "Preview only a time window, e.g. 60s-120s or 1:00-2:00 (implies -preview)")
+	contactSheet := flag.Bool("contact-sheet", false, "Write a contact sheet PNG with a thumbnail, index, start time, duration and name of every item")
+	contactSheetColumns := flag.Int("contact-sheet-columns", 5, "Thumbnails per row on the contact sheet")
+	contactSheetRows := flag.Int("contact-sheet-rows", 6, "Rows per contact sheet page; longer timelines get numbered pages")
+	dryRun := flag.Bool("dry-run", false, "Plan the video and print the timeline, filter graph and ffmpeg command without converting or encoding")
+	exportTimeline := flag.String("export-timeline", "", "Export the timeline for editors: comma-separated fcpxml, edl, otio, or all")
 	version := flag.Bool("version", false, "Show version information")
 	versionShort := flag.Bool("v", false, "Show version information (short)")
 	help := flag.Bool("help", false, "Show this help message")
@@ -43,12 +104,92 @@ func main() {
 		fmt.Printf("  -fps int                              Output framerate override: 30 or 60\n")
 		fmt.Printf("  -effects string                       Image motion effects: disabled, low, medium, or high (default disabled)\n")
 		fmt.Printf("  -fit-audio                            Auto-fit image and transition durations to fill the music length\n")
+		fmt.Printf("                                        (with videos, photo durations absorb the difference and clips keep their length)\n")
+		fmt.Printf("  -fit-audio-trim-clips                 Let -fit-audio shorten video clips when photos alone cannot absorb the difference\n")
+		fmt.Printf("  -fit-audio-min-clip float             Shortest length in seconds -fit-audio-trim-clips may cut a clip to (default 3)\n")
 		fmt.Printf("  -include-videos                       Include supported video files (mp4, mov, mkv, avi, webm, m4v) together with pictures\n")
-		fmt.Printf("  -keep-video-audio                     Keep input video audio and blend it with MP3 background audio\n")
+		fmt.Printf("  -keep-video-audio                     Keep input video audio and blend it with the background music\n")
 		fmt.Printf("  -order string                         Timeline order: metadata, filename, or random (default metadata)\n")
 		fmt.Printf("  -fullhd                               Generate Full HD (1920x1080) video instead of 4K UHD (3840x2160)\n")
-		fmt.Printf("  -exif-overlay                         Add camera info overlay to video (bottom center)\n")
+		fmt.Printf("  -exif-overlay                         Add camera info overlay to photos and video clips (bottom center)\n")
 		fmt.Printf("  -overlay-font-size int                Font size for EXIF overlay (default 36)\n")
+		fmt.Printf("  -overlay-template string              Go text/template for the EXIF overlay text (fields: Make, Model, Lens, FocalLength,\n")
+		fmt.Printf("                                        FNumber, ExposureTime, ISO, DateTaken, Location, Filename, Index, Count, Place;\n")
+		fmt.Printf("                                        Date \"layout\")\n")
+		fmt.Printf("  -overlay-position string              EXIF overlay position: top-left, top, top-right, left, center, right,\n")
+		fmt.Printf("                                        bottom-left, bottom, or bottom-right (default bottom)\n")
+		fmt.Printf("  -overlay-margin-x int                 Horizontal EXIF overlay margin in pixels (default 40 UHD, 30 Full HD)\n")
+		fmt.Printf("  -overlay-margin-y int                 Vertical EXIF overlay margin in pixels (default 40 UHD, 30 Full HD)\n")
+		fmt.Printf("  -overlay-font string                  Font file (TTF/OTF) for the EXIF overlay\n")
+		fmt.Printf("  -overlay-color string                 EXIF overlay text colour (default white)\n")
+		fmt.Printf("  -overlay-box-color string             EXIF overlay background box colour (default black)\n")
+		fmt.Printf("  -overlay-box-opacity float            EXIF overlay background box opacity, 0 disables the box (default 0.5)\n")
+		fmt.Printf("  -overlay-shadow                       Draw a drop shadow behind the EXIF overlay text\n")
+		fmt.Printf("  -overlay-fade                         Fade the EXIF overlay in and out with the crossfade\n")
+		fmt.Printf("  -overlay-renderer string              EXIF overlay renderer: drawtext (FFmpeg) or image (PNG captions rendered in Go,\n")
+		fmt.Printf("                                        no libfreetype/fontconfig needed) (default drawtext)\n")
+		fmt.Printf("  -captions                             Show per-item captions from photo.jpg.txt sidecars, XMP dc:description or\n")
+		fmt.Printf("                                        IPTC caption as a lower third (uses the overlay style and renderer)\n")
+		fmt.Printf("  -caption-font-size int                Font size for captions (default 64 UHD, 40 Full HD)\n")
+		fmt.Printf("  -title string                         Opening title card text (\\n starts a new line)\n")
+		fmt.Printf("  -subtitle string                      Subtitle shown below the title on the opening card\n")
+		fmt.Printf("  -credits string                       Closing credits card text, or \"auto\" to list cameras, date range and\n")
+		fmt.Printf("                                        music track titles\n")
+		fmt.Printf("  -watermark string                     Logo image (PNG) overlaid on the whole video, steady across transitions\n")
+		fmt.Printf("  -watermark-position string            Watermark position, same names as -overlay-position (default bottom-right)\n")
+		fmt.Printf("  -watermark-scale float                Watermark width as a fraction of the video width (default 0.15)\n")
+		fmt.Printf("  -watermark-opacity float              Watermark opacity, 0-1 (default 0.8)\n")
+		fmt.Printf("  -watermark-margin int                 Watermark margin in pixels (default 40 UHD, 30 Full HD)\n")
+		fmt.Printf("  -watermark-fade                       Fade the watermark in and out with the video\n")
+		fmt.Printf("  -chapters string                      MP4 chapter boundaries: none, folder, day, cluster, or every (default none);\n")
+		fmt.Printf("                                        also writes chapters.txt in YouTube description format\n")
+		fmt.Printf("  -chapter-every int                    Items per chapter with -chapters every (default 10)\n")
+		fmt.Printf("  -chapter-gap duration                 Capture time gap that starts a new chapter with -chapters cluster (default 2h)\n")
+		fmt.Printf("  -subtitle-track                       Write SRT/WebVTT subtitles with each item's caption/EXIF text and mux them\n")
+		fmt.Printf("                                        into the MP4 as a toggleable mov_text track\n")
+		fmt.Printf("  -subtitle-track-text string           Subtitle track text: caption, exif, or both (default both)\n")
+		fmt.Printf("  -export-timeline string               Export the timeline for Final Cut Pro/Resolve: comma-separated fcpxml,\n")
+		fmt.Printf("                                        edl, otio, or all (written next to the video)\n")
+		fmt.Printf("  -music-crossfade float                Seconds of crossfade between consecutive music tracks (default 0)\n")
+		fmt.Printf("  -loudness float                       Integrated loudness target of the final mix in LUFS (default -16;\n")
+		fmt.Printf("                                        e.g. -14 YouTube, -23 broadcast)\n")
+		fmt.Printf("  -loudness-two-pass                    Measure the mix first and normalize it linearly, keeping its dynamics;\n")
+		fmt.Printf("                                        adds an audio-only ffmpeg pass that decodes every input once more\n")
+		fmt.Printf("  -music-gain float                     Gain in dB applied to the music before mixing (default 0)\n")
+		fmt.Printf("  -clip-gain float                      Gain in dB applied to kept clip audio before mixing (default 0)\n")
+		fmt.Printf("  -codec string                         Video codec: h264, hevc, av1, or vp9 (default h264; hardware encoders\n")
+		fmt.Printf("                                        are used when detected)\n")
+		fmt.Printf("  -container string                     Output container: mp4, mkv, or webm (default mp4; vp9 uses webm with\n")
+		fmt.Printf("                                        -audio-codec opus, otherwise mkv)\n")
+		fmt.Printf("  -audio-codec string                   Audio codec: aac, opus, or flac (default aac)\n")
+		fmt.Printf("  -audio-bitrate string                 Audio bitrate such as 192k (default: from the source for AAC, 160k for Opus)\n")
+		fmt.Printf("  -audio-sample-rate int                Audio sample rate: 44100, 48000, or 96000 (default 48000)\n")
+		fmt.Printf("  -silent-audio                         Add a silent stereo audio track when there is no music or clip audio\n")
+		fmt.Printf("  -beat-sync                            Snap transitions to beats detected in the music\n")
+		fmt.Printf("  -beat-every int                       Cut on every Nth beat with -beat-sync (default 1)\n")
+		fmt.Printf("  -beat-min float                       Shortest photo duration in seconds -beat-sync may use (default half of -d)\n")
+		fmt.Printf("  -beat-max float                       Longest photo duration in seconds -beat-sync may use (default 1.5 × -d)\n")
+		fmt.Printf("  -narration string                     Voice-over track mixed over the music, which ducks under it\n")
+		fmt.Printf("  -narration-offset float               Seconds into the video where -narration starts (default 0)\n")
+		fmt.Printf("  -narration-per-item                   Play <item basename>.wav (e.g. IMG_0042.wav) over each item, holding\n")
+		fmt.Printf("                                        photos until it ends\n")
+		fmt.Printf("  -music-tracks string                  Comma-separated [ITEMS=]FILE[@START[-END]] music list replacing the files found\n")
+		fmt.Printf("                                        in the folder, e.g. \"1-40=a.mp3@1:05-3:20,41-=b.mp3\"\n")
+		fmt.Printf("  -music-fit string                     When the music is shorter than the video: loop, truncate-video, silence, or fit-audio\n")
+		fmt.Printf("                                        (default fit-audio with -fit-audio, otherwise truncate-video)\n")
+		fmt.Printf("  -music-trim-silence                   Trim leading and trailing silence from each music track before joining\n")
+		fmt.Printf("  -music-ducking string                 How music makes room for clip audio with -keep-video-audio: mute or duck (default mute)\n")
+		fmt.Printf("  -duck-level float                     Music level in dB while ducked with -music-ducking duck (default -18)\n")
+		fmt.Printf("  -duck-attack float                    Milliseconds for the music to dip when clip audio starts (default 20)\n")
+		fmt.Printf("  -duck-release float                   Milliseconds for the music to recover when clip audio stops (default 400)\n")
+		fmt.Printf("  -preview                              Fast 640x360 30 fps preview render (video_preview.mp4), same timeline and audio\n")
+		fmt.Printf("  -preview-range string                 Preview only a time window, e.g. 60s-120s or 1:00-2:00 (implies -preview)\n")
+		fmt.Printf("  -contact-sheet                        Write <video>_contact_sheet.png with a thumbnail, index, start time, duration\n")
+		fmt.Printf("                                        and name of every item, before encoding\n")
+		fmt.Printf("  -contact-sheet-columns int            Thumbnails per row on the contact sheet (default 5)\n")
+		fmt.Printf("  -contact-sheet-rows int               Rows per contact sheet page (default 6)\n")
+		fmt.Printf("  -dry-run                              Print the timeline, filter_complex and ffmpeg command without converting\n")
+		fmt.Printf("                                        images or encoding (command written to a temporary folder)\n")
 		fmt.Printf("  -gui                                  Launch desktop GUI\n")
 		fmt.Printf("  -debug                                Show environment detection and optimization info\n")
 		fmt.Printf("  -version                              Show version information\n")
@@ -65,11 +206,33 @@ func main() {
 		fmt.Printf("  go24k -effects high                        # Pan + zoom with high intensity\n")
 		fmt.Printf("  go24k -exif-overlay                        # Add camera info overlay\n")
 		fmt.Printf("  go24k -exif-overlay -overlay-font-size 48  # Large font overlay\n")
+		fmt.Printf("  go24k -exif-overlay -overlay-template '{{.Make}} {{.Model}} · {{.Lens}} · {{.Date \"2006-01-02\"}}'\n")
+		fmt.Printf("  go24k -exif-overlay -overlay-position top-right -overlay-shadow -overlay-fade  # Styled overlay\n")
+		fmt.Printf("  go24k -exif-overlay -overlay-renderer image  # Captions rendered in Go, identical on every platform\n")
+		fmt.Printf("  go24k -captions -overlay-shadow            # Lower-third captions from sidecars/XMP/IPTC\n")
+		fmt.Printf("  go24k -title \"Lisboa\" -subtitle \"Agosto 2024\" -credits auto  # Title and credits cards\n")
+		fmt.Printf("  go24k -watermark logo.png -watermark-position top-right  # Studio logo on every frame\n")
+		fmt.Printf("  go24k -chapters day                        # One chapter per day, plus chapters.txt for YouTube\n")
+		fmt.Printf("  go24k -subtitle-track                      # Toggleable, searchable captions instead of burned-in text\n")
+		fmt.Printf("  go24k -export-timeline all                 # FCPXML, EDL and OTIO to finish the cut in an editor\n")
+		fmt.Printf("  go24k -music-crossfade 4 -music-trim-silence  # Smooth changes between tracks\n")
+		fmt.Printf("  go24k -codec hevc                        # Smaller 4K archive that plays on Apple devices\n")
+		fmt.Printf("  go24k -codec vp9 -audio-codec opus       # WebM for the web\n")
+		fmt.Printf("  go24k -loudness -14 -music-gain -3       # YouTube loudness, music a little under the clips\n")
+		fmt.Printf("  go24k -fit-audio -beat-sync -beat-every 4  # Cut on the first beat of each bar\n")
+		fmt.Printf("  go24k -narration story.wav -narration-offset 5  # Voice-over from 5s, music ducked under it\n")
+		fmt.Printf("  go24k -music-tracks \"1-40=a.mp3@1:05,41-=b.mp3\"  # Start at the chorus, change song at item 41\n")
+		fmt.Printf("  go24k -music-fit loop                    # Repeat a short song until the last photo\n")
+		fmt.Printf("  go24k -preview -preview-range 60s-120s     # Review pacing of the second minute in seconds\n")
+		fmt.Printf("  go24k -dry-run -contact-sheet              # Storyboard for client sign-off without encoding\n")
+		fmt.Printf("  go24k -dry-run -fit-audio                  # Check order, timing and the ffmpeg command before rendering\n")
 		fmt.Printf("  go24k -fit-audio                         # Auto-fit duration to music length\n")
+		fmt.Printf("  go24k -include-videos -fit-audio -fit-audio-trim-clips  # Mixed album ending exactly with the music\n")
 		fmt.Printf("  go24k -include-videos                    # Mix videos (including MOV) with pictures in the timeline\n")
 		fmt.Printf("  go24k -order random                      # Random timeline order\n")
 		fmt.Printf("  go24k -order filename                    # Filename timeline order\n")
-		fmt.Printf("  go24k -include-videos -keep-video-audio  # Keep clip audio and blend it with MP3 audio\n")
+		fmt.Printf("  go24k -include-videos -keep-video-audio  # Keep clip audio and blend it with the music\n")
+		fmt.Printf("  go24k -include-videos -keep-video-audio -music-ducking duck -duck-level -20  # Dip music only while clips speak\n")
 		fmt.Printf("  go24k -fullhd                              # Generate Full HD (1920x1080) video\n")
 		fmt.Printf("  go24k -gui                                 # Open desktop GUI\n")
 		fmt.Printf("  go24k -debug                               # Show hardware detection info\n")
@@ -118,7 +281,9 @@ func main() {
 	startTime := time.Now()
 
 	// Convert images (e.g. scale, add background, overlay, etc.)
-	if err := utils.ConvertImages(*fullHD); err != nil {
+	if *dryRun {
+		fmt.Println("Dry run: skipping image conversion.")
+	} else if err := utils.ConvertImages(*fullHD); err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
 	}
@@ -166,8 +331,101 @@ func main() {
 			targetFPS = 30
 		}
 	}
+	opts := utils.GenerateOptions{
+		Overlay: utils.OverlayOptions{
+			Template:   *overlayTemplate,
+			Position:   *overlayPosition,
+			MarginX:    *overlayMarginX,
+			MarginY:    *overlayMarginY,
+			FontFile:   *overlayFont,
+			FontColor:  *overlayColor,
+			BoxColor:   *overlayBoxColor,
+			BoxOpacity: *overlayBoxOpacity,
+			DisableBox: *overlayBoxOpacity == 0,
+			Shadow:     *overlayShadow,
+			Fade:       *overlayFade,
+			Renderer:   *overlayRenderer,
+		},
+		Captions: utils.CaptionOptions{
+			Enabled:  *captions,
+			FontSize: *captionFontSize,
+		},
+		Cards: utils.CardOptions{
+			Title:    *title,
+			Subtitle: *subtitle,
+			Credits:  *credits,
+		},
+		Watermark: utils.WatermarkOptions{
+			File:     *watermark,
+			Position: *watermarkPosition,
+			Scale:    *watermarkScale,
+			Opacity:  *watermarkOpacity,
+			Margin:   *watermarkMargin,
+			Fade:     *watermarkFade,
+		},
+		Chapters: utils.ChapterOptions{
+			Mode:  *chapters,
+			Every: *chapterEvery,
+			Gap:   *chapterGap,
+		},
+		Subtitles: utils.SubtitleOptions{
+			Enabled: *subtitleTrack,
+			Text:    *subtitleTrackText,
+		},
+		Export: utils.ExportOptions{
+			Formats: *exportTimeline,
+		},
+		Preview: utils.PreviewOptions{
+			Enabled: *preview || *previewRange != "",
+			Range:   *previewRange,
+		},
+		Music: utils.MusicOptions{
+			Crossfade:    *musicCrossfade,
+			TrimSilence:  *musicTrimSilence,
+			Fit:          *musicFit,
+			Tracks:       *musicTracks,
+			FitTrimClips: *fitTrimClips,
+			FitMinClip:   *fitMinClip,
+			Ducking:      *musicDucking,
+			DuckLevel:    *duckLevel,
+			DuckAttack:   *duckAttack,
+			DuckRelease:  *duckRelease,
+		},
+		Video: utils.VideoOptions{
+			Codec:     *videoCodec,
+			Container: *container,
+		},
+		Audio: utils.AudioOptions{
+			Loudness:    *loudness,
+			TwoPass:     *loudnessTwoPass,
+			MusicGain:   *musicGain,
+			ClipGain:    *clipGain,
+			Codec:       *audioCodec,
+			Bitrate:     *audioBitrate,
+			SampleRate:  *audioSampleRate,
+			SilentTrack: *silentAudio,
+		},
+		Beats: utils.BeatSyncOptions{
+			Enabled:     *beatSync,
+			Every:       *beatEvery,
+			MinDuration: *beatMin,
+			MaxDuration: *beatMax,
+		},
+		Narration: utils.NarrationOptions{
+			File:    *narration,
+			Offset:  *narrationOffset,
+			PerItem: *narrationPerItem,
+		},
+		ContactSheet: utils.ContactSheetOptions{
+			Enabled: *contactSheet,
+			Columns: *contactSheetColumns,
+			Rows:    *contactSheetRows,
+		},
+		DryRun: *dryRun,
+	}
+
 	// Pass the duration and transition values from the flags.
-	utils.GenerateVideo(*duration, *transition, applyKenBurns, *exifOverlay, *overlayFontSize, *fitAudio, *includeVideos, *keepVideoAudio, *fullHD, targetFPS, resolvedOrderByFilename, resolvedRandomOrder, kenBurnsMode)
+	utils.GenerateVideo(*duration, *transition, applyKenBurns, *exifOverlay, *overlayFontSize, *fitAudio, *includeVideos, *keepVideoAudio, *fullHD, targetFPS, resolvedOrderByFilename, resolvedRandomOrder, kenBurnsMode, opts)
 
 	elapsedTime := time.Since(startTime).Seconds()
 	fmt.Printf("Total time: %.1f sec.\n", elapsedTime)
@@ -17,79 +17,216 @@ type AudioConfig struct {
 	AudioFilter        string
 	HasAudio           bool
 	AudioBitrateSource string
+	MixLabel           string // Mix before loudness normalization; the output maps audioMixLabel
 }
 
-// findMusicFiles returns the list of mp3 files without logging
+// musicExtensions lists the background music formats picked up from the working directory.
+var musicExtensions = map[string]struct{}{
+	".mp3": {}, ".wav": {}, ".flac": {}, ".aac": {}, ".m4a": {}, ".ogg": {}, ".oga": {}, ".opus": {},
+}
+
+// losslessAudioCodecs are codecs whose bitrate says nothing about the AAC bitrate to use.
+var losslessAudioCodecs = map[string]struct{}{
+	"flac": {}, "alac": {}, "wavpack": {}, "ape": {}, "tta": {}, "mlp": {}, "truehd": {},
+}
+
+const (
+	// musicSilenceThreshold is the level below which leading/trailing music is trimmed.
+	musicSilenceThreshold = "-50dB"
+
+	musicDuckingMute = "mute"
+	musicDuckingDuck = "duck"
+
+	defaultDuckLevelDB   = -18.0
+	defaultDuckAttackMs  = 20.0
+	defaultDuckReleaseMs = 400.0
+	// duckThreshold is the clip audio level (about -50 dB) above which music starts to dip.
+	duckThreshold = 0.003
+	duckRatio     = 20
+
+	defaultAACBitrate  = "192k"
+	losslessAACBitrate = "256k"
+	minAACBitrateKbps  = 96
+	maxAACBitrateKbps  = 320
+)
+
+// findMusicFiles returns the files of -music-tracks when set, otherwise the music files
+// in the working directory (see musicExtensions),
+// matched case-insensitively and sorted by name, without logging.
 func findMusicFiles() ([]string, error) {
-	musicFiles, err := filepath.Glob("*.mp3")
+	if len(activeRun.MusicTracks) > 0 {
+		return musicTrackFiles(activeRun.MusicTracks)
+	}
+	entries, err := os.ReadDir(".")
 	if err != nil {
-		return nil, fmt.Errorf("failed to list mp3 files: %v", err)
+		return nil, fmt.Errorf("failed to list music files: %v", err)
+	}
+	var musicFiles []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if _, ok := musicExtensions[strings.ToLower(filepath.Ext(entry.Name()))]; ok {
+			musicFiles = append(musicFiles, entry.Name())
+		}
 	}
 	sort.Strings(musicFiles)
 	return musicFiles, nil
 }
 
-func createAudioConcatFile(musicFiles []string) (string, error) {
+// getTotalAudioDurationSeconds returns the length of the joined music: the sum of the
+// track excerpts (after silence trimming, when enabled) minus the crossfade overlap
+// between them.
+func getTotalAudioDurationSeconds(musicFiles []string) (float64, error) {
 	if len(musicFiles) == 0 {
-		return "", fmt.Errorf("no music files provided")
+		return 0, fmt.Errorf("no music files provided")
 	}
-	if len(musicFiles) == 1 {
-		return musicFiles[0], nil
+
+	totalDuration := 0.0
+	for i, file := range musicFiles {
+		_, length, err := musicTrackPlayback(i, file)
+		if err != nil {
+			return 0, err
+		}
+		totalDuration += length
 	}
 
-	concatFile := "audio_concat.txt"
-	var content strings.Builder
-	for _, file := range musicFiles {
-		escapedFile := strings.ReplaceAll(file, "'", "'\\''")
-		content.WriteString(fmt.Sprintf("file '%s'\n", escapedFile))
+	return totalDuration - musicCrossfadeOverlap(len(musicFiles)), nil
+}
+
+// musicTrackPlayback returns where the music track at index starts playing in its file
+// and for how long: the -music-tracks excerpt, or the file without its leading and
+// trailing silence with -music-trim-silence. It matches musicTrackFilter, which trims
+// silence from whole files only.
+func musicTrackPlayback(index int, file string) (float64, float64, error) {
+	duration, err := getAudioDurationSeconds(file)
+	if err != nil {
+		return 0, 0, err
 	}
+	start, end := musicExcerpt(index)
+	if activeOptions.Music.TrimSilence && start == 0 && end == 0 {
+		lead, trail := measureTrackSilence(file, duration)
+		return lead, math.Max(duration-lead-trail, 0), nil
+	}
+	return start, musicExcerptLength(index, duration), nil
+}
 
-	if err := os.WriteFile(concatFile, []byte(content.String()), 0644); err != nil {
-		return "", fmt.Errorf("failed to create concat file: %v", err)
+// musicCrossfadeOverlap returns the time lost to crossfades when joining count tracks.
+func musicCrossfadeOverlap(count int) float64 {
+	if count < 2 || activeOptions.Music.Crossfade <= 0 {
+		return 0
 	}
+	return float64(count-1) * activeOptions.Music.Crossfade
+}
 
-	return concatFile, nil
+// measureTrackSilence runs silencedetect over a track and returns the leading and trailing
+// silence in seconds. Errors count as no silence.
+func measureTrackSilence(filename string, duration float64) (float64, float64) {
+	cmd := newExecCommand("ffmpeg", "-v", "info", "-nostats", "-i", filename,
+		"-af", "silencedetect=noise="+musicSilenceThreshold+":d=0.1", "-f", "null", "-")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, 0
+	}
+	return parseSilenceDetect(string(output), duration)
 }
 
-func getTotalAudioDurationSeconds(musicFiles []string) (float64, error) {
-	if len(musicFiles) == 0 {
-		return 0, fmt.Errorf("no music files provided")
+// firstField returns the first whitespace-separated field of value, or "".
+func firstField(value string) string {
+	if fields := strings.Fields(value); len(fields) > 0 {
+		return fields[0]
 	}
+	return ""
+}
 
-	totalDuration := 0.0
-	for _, file := range musicFiles {
-		dur, err := getAudioDurationSeconds(file)
-		if err != nil {
-			return 0, err
+// parseSilenceDetect reads silencedetect log lines and returns the silence at the start
+// and at the end of a track of the given duration.
+func parseSilenceDetect(output string, duration float64) (float64, float64) {
+	const edgeTolerance = 0.05
+	lead, trail := 0.0, 0.0
+	openStart := -1.0
+	for _, line := range strings.Split(output, "\n") {
+		if _, value, ok := strings.Cut(line, "silence_start: "); ok {
+			if start, err := strconv.ParseFloat(firstField(value), 64); err == nil {
+				openStart = start
+			}
+			continue
+		}
+		if _, value, ok := strings.Cut(line, "silence_end: "); ok && openStart >= 0 {
+			end, err := strconv.ParseFloat(firstField(value), 64)
+			if err != nil {
+				continue
+			}
+			if openStart <= edgeTolerance {
+				lead = end
+			}
+			if end >= duration-edgeTolerance {
+				trail = duration - openStart
+			}
+			openStart = -1
 		}
-		totalDuration += dur
 	}
-
-	return totalDuration, nil
+	// A silence still open when the log ends runs to the end of the track.
+	if openStart >= 0 && openStart > lead {
+		trail = duration - openStart
+	}
+	if lead+trail >= duration {
+		return 0, 0
+	}
+	return lead, trail
 }
 
+// getAudioBitrateStr returns the AAC bitrate to encode with, derived from the source audio.
 func getAudioBitrateStr(filename string) string {
 	cmd := newExecCommand("ffprobe", "-v", "error", "-select_streams", "a:0",
-		"-show_entries", "stream=bit_rate", "-of", "default=noprint_wrappers=1:nokey=1", filename)
+		"-show_entries", "stream=codec_name,bit_rate", "-of", "default=noprint_wrappers=1", filename)
 	out, err := cmd.Output()
 	if err != nil {
-		return "192k"
+		return defaultAACBitrate
+	}
+	codec, bitrateStr := "", ""
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "codec_name":
+			codec = value
+		case "bit_rate":
+			bitrateStr = value
+		}
 	}
-	bitrateStr := strings.TrimSpace(string(out))
 	if bitrateStr == "" || bitrateStr == "N/A" {
 		cmd2 := newExecCommand("ffprobe", "-v", "error",
 			"-show_entries", "format=bit_rate", "-of", "default=noprint_wrappers=1:nokey=1", filename)
-		out2, err2 := cmd2.Output()
-		if err2 != nil {
-			return "192k"
+		if out2, err2 := cmd2.Output(); err2 == nil {
+			bitrateStr = strings.TrimSpace(string(out2))
 		}
-		bitrateStr = strings.TrimSpace(string(out2))
 	}
 	bps, err := strconv.Atoi(bitrateStr)
-	if err != nil || bps <= 0 {
-		return "192k"
+	if err != nil {
+		bps = 0
+	}
+	return chooseAACBitrate(codec, bps)
+}
+
+// chooseAACBitrate maps a source codec and bitrate (bits/s, 0 when unknown) to an AAC bitrate.
+// Lossy sources keep their bitrate within 96k-320k; lossless and PCM sources, whose
+// bitrate reflects the uncompressed size, get 256k.
+func chooseAACBitrate(codec string, bps int) string {
+	codec = strings.ToLower(codec)
+	if _, lossless := losslessAudioCodecs[codec]; lossless || strings.HasPrefix(codec, "pcm_") {
+		return losslessAACBitrate
+	}
+	if bps <= 0 {
+		return defaultAACBitrate
 	}
-	return fmt.Sprintf("%dk", bps/1000)
+	kbps := bps / 1000
+	if kbps < minAACBitrateKbps {
+		kbps = minAACBitrateKbps
+	}
+	if kbps > maxAACBitrateKbps {
+		kbps = maxAACBitrateKbps
+	}
+	return fmt.Sprintf("%dk", kbps)
 }
 
 func getMediaDurationSeconds(filename string) (float64, error) {
@@ -161,6 +298,54 @@ func joinFilterInputs(labels []string) string {
 	return builder.String()
 }
 
+// NormalizeMusicDucking validates how music reacts to kept clip audio.
+// An empty value keeps the full mute during clips.
+func NormalizeMusicDucking(mode string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", musicDuckingMute, "off":
+		return musicDuckingMute, nil
+	case musicDuckingDuck, "sidechain":
+		return musicDuckingDuck, nil
+	default:
+		return "", fmt.Errorf("invalid music ducking mode %q. Use mute or duck", mode)
+	}
+}
+
+// validateMusicDucking rejects duck levels above 0 dB and negative attack/release times.
+func validateMusicDucking(options MusicOptions) error {
+	if _, err := NormalizeMusicDucking(options.Ducking); err != nil {
+		return err
+	}
+	if options.DuckLevel > 0 {
+		return fmt.Errorf("duck level must be 0 dB or lower, got %.1f dB", options.DuckLevel)
+	}
+	if options.DuckAttack < 0 || options.DuckRelease < 0 {
+		return fmt.Errorf("duck attack and release must not be negative")
+	}
+	return nil
+}
+
+// buildMusicDuckFilter dips music under the clip audio bus with sidechaincompress.
+// The compressor pushes the music close to silence while clip audio is above
+// duckThreshold, and its dry/wet mix keeps DuckLevel of the original, so the music
+// settles at that level while someone speaks and recovers when the clip goes quiet.
+func buildMusicDuckFilter(musicLabel, sidechainLabel, outputLabel string) string {
+	level, attack, release := activeOptions.Music.DuckLevel, activeOptions.Music.DuckAttack, activeOptions.Music.DuckRelease
+	if level == 0 {
+		level = defaultDuckLevelDB
+	}
+	if attack == 0 {
+		attack = defaultDuckAttackMs
+	}
+	if release == 0 {
+		release = defaultDuckReleaseMs
+	}
+	mix := 1 - math.Pow(10, level/20)
+
+	return fmt.Sprintf("[%s][%s]sidechaincompress=threshold=%g:ratio=%d:attack=%g:release=%g:mix=%.4f[%s]; ",
+		musicLabel, sidechainLabel, duckThreshold, duckRatio, attack, release, mix, outputLabel)
+}
+
 func buildMusicMuteExpression(mediaInputs []MediaInput, offsets []float64, fadeDuration float64) string {
 	var parts []string
 
@@ -244,34 +429,107 @@ func adjustDurationsToMusic(duration, fadeDuration float64, numImages int, audio
 	return newDuration, newFade, true
 }
 
-func setupAudioProcessing(inputs []string, mediaInputs []MediaInput, finalLength, fadeDuration float64, musicFiles []string, keepVideoAudio bool) AudioConfig {
+// musicTrackFilter decodes one music input to 48 kHz stereo, cutting the excerpt of
+// the given track, or trimming leading and trailing silence of a whole file when
+// enabled: an excerpt plays exactly as chosen. The chain is left open for further filters.
+func musicTrackFilter(inputIndex, track int) string {
+	filter := fmt.Sprintf("[%d:a]", inputIndex)
+	excerpt := musicExcerptFilter(track)
+	if excerpt != "" {
+		filter += excerpt + ","
+	}
+	filter += "aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=stereo,aresample=48000"
+	if activeOptions.Music.TrimSilence && excerpt == "" {
+		// Trailing silence is trimmed as leading silence of the reversed track.
+		trim := "silenceremove=start_periods=1:start_threshold=" + musicSilenceThreshold
+		filter += "," + trim + ",areverse," + trim + ",areverse"
+	}
+	return filter
+}
+
+// buildMusicConcatFilter decodes count music inputs starting at firstInput and joins them
+// into outputLabel: back to back, or overlapped with acrossfade when a crossfade is set.
+func buildMusicConcatFilter(firstInput, count int, outputLabel string) string {
+	return buildMusicJoinFilter(firstInput, musicJoinCrossfades(count, 1, 0), outputLabel)
+}
+
+// buildMusicJoinFilter decodes len(crossfades)+1 music inputs starting at firstInput and
+// joins them into outputLabel, each join overlapped by its crossfade (zero is a plain cut).
+func buildMusicJoinFilter(firstInput int, crossfades []float64, outputLabel string) string {
+	var builder strings.Builder
+	count := len(crossfades) + 1
+	labels := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		label := fmt.Sprintf("music%d", i)
+		fmt.Fprintf(&builder, "%s,asetpts=PTS-STARTPTS[%s]; ", musicTrackFilter(firstInput+i, i), label)
+		labels = append(labels, label)
+	}
+
+	anyCrossfade := false
+	for _, crossfade := range crossfades {
+		anyCrossfade = anyCrossfade || crossfade > 0
+	}
+	if !anyCrossfade {
+		fmt.Fprintf(&builder, "%sconcat=n=%d:v=0:a=1[%s]; ", joinFilterInputs(labels), count, outputLabel)
+		return builder.String()
+	}
+
+	current := labels[0]
+	for i := 1; i < count; i++ {
+		next := fmt.Sprintf("musicxf%d", i)
+		if i == count-1 {
+			next = outputLabel
+		}
+		if crossfade := crossfades[i-1]; crossfade > 0 {
+			fmt.Fprintf(&builder, "[%s][%s]acrossfade=d=%s:c1=tri:c2=tri[%s]; ", current, labels[i], formatSeconds(crossfade), next)
+		} else {
+			fmt.Fprintf(&builder, "[%s][%s]concat=n=2:v=0:a=1[%s]; ", current, labels[i], next)
+		}
+		current = next
+	}
+	return builder.String()
+}
+
+func setupAudioProcessing(inputs []string, mediaInputs []MediaInput, finalLength, fadeDuration float64, musicFiles []string, keepVideoAudio bool, fit musicFitPlan, narration []narrationClip) AudioConfig {
 	config := AudioConfig{Inputs: inputs}
 
 	hasMusic := len(musicFiles) > 0
-	musicInputIndex := len(mediaInputs)
+	// Music follows every input already registered (media items and any caption images).
+	musicInputIndex := countFFmpegInputs(inputs)
 
 	if hasMusic {
 		if len(musicFiles) > 1 {
-			fmt.Printf("Audio files found: %d MP3 files\n", len(musicFiles))
+			fmt.Printf("Audio files found: %d music files\n", len(musicFiles))
 			for _, file := range musicFiles {
 				fmt.Printf("  - %s\n", file)
 			}
-
-			concatFile, err := createAudioConcatFile(musicFiles)
-			if err != nil {
-				fmt.Printf("Warning: Failed to create audio concat: %v\n", err)
-				fmt.Printf("Using single audio file: %s\n", musicFiles[0])
-				config.Inputs = append(config.Inputs, "-i", musicFiles[0])
-			} else {
-				config.Inputs = append(config.Inputs, "-f", "concat", "-safe", "0", "-i", concatFile)
-			}
 		} else {
 			fmt.Printf("Audio file found: %s\n", musicFiles[0])
-			config.Inputs = append(config.Inputs, "-i", musicFiles[0])
+		}
+		// Each track is its own input so tracks may differ in codec, sample rate and channels.
+		// Looping repeats the whole list.
+		for loop := 0; loop < max(fit.Loops, 1); loop++ {
+			for _, file := range musicFiles {
+				config.Inputs = append(config.Inputs, "-i", file)
+			}
 		}
 		config.AudioBitrateSource = musicFiles[0]
 	}
 
+	// Narration follows the music inputs
+	narrationInputIndex := countFFmpegInputs(config.Inputs)
+	for _, clip := range narration {
+		config.Inputs = append(config.Inputs, "-i", clip.Path)
+	}
+	narrationBusLabel := ""
+	if len(narration) > 0 {
+		narrationBusLabel = "narrationbus"
+		config.AudioFilter += buildNarrationFilter(narrationInputIndex, narration, finalLength, narrationBusLabel)
+		if config.AudioBitrateSource == "" {
+			config.AudioBitrateSource = narration[0].Path
+		}
+	}
+
 	offsets := buildTimelineOffsets(mediaInputs, fadeDuration)
 	videoAudioLabels := []string{}
 
@@ -286,7 +544,7 @@ func setupAudioProcessing(inputs []string, mediaInputs []MediaInput, finalLength
 			delayMs := int(math.Round(offsets[index] * 1000))
 			label := fmt.Sprintf("clipaudio%d", len(videoAudioLabels))
 
-			config.AudioFilter += fmt.Sprintf("[%d:a]aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=stereo,aresample=48000,atrim=duration=%s,asetpts=PTS-STARTPTS,afade=t=in:st=0:d=%s,afade=t=out:st=%s:d=%s,adelay=%d|%d[%s]; ", index, formatSeconds(media.SegmentDuration), formatSeconds(fadeLength), formatSeconds(fadeOutStart), formatSeconds(fadeLength), delayMs, delayMs, label)
+			config.AudioFilter += fmt.Sprintf("[%d:a]aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=stereo,aresample=48000,atrim=duration=%s,asetpts=PTS-STARTPTS,afade=t=in:st=0:d=%s,afade=t=out:st=%s:d=%s,adelay=%d|%d%s[%s]; ", index, formatSeconds(media.SegmentDuration), formatSeconds(fadeLength), formatSeconds(fadeOutStart), formatSeconds(fadeLength), delayMs, delayMs, gainFilter(activeOptions.Audio.ClipGain), label)
 			videoAudioLabels = append(videoAudioLabels, label)
 
 			if config.AudioBitrateSource == "" {
@@ -311,33 +569,87 @@ func setupAudioProcessing(inputs []string, mediaInputs []MediaInput, finalLength
 
 	var finalAudioLabel string
 	if hasMusic {
-		musicFadeOutStart := finalLength - fadeDuration
+		// Music shorter than the video fades out where it ends
+		musicFadeOutStart := musicEnd(fit, finalLength) - fadeDuration
 		if musicFadeOutStart < 0 {
 			musicFadeOutStart = 0
 		}
 
-		config.AudioFilter += fmt.Sprintf("[%d:a]aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=stereo,aresample=48000,loudnorm=I=-16:TP=-1.5:LRA=11,atrim=duration=%s,asetpts=PTS-STARTPTS,afade=t=in:st=0:d=%s,afade=t=out:st=%s:d=%s[musicout]; ", musicInputIndex, formatSeconds(finalLength), formatSeconds(fadeDuration), formatSeconds(musicFadeOutStart), formatSeconds(fadeDuration))
+		// musicSource is an open chain: joined music passes through anull
+		musicSource := musicTrackFilter(musicInputIndex, 0)
+		if musicSectioned() {
+			// Section items were checked against the timeline in GenerateVideo.
+			starts, end, _ := musicSectionTimes(mediaInputs, fadeDuration, finalLength)
+			config.AudioFilter += buildMusicSectionFilter(musicInputIndex, starts, end, finalLength, musicSectionCrossfade(fadeDuration), "musicjoined")
+			musicSource = "[musicjoined]anull"
+		} else if joins := musicJoinCrossfades(len(musicFiles), fit.Loops, fit.MusicLength); len(joins) > 0 {
+			config.AudioFilter += buildMusicJoinFilter(musicInputIndex, joins, "musicjoined")
+			musicSource = "[musicjoined]anull"
+		}
+		// Unless the video is cut to the music, pad it so the mix lasts the whole video
+		padding := ""
+		if fit.Policy != "" && fit.Policy != musicFitTruncate {
+			padding = "apad,"
+		}
+		config.AudioFilter += fmt.Sprintf("%s%s,%satrim=duration=%s,asetpts=PTS-STARTPTS,afade=t=in:st=0:d=%s,afade=t=out:st=%s:d=%s[musicout]; ", musicSource, gainFilter(activeOptions.Audio.MusicGain), padding, formatSeconds(finalLength), formatSeconds(fadeDuration), formatSeconds(musicFadeOutStart), formatSeconds(fadeDuration))
+
+		musicLabel := "musicout"
+		if narrationBusLabel != "" {
+			// The music always ducks under the voice; the narration joins the mix last.
+			config.AudioFilter += fmt.Sprintf("[%s]asplit=2[narrationmix][narrationsidechain]; ", narrationBusLabel)
+			config.AudioFilter += buildMusicDuckFilter(musicLabel, "narrationsidechain", "musicnarrated")
+			musicLabel = "musicnarrated"
+			narrationBusLabel = "narrationmix"
+		}
 
 		if clipAudioBusLabel != "" {
-			muteExpr := buildMusicMuteExpression(mediaInputs, offsets, fadeDuration)
-			config.AudioFilter += fmt.Sprintf("[musicout]volume='%s':eval=frame[musicmuted]; ", muteExpr)
-			config.AudioFilter += fmt.Sprintf("[musicmuted][%s]amix=inputs=2:duration=first:normalize=0:dropout_transition=%s[mixedaudio]; ", clipAudioBusLabel, formatSeconds(fadeDuration))
+			if ducking, _ := NormalizeMusicDucking(activeOptions.Music.Ducking); ducking == musicDuckingDuck {
+				// The clip bus feeds both the mix and the compressor's sidechain.
+				config.AudioFilter += fmt.Sprintf("[%s]asplit=2[clipmix][clipsidechain]; ", clipAudioBusLabel)
+				config.AudioFilter += buildMusicDuckFilter(musicLabel, "clipsidechain", "musicducked")
+				config.AudioFilter += fmt.Sprintf("[musicducked][clipmix]amix=inputs=2:duration=first:normalize=0:dropout_transition=%s[mixedaudio]; ", formatSeconds(fadeDuration))
+			} else {
+				muteExpr := buildMusicMuteExpression(mediaInputs, offsets, fadeDuration)
+				config.AudioFilter += fmt.Sprintf("[%s]volume='%s':eval=frame[musicmuted]; ", musicLabel, muteExpr)
+				config.AudioFilter += fmt.Sprintf("[musicmuted][%s]amix=inputs=2:duration=first:normalize=0:dropout_transition=%s[mixedaudio]; ", clipAudioBusLabel, formatSeconds(fadeDuration))
+			}
 			finalAudioLabel = "mixedaudio"
 		} else {
-			finalAudioLabel = "musicout"
+			finalAudioLabel = musicLabel
 		}
 	} else if clipAudioBusLabel != "" {
 		finalAudioLabel = clipAudioBusLabel
 	}
 
+	if narrationBusLabel != "" {
+		if finalAudioLabel == "" {
+			finalAudioLabel = narrationBusLabel
+		} else {
+			config.AudioFilter += fmt.Sprintf("[%s][%s]amix=inputs=2:duration=first:normalize=0[narratedaudio]; ", finalAudioLabel, narrationBusLabel)
+			finalAudioLabel = "narratedaudio"
+		}
+	}
+
 	config.HasAudio = finalAudioLabel != ""
 	if config.HasAudio {
 		if !hasMusic && clipAudioBusLabel != "" {
-			fmt.Printf("No MP3 file found - using input video audio only\n")
+			fmt.Printf("No music file found - using input video audio only\n")
+		}
+		// The whole mix is normalized to the loudness target
+		config.MixLabel = finalAudioLabel
+		config.AudioFilter += loudnessStage(finalAudioLabel, nil)
+		config.MapArgs = []string{"-map", "[xfout]", "-map", fmt.Sprintf("[%s]", audioMixLabel)}
+		if fit.Policy == "" || fit.Policy == musicFitTruncate {
+			config.MapArgs = append(config.MapArgs, "-shortest")
 		}
-		config.MapArgs = []string{"-map", "[xfout]", "-map", fmt.Sprintf("[%s]", finalAudioLabel), "-shortest"}
+	} else if activeOptions.Audio.SilentTrack {
+		// A silent stream keeps players and later concatenation happy; there is nothing to normalize.
+		fmt.Printf("No music file found - adding a silent audio track\n")
+		config.HasAudio = true
+		config.AudioFilter += fmt.Sprintf("anullsrc=channel_layout=stereo:sample_rate=%d,atrim=duration=%s[%s]; ", audioSampleRate(), formatSeconds(finalLength), audioMixLabel)
+		config.MapArgs = []string{"-map", "[xfout]", "-map", fmt.Sprintf("[%s]", audioMixLabel)}
 	} else {
-		fmt.Printf("No MP3 file found - generating video without audio\n")
+		fmt.Printf("No music file found - generating video without audio\n")
 		config.MapArgs = []string{"-map", "[xfout]"}
 	}
 
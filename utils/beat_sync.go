@@ -0,0 +1,357 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	beatAnalysisRate = 11025 // Mono sample rate the music is decoded at for analysis
+	beatHopSize      = 256   // Samples per onset envelope frame (about 23 ms)
+	beatMinBPM       = 60.0
+	beatMaxBPM       = 180.0
+	// beatPreferredBPM biases the tempo estimate away from half- and double-time readings.
+	beatPreferredBPM = 120.0
+	// beatTrackingWindow is how far, as a fraction of the period, each beat may move
+	// towards the strongest onset near where the tempo predicts it.
+	beatTrackingWindow = 0.1
+)
+
+// BeatSyncOptions snaps transitions to beats of the background music.
+type BeatSyncOptions struct {
+	Enabled     bool
+	Every       int     // Cut on every Nth beat; zero or one uses every beat
+	MinDuration float64 // Shortest photo hold when snapping; zero uses half of -d
+	MaxDuration float64 // Longest photo hold when snapping; zero uses 1.5 × -d
+}
+
+// validateBeatSync rejects negative settings and a minimum hold above the maximum.
+func validateBeatSync(options BeatSyncOptions) error {
+	if options.Every < 0 || options.MinDuration < 0 || options.MaxDuration < 0 {
+		return fmt.Errorf("beat sync settings must not be negative")
+	}
+	if options.MaxDuration > 0 && options.MinDuration > options.MaxDuration {
+		return fmt.Errorf("beat sync minimum duration %.2fs is above the maximum %.2fs", options.MinDuration, options.MaxDuration)
+	}
+	return nil
+}
+
+// beatCut is one transition chosen by beat sync.
+type beatCut struct {
+	After    int     // Index of the item the transition leaves
+	Original float64 // Transition start before snapping, in seconds
+	Time     float64 // Transition start after snapping, in seconds
+	OnBeat   bool
+}
+
+// decodeMusicSamples decodes the music track at index to mono samples at
+// beatAnalysisRate, cutting its excerpt and trimming silence the same way the render does.
+func decodeMusicSamples(filename string, index int) ([]float64, error) {
+	args := []string{"-v", "error", "-i", filename}
+	var filters []string
+	if excerpt := musicExcerptFilter(index); excerpt != "" {
+		filters = append(filters, excerpt)
+	}
+	if activeOptions.Music.TrimSilence {
+		trim := "silenceremove=start_periods=1:start_threshold=" + musicSilenceThreshold
+		filters = append(filters, trim+",areverse,"+trim+",areverse")
+	}
+	if len(filters) > 0 {
+		args = append(args, "-af", strings.Join(filters, ","))
+	}
+	args = append(args, "-ac", "1", "-ar", fmt.Sprint(beatAnalysisRate), "-f", "s16le", "-acodec", "pcm_s16le", "-")
+	output, err := newExecCommand("ffmpeg", args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s for beat detection: %v", filename, err)
+	}
+
+	samples := make([]float64, len(output)/2)
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(output[2*i:]))) / 32768
+	}
+	return samples, nil
+}
+
+// onsetEnvelope returns the onset strength of each hop: the rise in log energy from the
+// previous hop, zero when the energy falls.
+func onsetEnvelope(samples []float64, hop int) []float64 {
+	frames := len(samples) / hop
+	if frames < 2 {
+		return nil
+	}
+	envelope := make([]float64, frames)
+	previous := 0.0
+	for frame := 0; frame < frames; frame++ {
+		energy := 0.0
+		for _, sample := range samples[frame*hop : (frame+1)*hop] {
+			energy += sample * sample
+		}
+		level := math.Log10(energy/float64(hop) + 1e-10)
+		if frame > 0 && level > previous {
+			envelope[frame] = level - previous
+		}
+		previous = level
+	}
+	return envelope
+}
+
+// estimateBeatPeriod returns the beat period in envelope frames (fractional) from the
+// autocorrelation of the onset envelope, weighted towards beatPreferredBPM.
+// It returns zero when no tempo stands out.
+func estimateBeatPeriod(envelope []float64, hopSeconds float64) float64 {
+	minLag := int(math.Floor(60 / beatMaxBPM / hopSeconds))
+	maxLag := int(math.Ceil(60 / beatMinBPM / hopSeconds))
+	if minLag < 1 || len(envelope) < 4*maxLag {
+		return 0
+	}
+
+	// Periods rarely fall on whole frames: smoothing lets onsets one frame apart correlate.
+	smoothed := make([]float64, len(envelope))
+	for i := range envelope {
+		for offset, weight := range []float64{0.25, 0.5, 0.25} {
+			if j := i + offset - 1; j >= 0 && j < len(envelope) {
+				smoothed[i] += weight * envelope[j]
+			}
+		}
+	}
+
+	correlation := make([]float64, maxLag+2)
+	for lag := minLag - 1; lag <= maxLag+1; lag++ {
+		sum := 0.0
+		for i := lag; i < len(smoothed); i++ {
+			sum += smoothed[i] * smoothed[i-lag]
+		}
+		correlation[lag] = sum
+	}
+
+	best, bestScore := 0, 0.0
+	for lag := minLag; lag <= maxLag; lag++ {
+		bpm := 60 / (float64(lag) * hopSeconds)
+		weight := math.Exp(-0.5 * math.Pow(math.Log2(bpm/beatPreferredBPM), 2))
+		if score := correlation[lag] * weight; score > bestScore {
+			best, bestScore = lag, score
+		}
+	}
+	if best == 0 {
+		return 0
+	}
+
+	// Parabolic interpolation around the peak gives sub-frame precision.
+	left, center, right := correlation[best-1], correlation[best], correlation[best+1]
+	period := float64(best)
+	if denominator := left - 2*center + right; denominator < 0 {
+		period += 0.5 * (left - right) / denominator
+	}
+	return period
+}
+
+// trackBeats places beats every period frames from the phase with the strongest onsets,
+// letting each beat follow the strongest onset within beatTrackingWindow.
+func trackBeats(envelope []float64, period float64) []float64 {
+	phase, phaseScore := 0, -1.0
+	for candidate := 0; candidate < int(period); candidate++ {
+		score := 0.0
+		for position := float64(candidate); int(math.Round(position)) < len(envelope); position += period {
+			score += envelope[int(math.Round(position))]
+		}
+		if score > phaseScore {
+			phase, phaseScore = candidate, score
+		}
+	}
+
+	window := int(math.Max(1, math.Round(period*beatTrackingWindow)))
+	var beats []float64
+	for expected := float64(phase); int(math.Round(expected)) < len(envelope); {
+		center := int(math.Round(expected))
+		position := center
+		for i := max(center-window, 0); i <= min(center+window, len(envelope)-1); i++ {
+			if envelope[i] > envelope[position] {
+				position = i
+			}
+		}
+		beats = append(beats, float64(position))
+		expected = float64(position) + period
+	}
+	return beats
+}
+
+// detectBeats returns beat times in seconds and the tempo in BPM of mono samples.
+// It returns no beats when the music has no clear pulse.
+func detectBeats(samples []float64, rate int) ([]float64, float64) {
+	hopSeconds := float64(beatHopSize) / float64(rate)
+	envelope := onsetEnvelope(samples, beatHopSize)
+	period := estimateBeatPeriod(envelope, hopSeconds)
+	if period <= 0 {
+		return nil, 0
+	}
+	frames := trackBeats(envelope, period)
+	beats := make([]float64, len(frames))
+	for i, frame := range frames {
+		beats[i] = frame * hopSeconds
+	}
+	return beats, 60 / (period * hopSeconds)
+}
+
+// musicBeatTimes detects the beats of every music track and places them on the joined
+// music timeline, accounting for -music-crossfade. It returns the beats and the tempo
+// of each track.
+func musicBeatTimes(musicFiles []string) ([]float64, []float64, error) {
+	var beats, tempos []float64
+	start := 0.0
+	for i, file := range musicFiles {
+		samples, err := decodeMusicSamples(file, i)
+		if err != nil {
+			return nil, nil, err
+		}
+		trackBeats, bpm := detectBeats(samples, beatAnalysisRate)
+		for _, beat := range trackBeats {
+			beats = append(beats, start+beat)
+		}
+		tempos = append(tempos, bpm)
+		start += float64(len(samples))/beatAnalysisRate - math.Max(activeOptions.Music.Crossfade, 0)
+	}
+	return beats, tempos, nil
+}
+
+// beatHoldBounds returns the shortest and longest photo hold beat sync may use.
+func beatHoldBounds(durationSec, fadeSec float64) (float64, float64) {
+	minHold, maxHold := activeOptions.Beats.MinDuration, activeOptions.Beats.MaxDuration
+	if minHold <= 0 {
+		minHold = durationSec * 0.5
+	}
+	if maxHold <= 0 {
+		maxHold = durationSec * 1.5
+	}
+	minHold = math.Max(minHold, fadeSec+0.1)
+	return minHold, math.Max(maxHold, minHold)
+}
+
+// snapCutsToBeats moves each transition that leaves a photo to the nearest usable beat
+// (every activeOptions.Beats.Every-th beat) by changing the photo's hold within the bounds of
+// beatHoldBounds, raised to the photo's narration hold when it has narration. Clips keep their length. Targets follow the original schedule, so
+// snapping errors do not accumulate, and the last photo is held to the original end.
+// It updates mediaInputs and returns every transition with its chosen time.
+func snapCutsToBeats(mediaInputs []MediaInput, fadeSec, durationSec float64, beats []float64) []beatCut {
+	if len(mediaInputs) < 2 {
+		return nil
+	}
+	every := max(activeOptions.Beats.Every, 1)
+	grid := make([]float64, 0, len(beats)/every+1)
+	for i := 0; i < len(beats); i += every {
+		grid = append(grid, beats[i])
+	}
+	minHold, maxHold := beatHoldBounds(durationSec, fadeSec)
+
+	offsets := buildTimelineOffsets(mediaInputs, fadeSec)
+	last := len(mediaInputs) - 1
+	originalEnd := offsets[last] + mediaInputs[last].SegmentDuration
+
+	cuts := make([]beatCut, 0, last)
+	start := 0.0
+	for i := 0; i < last; i++ {
+		media := &mediaInputs[i]
+		original := offsets[i] + media.SegmentDuration - fadeSec
+		cut := beatCut{After: i, Original: original}
+
+		if media.IsImage {
+			// Nearest grid beat to the original cut that keeps the hold within bounds.
+			itemMin := math.Max(minHold, narrationHold(*media, fadeSec))
+			itemMax := math.Max(maxHold, itemMin)
+			bestDistance := math.Inf(1)
+			for _, beat := range grid {
+				hold := beat - start + fadeSec
+				if hold < itemMin || hold > itemMax {
+					continue
+				}
+				if distance := math.Abs(beat - original); distance < bestDistance {
+					bestDistance, cut.Time, cut.OnBeat = distance, beat, true
+				}
+			}
+			if !cut.OnBeat {
+				hold := math.Min(math.Max(original-start+fadeSec, itemMin), itemMax)
+				cut.Time = start + hold - fadeSec
+			}
+			media.SegmentDuration = cut.Time - start + fadeSec
+		} else {
+			cut.Time = start + media.SegmentDuration - fadeSec
+			cut.OnBeat = nearBeat(grid, cut.Time)
+		}
+
+		cuts = append(cuts, cut)
+		start = cut.Time
+	}
+
+	if mediaInputs[last].IsImage {
+		itemMin := math.Max(minHold, narrationHold(mediaInputs[last], fadeSec))
+		mediaInputs[last].SegmentDuration = math.Max(originalEnd-start, itemMin)
+	}
+	return cuts
+}
+
+// nearBeat reports whether t falls within one envelope frame of a grid beat.
+func nearBeat(grid []float64, t float64) bool {
+	tolerance := float64(beatHopSize) / beatAnalysisRate
+	for _, beat := range grid {
+		if math.Abs(beat-t) <= tolerance {
+			return true
+		}
+	}
+	return false
+}
+
+// applyBeatSync detects the beats of the music and snaps the transitions to them.
+// It returns the chosen cuts for the run summary, or nil when beat sync is off or
+// the music has no usable beats.
+func applyBeatSync(mediaInputs []MediaInput, fadeSec, durationSec float64, musicFiles []string) []beatCut {
+	if !activeOptions.Beats.Enabled {
+		return nil
+	}
+	if len(musicFiles) == 0 {
+		fmt.Printf("beat-sync requested but no music file found; keeping item durations.\n")
+		return nil
+	}
+	if musicSectioned() {
+		fmt.Printf("beat-sync skipped: -music-tracks sections move with the item durations.\n")
+		return nil
+	}
+	beats, tempos, err := musicBeatTimes(musicFiles)
+	if err != nil {
+		fmt.Printf("Warning: %v; keeping item durations.\n", err)
+		return nil
+	}
+	if len(beats) == 0 {
+		fmt.Printf("Warning: no clear beat found in the music; keeping item durations.\n")
+		return nil
+	}
+	for i, bpm := range tempos {
+		if bpm > 0 {
+			fmt.Printf("Beat sync: %s at %.1f BPM\n", musicFiles[i], bpm)
+		}
+	}
+	return snapCutsToBeats(mediaInputs, fadeSec, durationSec, beats)
+}
+
+// printBeatSyncSummary lists the chosen cut points.
+func printBeatSyncSummary(cuts []beatCut) {
+	if len(cuts) == 0 {
+		return
+	}
+	onBeat := 0
+	for _, cut := range cuts {
+		if cut.OnBeat {
+			onBeat++
+		}
+	}
+	every := max(activeOptions.Beats.Every, 1)
+	fmt.Printf("\nBeat-synced cuts: %d of %d on the beat (every %d beat(s))\n", onBeat, len(cuts), every)
+	for _, cut := range cuts {
+		marker := "beat"
+		if !cut.OnBeat {
+			marker = "off-beat"
+		}
+		fmt.Printf("  %d → %d: %s (was %s, %s)\n", cut.After+1, cut.After+2,
+			formatSubtitleTimestamp(cut.Time, "."), formatSubtitleTimestamp(cut.Original, "."), marker)
+	}
+}
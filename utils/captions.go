@@ -0,0 +1,406 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+)
+
+const (
+	// captionWidthRatio is the share of the canvas width a caption line may use.
+	captionWidthRatio = 0.8
+	// captionLineSpacing is the line height as a multiple of the font size.
+	captionLineSpacing = 1.3
+
+	iptcCaptionDataset = 120 // IPTC IIM 2:120 Caption/Abstract
+)
+
+var (
+	xmpDescriptionAltPattern  = regexp.MustCompile(`(?s)<dc:description[^>]*>.*?<rdf:li[^>]*>(.*?)</rdf:li>`)
+	xmpDescriptionAttrPattern = regexp.MustCompile(`dc:description="([^"]*)"`)
+)
+
+// defaultCaptionFontSize returns the caption font size used when none is configured.
+func defaultCaptionFontSize() int {
+	if activeResolution == resolutionFullHD {
+		return 40
+	}
+	if activeResolution == resolutionPreview {
+		return 14
+	}
+	return 64
+}
+
+// captionFontSize returns the configured caption font size or the resolution default.
+func captionFontSize() int {
+	if activeOptions.Captions.FontSize > 0 {
+		return activeOptions.Captions.FontSize
+	}
+	return defaultCaptionFontSize()
+}
+
+// activeCanvasSize returns the output width and height for the current run.
+func activeCanvasSize() (int, int) {
+	parts := strings.SplitN(activeResolution, "x", 2)
+	if len(parts) == 2 {
+		w, err1 := strconv.Atoi(parts[0])
+		h, err2 := strconv.Atoi(parts[1])
+		if err1 == nil && err2 == nil {
+			return w, h
+		}
+	}
+	return 3840, 2160
+}
+
+// readCaptionText returns the human caption of an image. The first non-empty source wins:
+// a sidecar text file (photo.jpg.txt, then photo.txt), the XMP dc:description,
+// then the IPTC Caption/Abstract. Clips only use sidecars (see mediaCaptionText).
+func readCaptionText(filename string) string {
+	if text := readSidecarCaption(filename); text != "" {
+		return text
+	}
+	if text := xmpDescription(readXMPPacket(filename)); text != "" {
+		return text
+	}
+	return readIPTCCaption(filename)
+}
+
+// readSidecarCaption reads the caption from a text file next to the media file.
+func readSidecarCaption(filename string) string {
+	candidates := []string{filename + ".txt"}
+	if ext := filepath.Ext(filename); ext != "" && !strings.EqualFold(ext, ".txt") {
+		candidates = append(candidates, strings.TrimSuffix(filename, ext)+".txt")
+	}
+	for _, candidate := range candidates {
+		data, err := os.ReadFile(candidate)
+		if err != nil {
+			continue
+		}
+		data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+		if text := normalizeCaptionText(decodeCaptionBytes(data)); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// xmpDescription extracts dc:description from an XMP packet, written either as an
+// rdf:Alt language list (the first entry, normally x-default) or as an attribute.
+func xmpDescription(packet string) string {
+	if packet == "" {
+		return ""
+	}
+	if match := xmpDescriptionAltPattern.FindStringSubmatch(packet); match != nil {
+		return normalizeCaptionText(unescapeXML(match[1]))
+	}
+	if match := xmpDescriptionAttrPattern.FindStringSubmatch(packet); match != nil {
+		return normalizeCaptionText(unescapeXML(match[1]))
+	}
+	return ""
+}
+
+// readIPTCCaption returns the IPTC IIM Caption/Abstract (2:120) stored in the
+// Photoshop APP13 segment of a JPEG, or "" when there is none.
+func readIPTCCaption(filename string) string {
+	data, err := readFileHeader(filename, metadataHeaderSize)
+	if err != nil || len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return ""
+	}
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return ""
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 { // Start of scan / end of image: no more metadata
+			return ""
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return ""
+		}
+		if marker == 0xED {
+			if text := parsePhotoshopIPTCCaption(data[pos+4 : end]); text != "" {
+				return text
+			}
+		}
+		pos = end
+	}
+	return ""
+}
+
+// parsePhotoshopIPTCCaption walks the 8BIM resources of an APP13 payload and
+// returns the caption from the IPTC-NAA resource (0x0404).
+func parsePhotoshopIPTCCaption(segment []byte) string {
+	const header = "Photoshop 3.0\x00"
+	if !bytes.HasPrefix(segment, []byte(header)) {
+		return ""
+	}
+	pos := len(header)
+	for pos+12 <= len(segment) {
+		if string(segment[pos:pos+4]) != "8BIM" {
+			return ""
+		}
+		resourceID := binary.BigEndian.Uint16(segment[pos+4 : pos+6])
+		pos += 6
+
+		// Pascal string name, padded to an even length including the length byte.
+		nameLength := int(segment[pos]) + 1
+		if nameLength%2 != 0 {
+			nameLength++
+		}
+		pos += nameLength
+		if pos+4 > len(segment) {
+			return ""
+		}
+		size := int(binary.BigEndian.Uint32(segment[pos : pos+4]))
+		pos += 4
+		if size < 0 || pos+size > len(segment) {
+			return ""
+		}
+		if resourceID == 0x0404 {
+			return parseIPTCCaption(segment[pos : pos+size])
+		}
+		pos += size
+		if size%2 != 0 {
+			pos++
+		}
+	}
+	return ""
+}
+
+// parseIPTCCaption reads the Caption/Abstract dataset from IPTC IIM records.
+// Text is UTF-8 when the 1:90 coded character set says so or when it is valid UTF-8,
+// otherwise it is treated as Latin-1.
+func parseIPTCCaption(records []byte) string {
+	utf8Declared := false
+	caption := []byte(nil)
+	pos := 0
+	for pos+5 <= len(records) && records[pos] == 0x1C {
+		record, dataset := records[pos+1], records[pos+2]
+		size := int(binary.BigEndian.Uint16(records[pos+3 : pos+5]))
+		pos += 5
+		if size&0x8000 != 0 || pos+size > len(records) { // Extended datasets are not used for captions
+			break
+		}
+		value := records[pos : pos+size]
+		pos += size
+
+		if record == 1 && dataset == 90 && bytes.Equal(value, []byte("\x1b%G")) {
+			utf8Declared = true
+		}
+		if record == 2 && dataset == iptcCaptionDataset && caption == nil {
+			caption = value
+		}
+	}
+	if caption == nil {
+		return ""
+	}
+	if utf8Declared {
+		return normalizeCaptionText(strings.ToValidUTF8(string(caption), ""))
+	}
+	return normalizeCaptionText(decodeCaptionBytes(caption))
+}
+
+// decodeCaptionBytes returns UTF-8 text as is and converts anything else from Latin-1.
+func decodeCaptionBytes(data []byte) string {
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// normalizeCaptionText trims each line, normalises line endings and drops blank lines.
+func normalizeCaptionText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	var kept []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// wrapCaptionText breaks text into lines no wider than maxWidth as reported by measure.
+// Explicit line breaks are kept; words longer than a line are split between runes,
+// so multi-byte characters and emoji are never cut in half.
+func wrapCaptionText(text string, maxWidth int, measure func(string) int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		current := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if current != "" {
+				candidate = current + " " + word
+			}
+			if measure(candidate) <= maxWidth {
+				current = candidate
+				continue
+			}
+			if current != "" {
+				lines = append(lines, current)
+			}
+			current = ""
+			for measure(word) > maxWidth {
+				head, tail := splitCaptionWord(word, maxWidth, measure)
+				lines = append(lines, head)
+				word = tail
+			}
+			current = word
+		}
+		if current != "" {
+			lines = append(lines, current)
+		}
+	}
+	return lines
+}
+
+// splitCaptionWord returns the longest rune prefix of word that fits maxWidth (at least one rune) and the rest.
+func splitCaptionWord(word string, maxWidth int, measure func(string) int) (string, string) {
+	cut := 0
+	for i, r := range word {
+		next := i + utf8.RuneLen(r)
+		if cut > 0 && measure(word[:next]) > maxWidth {
+			break
+		}
+		cut = next
+	}
+	return word[:cut], word[cut:]
+}
+
+// estimateDrawtextWidth approximates the rendered width of text for drawtext, where
+// the font metrics are not known in advance. Wide (CJK, emoji) runes count as a full em.
+func estimateDrawtextWidth(fontSize int) func(string) int {
+	return func(text string) int {
+		width := 0.0
+		for _, r := range text {
+			if r >= 0x1100 {
+				width += float64(fontSize)
+			} else {
+				width += float64(fontSize) * 0.55
+			}
+		}
+		return int(width + 0.5)
+	}
+}
+
+// captionLines wraps a caption to the canvas width using the metrics of the active renderer.
+func captionLines(text string, fontSize int) []string {
+	canvasWidth, _ := activeCanvasSize()
+	maxWidth := int(float64(canvasWidth) * captionWidthRatio)
+
+	measure := estimateDrawtextWidth(fontSize)
+	if useImageOverlayRenderer() {
+		if parsedFont, err := loadOverlayFont(strings.TrimSpace(activeOptions.Overlay.FontFile)); err == nil {
+			if face, err := opentype.NewFace(parsedFont, &opentype.FaceOptions{Size: float64(fontSize), DPI: 72, Hinting: font.HintingFull}); err == nil {
+				defer face.Close()
+				measure = func(s string) int { return font.MeasureString(face, s).Ceil() }
+			}
+		}
+	}
+	return wrapCaptionText(text, maxWidth, measure)
+}
+
+// mediaCaptionText returns the description caption of a timeline item.
+// Converted images are traced back to their original file first; clips are not read,
+// only their sidecar text file.
+func mediaCaptionText(media MediaInput) string {
+	if media.IsImage {
+		originalFile := originalFilename(media.Path)
+		if originalFile == "" {
+			return ""
+		}
+		return readCaptionText(originalFile)
+	}
+	return readSidecarCaption(media.Path)
+}
+
+// captionBottomExpression is the y coordinate of the caption's bottom edge: the lower third,
+// one eighth of the frame above the bottom so it stays clear of a footer EXIF overlay.
+func captionBottomExpression(frameH string) string {
+	return fmt.Sprintf("%s-%s/8", frameH, frameH)
+}
+
+// formatCaptionDrawtext returns one centred drawtext filter per caption line, stacked
+// upwards from the lower third. Each line is read from converted/description_<index>_<line>.txt;
+// if that file cannot be written the line is escaped inline instead.
+func formatCaptionDrawtext(lines []string, fontSize, index int, duration, fadeDuration float64) string {
+	lineHeight := int(float64(fontSize)*captionLineSpacing + 0.5)
+	var filters strings.Builder
+	for i, line := range lines {
+		yPosition := fmt.Sprintf("%s-%d", captionBottomExpression("h"), (len(lines)-i)*lineHeight)
+		style := buildDrawtextStyleAt(fontSize, duration, fadeDuration, "(w-tw)/2", yPosition)
+
+		textFile := generatedFile(fmt.Sprintf("converted/description_%d_%d.txt", index, i))
+		if err := os.WriteFile(textFile, []byte(line), 0644); err != nil {
+			fmt.Fprintf(&filters, ",drawtext=expansion=none:text=%s:%s", escapeDrawtextText(line), style)
+			continue
+		}
+		fmt.Fprintf(&filters, ",drawtext=expansion=none:textfile='%s':%s", textFile, style)
+	}
+	return filters.String()
+}
+
+// prepareDescriptionImage renders caption lines to converted/description_<index>.png.
+// It returns the PNG path, or "" when rendering failed.
+func prepareDescriptionImage(lines []string, fontSize, index int) string {
+	descriptionFile := generatedFile(fmt.Sprintf("converted/description_%d.png", index))
+	if err := os.MkdirAll(filepath.Dir(descriptionFile), os.ModePerm); err != nil {
+		fmt.Printf("Warning: failed to create caption folder: %v\n", err)
+		return ""
+	}
+	if err := renderCaptionImage(strings.Join(lines, "\n"), fontSize, descriptionFile); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return ""
+	}
+	return descriptionFile
+}
+
+// applyDescriptionCaption adds the description caption of a timeline item to its video filter.
+// With the image renderer the caption PNG is appended to extraInputs, whose first input has
+// index firstExtraInput. It returns the updated filter and extra inputs.
+func applyDescriptionCaption(videoFilter string, media MediaInput, index int, fadeSec float64, firstExtraInput int, extraInputs []string) (string, []string) {
+	text := mediaCaptionText(media)
+	if text == "" {
+		return videoFilter, extraInputs
+	}
+	fontSize := captionFontSize()
+	lines := captionLines(text, fontSize)
+	if len(lines) == 0 {
+		return videoFilter, extraInputs
+	}
+
+	if !useImageOverlayRenderer() {
+		return videoFilter + formatCaptionDrawtext(lines, fontSize, index, media.SegmentDuration, fadeSec), extraInputs
+	}
+
+	descriptionFile := prepareDescriptionImage(lines, fontSize, index)
+	if descriptionFile == "" {
+		return videoFilter, extraInputs
+	}
+	inputIndex := firstExtraInput + countFFmpegInputs(extraInputs)
+	extraInputs = append(extraInputs, "-loop", "1", "-t", formatSeconds(media.SegmentDuration), "-i", descriptionFile)
+	baseLabel := fmt.Sprintf("desc%d", index)
+	yPosition := fmt.Sprintf("%s-h", captionBottomExpression("H"))
+	videoFilter = fmt.Sprintf("%s[%s]; %s", videoFilter, baseLabel,
+		buildImageOverlayFilter(baseLabel, inputIndex, media.SegmentDuration, fadeSec, "(W-w)/2", yPosition))
+	return videoFilter, extraInputs
+}
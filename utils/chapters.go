@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	chapterModeNone    = "none"
+	chapterModeFolder  = "folder"
+	chapterModeDay     = "day"
+	chapterModeCluster = "cluster"
+	chapterModeEvery   = "every"
+
+	defaultChapterEvery = 10
+	defaultChapterGap   = 2 * time.Hour
+
+	// chapterMetadataFile is the temporary ffmetadata input carrying the MP4 chapters.
+	chapterMetadataFile = "chapters_metadata.txt"
+	// youTubeChaptersFile lists the chapters in YouTube description format.
+	youTubeChaptersFile = "chapters.txt"
+
+	youTubeMinChapters       = 3
+	youTubeMinChapterSeconds = 10
+)
+
+// Chapter is a named section of the output video, in seconds from the start.
+type Chapter struct {
+	Title string
+	Start float64
+	End   float64
+}
+
+// NormalizeChapterMode validates a chapter boundary mode.
+// An empty value disables chapters.
+func NormalizeChapterMode(mode string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", chapterModeNone, "off":
+		return chapterModeNone, nil
+	case chapterModeFolder:
+		return chapterModeFolder, nil
+	case chapterModeDay, "date":
+		return chapterModeDay, nil
+	case chapterModeCluster, "event":
+		return chapterModeCluster, nil
+	case chapterModeEvery, "items":
+		return chapterModeEvery, nil
+	default:
+		return "", fmt.Errorf("invalid chapter mode %q. Use none, folder, day, cluster, or every", mode)
+	}
+}
+
+// folderChapterTitle names a chapter after the folder containing the source file.
+func folderChapterTitle(path string) string {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return filepath.Dir(path)
+	}
+	return filepath.Base(dir)
+}
+
+// buildChapters groups timeline items into chapters using the active chapter mode.
+// Chapter starts come from offsets (see buildTimelineOffsets); the last chapter ends at finalLength.
+// Items that carry no boundary information (title/credits cards, items without a capture
+// time) stay in the surrounding chapter, and the first chapter always starts at 0.
+func buildChapters(mediaInputs []MediaInput, offsets []float64, finalLength float64) []Chapter {
+	mode, err := NormalizeChapterMode(activeOptions.Chapters.Mode)
+	if err != nil || mode == chapterModeNone || len(mediaInputs) == 0 {
+		return nil
+	}
+
+	every := activeOptions.Chapters.Every
+	if every <= 0 {
+		every = defaultChapterEvery
+	}
+	gap := activeOptions.Chapters.Gap
+	if gap <= 0 {
+		gap = defaultChapterGap
+	}
+
+	var chapters []Chapter
+	currentKey := ""
+	contentIndex := 0
+	var lastCapture time.Time
+
+	for i, media := range mediaInputs {
+		key, title := "", ""
+		if !media.IsCard {
+			switch mode {
+			case chapterModeFolder:
+				source := mediaSourcePath(media)
+				key = filepath.Dir(source)
+				title = folderChapterTitle(source)
+			case chapterModeDay:
+				if media.HasCapturedAt {
+					key = media.CapturedAt.Format("2006-01-02")
+					title = media.CapturedAt.Format("02/01/2006")
+				}
+			case chapterModeCluster:
+				if media.HasCapturedAt {
+					key = currentKey
+					if lastCapture.IsZero() || media.CapturedAt.Sub(lastCapture) > gap || lastCapture.Sub(media.CapturedAt) > gap {
+						key = media.CapturedAt.Format(time.RFC3339)
+						title = media.CapturedAt.Format("02/01/2006 15:04")
+					}
+					lastCapture = media.CapturedAt
+				}
+			case chapterModeEvery:
+				key = strconv.Itoa(contentIndex / every)
+				title = fmt.Sprintf("Part %d", contentIndex/every+1)
+			}
+			contentIndex++
+		}
+
+		switch {
+		case len(chapters) == 0:
+			chapters = append(chapters, Chapter{Title: title, Start: 0})
+			currentKey = key
+		case key != "" && currentKey == "":
+			// The opening chapter so far only held cards or undated items.
+			chapters[len(chapters)-1].Title = title
+			currentKey = key
+		case key != "" && key != currentKey:
+			chapters = append(chapters, Chapter{Title: title, Start: offsets[i]})
+			currentKey = key
+		}
+	}
+
+	for i := range chapters {
+		if i+1 < len(chapters) {
+			chapters[i].End = chapters[i+1].Start
+		} else {
+			chapters[i].End = finalLength
+		}
+		if chapters[i].Title == "" {
+			chapters[i].Title = fmt.Sprintf("Chapter %d", i+1)
+		}
+	}
+	return chapters
+}
+
+// escapeFFMetadata escapes the characters with special meaning in ffmetadata files.
+func escapeFFMetadata(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n").Replace(value)
+}
+
+// formatFFMetadataChapters renders chapters as an ffmetadata file with millisecond timestamps.
+func formatFFMetadataChapters(chapters []Chapter) string {
+	var builder strings.Builder
+	builder.WriteString(";FFMETADATA1\n")
+	for _, chapter := range chapters {
+		builder.WriteString("\n[CHAPTER]\nTIMEBASE=1/1000\n")
+		fmt.Fprintf(&builder, "START=%d\n", int64(math.Round(chapter.Start*1000)))
+		fmt.Fprintf(&builder, "END=%d\n", int64(math.Round(chapter.End*1000)))
+		fmt.Fprintf(&builder, "title=%s\n", escapeFFMetadata(chapter.Title))
+	}
+	return builder.String()
+}
+
+// formatYouTubeTimestamp formats seconds as MM:SS, or H:MM:SS from one hour on.
+func formatYouTubeTimestamp(seconds float64) string {
+	total := int(math.Floor(seconds))
+	hours, minutes, secs := total/3600, (total%3600)/60, total%60
+	if hours > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, minutes, secs)
+	}
+	return fmt.Sprintf("%02d:%02d", minutes, secs)
+}
+
+// formatYouTubeChapters renders chapters as "00:00 Title" lines for a video description.
+func formatYouTubeChapters(chapters []Chapter) string {
+	var builder strings.Builder
+	for _, chapter := range chapters {
+		fmt.Fprintf(&builder, "%s %s\n", formatYouTubeTimestamp(chapter.Start), chapter.Title)
+	}
+	return builder.String()
+}
+
+// prepareChapters writes the chapter files for the timeline and returns the extra FFmpeg
+// input arguments (the ffmetadata file) and output arguments (-map_chapters).
+// inputIndex is the index the metadata input will have. It returns nil slices when chapters are off.
+func prepareChapters(mediaInputs []MediaInput, fadeSec, finalLength float64, inputIndex int) ([]string, []string) {
+	chapters := buildChapters(mediaInputs, buildTimelineOffsets(mediaInputs, fadeSec), finalLength)
+	if len(chapters) == 0 {
+		return nil, nil
+	}
+
+	youTubeFile, metadataFile := generatedFile(youTubeChaptersFile), generatedFile(chapterMetadataFile)
+	if err := os.WriteFile(youTubeFile, []byte(formatYouTubeChapters(chapters)), 0644); err != nil {
+		fmt.Printf("Warning: failed to write %s: %v\n", youTubeFile, err)
+	} else {
+		fmt.Printf("Chapters: %d (YouTube list written to %s)\n", len(chapters), youTubeFile)
+	}
+	if len(chapters) < youTubeMinChapters {
+		fmt.Printf("Note: YouTube only shows chapters when there are at least %d.\n", youTubeMinChapters)
+	}
+	for _, chapter := range chapters {
+		if chapter.End-chapter.Start < youTubeMinChapterSeconds {
+			fmt.Printf("Note: chapter %q is shorter than %ds; YouTube may ignore the chapter list.\n", chapter.Title, youTubeMinChapterSeconds)
+			break
+		}
+	}
+
+	if err := os.WriteFile(metadataFile, []byte(formatFFMetadataChapters(chapters)), 0644); err != nil {
+		fmt.Printf("Warning: failed to write chapter metadata, MP4 will have no chapters: %v\n", err)
+		return nil, nil
+	}
+	return []string{"-f", "ffmetadata", "-i", metadataFile}, []string{"-map_chapters", strconv.Itoa(inputIndex)}
+}
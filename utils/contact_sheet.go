@@ -0,0 +1,240 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	defaultContactSheetColumns = 5
+	defaultContactSheetRows    = 6
+
+	contactSheetThumbWidth  = 384
+	contactSheetThumbHeight = 216
+	contactSheetPadding     = 16
+	contactSheetHeaderSize  = 22
+	contactSheetLabelSize   = 16
+	contactSheetLabelLines  = 3
+)
+
+var (
+	contactSheetBackground  = color.NRGBA{32, 32, 32, 255}
+	contactSheetPlaceholder = color.NRGBA{64, 64, 64, 255}
+	contactSheetDimmed      = color.NRGBA{170, 170, 170, 255}
+)
+
+// contactSheetEntry is one timeline item on the sheet: its thumbnail and label lines.
+type contactSheetEntry struct {
+	Thumbnail image.Image
+	Labels    []string
+}
+
+// contactSheetFilenames returns the sheet paths for an output video: <video>_contact_sheet.png,
+// or <video>_contact_sheet_01.png, _02.png... when the timeline spans several pages.
+func contactSheetFilenames(outputFilename string, pages int) []string {
+	base := strings.TrimSuffix(outputFilename, filepath.Ext(outputFilename)) + "_contact_sheet"
+	if pages <= 1 {
+		return []string{base + ".png"}
+	}
+	names := make([]string, pages)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s_%02d.png", base, i+1)
+	}
+	return names
+}
+
+// contactSheetLabels describes a manifest item: index and start time, duration and type,
+// and the file name (the original photo when known).
+func contactSheetLabels(item TimelineManifestItem) []string {
+	name := filepath.Base(item.Source)
+	if item.Original != "" {
+		name = filepath.Base(item.Original)
+	}
+	return []string{
+		fmt.Sprintf("#%d  %s", item.Index, item.StartTimecode),
+		fmt.Sprintf("%.2fs · %s", item.SegmentDuration, item.Type),
+		name,
+	}
+}
+
+// grabVideoFrame decodes a single frame of a clip at the given time, scaled to width.
+func grabVideoFrame(path string, at float64, width int) (image.Image, error) {
+	cmd := newExecCommand("ffmpeg", "-v", "error", "-ss", formatSeconds(at), "-i", path,
+		"-frames:v", "1", "-vf", fmt.Sprintf("scale=%d:-2", width), "-f", "image2pipe", "-vcodec", "png", "-")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to grab a frame from %s: %v", path, err)
+	}
+	frame, err := png.Decode(bytes.NewReader(output))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the frame from %s: %v", path, err)
+	}
+	return frame, nil
+}
+
+// contactSheetThumbnail returns the thumbnail of a timeline item: the converted still
+// (or the original photo before conversion) or a frame from the middle of a clip.
+// It returns nil when no picture is available.
+func contactSheetThumbnail(media MediaInput) image.Image {
+	var source image.Image
+	var err error
+	if media.IsImage {
+		source, err = imaging.Open(media.Path, imaging.AutoOrientation(true))
+		if err != nil && !media.IsCard {
+			source, err = imaging.Open(mediaSourcePath(media), imaging.AutoOrientation(true))
+		}
+	} else {
+		source, err = grabVideoFrame(media.Path, media.SegmentDuration/2, contactSheetThumbWidth)
+	}
+	if err != nil || source == nil {
+		return nil
+	}
+	return imaging.Fit(source, contactSheetThumbWidth, contactSheetThumbHeight, imaging.Lanczos)
+}
+
+// fitLabel shortens text with an ellipsis until it fits maxWidth.
+func fitLabel(face font.Face, text string, maxWidth int) string {
+	if font.MeasureString(face, text).Ceil() <= maxWidth {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		candidate := string(runes) + "…"
+		if font.MeasureString(face, candidate).Ceil() <= maxWidth {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// contactSheetLineHeight returns the line height for a font size, spaced like captions.
+func contactSheetLineHeight(size int) int {
+	return int(float64(size)*captionLineSpacing + 0.5)
+}
+
+// renderContactSheetPage draws a grid of entries under a header line.
+func renderContactSheetPage(entries []contactSheetEntry, columns int, header string, headerFace, labelFace font.Face) *image.NRGBA {
+	rows := (len(entries) + columns - 1) / columns
+	headerHeight := contactSheetLineHeight(contactSheetHeaderSize) + contactSheetPadding
+	lineHeight := contactSheetLineHeight(contactSheetLabelSize)
+	cellHeight := contactSheetThumbHeight + contactSheetLabelLines*lineHeight + contactSheetPadding/2
+
+	width := columns*contactSheetThumbWidth + (columns+1)*contactSheetPadding
+	height := headerHeight + rows*cellHeight + (rows+1)*contactSheetPadding
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{contactSheetBackground}, image.Point{}, draw.Src)
+
+	drawText := func(face font.Face, c color.Color, text string, x, baseline int) {
+		drawer := &font.Drawer{Dst: img, Src: &image.Uniform{c}, Face: face, Dot: fixed.P(x, baseline)}
+		drawer.DrawString(text)
+	}
+	drawText(headerFace, color.White, fitLabel(headerFace, header, width-2*contactSheetPadding),
+		contactSheetPadding, contactSheetPadding+headerFace.Metrics().Ascent.Ceil())
+
+	for i, entry := range entries {
+		x := contactSheetPadding + (i%columns)*(contactSheetThumbWidth+contactSheetPadding)
+		y := headerHeight + contactSheetPadding + (i/columns)*(cellHeight+contactSheetPadding)
+
+		box := image.Rect(x, y, x+contactSheetThumbWidth, y+contactSheetThumbHeight)
+		if entry.Thumbnail == nil {
+			draw.Draw(img, box, &image.Uniform{contactSheetPlaceholder}, image.Point{}, draw.Src)
+		} else {
+			draw.Draw(img, box, &image.Uniform{color.Black}, image.Point{}, draw.Src)
+			bounds := entry.Thumbnail.Bounds()
+			offset := image.Pt(x+(contactSheetThumbWidth-bounds.Dx())/2, y+(contactSheetThumbHeight-bounds.Dy())/2)
+			draw.Draw(img, bounds.Sub(bounds.Min).Add(offset), entry.Thumbnail, bounds.Min, draw.Over)
+		}
+
+		baseline := y + contactSheetThumbHeight + contactSheetPadding/2 + labelFace.Metrics().Ascent.Ceil()
+		for line, label := range entry.Labels {
+			c := color.Color(color.White)
+			if line > 0 {
+				c = contactSheetDimmed
+			}
+			drawText(labelFace, c, fitLabel(labelFace, label, contactSheetThumbWidth), x, baseline+line*lineHeight)
+		}
+	}
+	return img
+}
+
+// writeContactSheet renders thumbnails of every timeline item, in order, with index,
+// start time, duration and file name, and writes them next to the output video.
+// It returns the paths written.
+func writeContactSheet(mediaInputs []MediaInput, fadeSec, finalLength float64, outputFilename string, applyKenBurns, keepVideoAudio bool) ([]string, error) {
+	if len(mediaInputs) == 0 {
+		return nil, nil
+	}
+	columns := activeOptions.ContactSheet.Columns
+	if columns <= 0 {
+		columns = defaultContactSheetColumns
+	}
+	rows := activeOptions.ContactSheet.Rows
+	if rows <= 0 {
+		rows = defaultContactSheetRows
+	}
+	perPage := columns * rows
+	if columns > len(mediaInputs) {
+		columns = len(mediaInputs)
+	}
+	pages := (len(mediaInputs) + perPage - 1) / perPage
+
+	parsedFont, err := loadOverlayFont(strings.TrimSpace(activeOptions.Overlay.FontFile))
+	if err != nil {
+		return nil, err
+	}
+	headerFace, err := opentype.NewFace(parsedFont, &opentype.FaceOptions{Size: contactSheetHeaderSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create contact sheet font face: %v", err)
+	}
+	defer headerFace.Close()
+	labelFace, err := opentype.NewFace(parsedFont, &opentype.FaceOptions{Size: contactSheetLabelSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create contact sheet font face: %v", err)
+	}
+	defer labelFace.Close()
+
+	manifest := buildTimelineManifest(mediaInputs, fadeSec, finalLength, outputFilename, applyKenBurns, keepVideoAudio)
+	filenames := contactSheetFilenames(outputFilename, pages)
+	for page := 0; page < pages; page++ {
+		first := page * perPage
+		last := min(first+perPage, len(mediaInputs))
+
+		entries := make([]contactSheetEntry, 0, last-first)
+		for i := first; i < last; i++ {
+			entries = append(entries, contactSheetEntry{
+				Thumbnail: contactSheetThumbnail(mediaInputs[i]),
+				Labels:    contactSheetLabels(manifest.Items[i]),
+			})
+		}
+
+		header := fmt.Sprintf("%s · %d items · %s", filepath.Base(outputFilename), len(mediaInputs), formatYouTubeTimestamp(finalLength))
+		if pages > 1 {
+			header += fmt.Sprintf(" · page %d/%d", page+1, pages)
+		}
+		img := renderContactSheetPage(entries, columns, header, headerFace, labelFace)
+
+		file, err := os.Create(filenames[page])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create contact sheet %s: %v", filenames[page], err)
+		}
+		err = png.Encode(file, img)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode contact sheet %s: %v", filenames[page], err)
+		}
+	}
+	return filenames, nil
+}
@@ -16,14 +16,16 @@ import (
 
 // CameraInfo contains EXIF data about the camera and photo settings
 type CameraInfo struct {
-	Make         string // Camera manufacturer
-	Model        string // Camera model
-	LensModel    string // Lens model
-	FocalLength  string // Focal length (e.g., "50mm")
-	ISO          string // ISO speed (e.g., "400")
-	ExposureTime string // Shutter speed (e.g., "1/125s")
-	FNumber      string // Aperture (e.g., "f/2.8")
-	DateTaken    string // Date the photo was taken (DD/MM/YYYY)
+	Make         string    // Camera manufacturer
+	Model        string    // Camera model
+	LensModel    string    // Lens model
+	FocalLength  string    // Focal length (e.g., "50mm")
+	ISO          string    // ISO speed (e.g., "400")
+	ExposureTime string    // Shutter speed (e.g., "1/125s")
+	FNumber      string    // Aperture (e.g., "f/2.8")
+	DateTaken    string    // Date the photo was taken (DD/MM/YYYY)
+	CapturedAt   time.Time // Capture time, zero when EXIF has no date
+	Location     string    // GPS coordinates (e.g., "38.6916°N, 9.2160°W")
 }
 
 // ConvertImages processes each .jpg file in the working directory, applies scaling,
@@ -40,35 +42,30 @@ func ConvertImages(fullHD bool) error {
 		imageSuffix = "fhd"
 	}
 
-	// Check if "converted" directory already exists.
-	if _, err := os.Stat("converted"); err == nil {
-		convertedFiles, globErr := filepath.Glob(filepath.Join("converted", "*.jpg"))
-		if globErr != nil {
-			return fmt.Errorf("failed to inspect converted images: %v", globErr)
-		}
-
-		if len(convertedFiles) > 0 {
-			sampleImg, openErr := imaging.Open(convertedFiles[0], imaging.AutoOrientation(true))
-			if openErr != nil {
-				fmt.Printf("Converted images are unreadable (%v), regenerating for %s...\n", openErr, resLabel)
-				if rmErr := os.RemoveAll("converted"); rmErr != nil {
-					return fmt.Errorf("failed to remove invalid converted folder: %v", rmErr)
-				}
-			} else {
-				bounds := sampleImg.Bounds()
-				if bounds.Dx() == targetWidth && bounds.Dy() == targetHeight {
-					fmt.Println("The 'converted' folder already exists, skipping image conversion...")
-					return nil // Existing converted images already match requested output resolution.
-				}
-
-				fmt.Printf("Converted images are %dx%d but requested output is %dx%d, rebuilding...\n", bounds.Dx(), bounds.Dy(), targetWidth, targetHeight)
-				if rmErr := os.RemoveAll("converted"); rmErr != nil {
-					return fmt.Errorf("failed to remove converted folder for resolution rebuild: %v", rmErr)
-				}
+	// Skip conversion only when "converted" already holds converted images; cards,
+	// caption images or text files alone do not count.
+	convertedFiles, globErr := existingConvertedImages()
+	if globErr != nil {
+		return fmt.Errorf("failed to inspect converted images: %v", globErr)
+	}
+	if len(convertedFiles) > 0 {
+		sampleImg, openErr := imaging.Open(convertedFiles[0], imaging.AutoOrientation(true))
+		if openErr != nil {
+			fmt.Printf("Converted images are unreadable (%v), regenerating for %s...\n", openErr, resLabel)
+			if rmErr := os.RemoveAll("converted"); rmErr != nil {
+				return fmt.Errorf("failed to remove invalid converted folder: %v", rmErr)
 			}
 		} else {
-			fmt.Println("The 'converted' folder already exists, skipping image conversion...")
-			return nil // Preserve existing behavior for an empty converted folder.
+			bounds := sampleImg.Bounds()
+			if bounds.Dx() == targetWidth && bounds.Dy() == targetHeight {
+				fmt.Println("The 'converted' folder already exists, skipping image conversion...")
+				return nil // Existing converted images already match requested output resolution.
+			}
+
+			fmt.Printf("Converted images are %dx%d but requested output is %dx%d, rebuilding...\n", bounds.Dx(), bounds.Dy(), targetWidth, targetHeight)
+			if rmErr := os.RemoveAll("converted"); rmErr != nil {
+				return fmt.Errorf("failed to remove converted folder for resolution rebuild: %v", rmErr)
+			}
 		}
 	}
 
@@ -124,14 +121,13 @@ func ConvertImages(fullHD bool) error {
 		// Composite the resized image onto the black background.
 		imgConverted := imaging.OverlayCenter(uhdBlack, imgResized, 1.0)
 
-		// Get image timestamp.
-		timestamp, err := FetchImageTimestamp(file)
+		// Name the converted image after its timestamp.
+		filenameConverted, err := convertedImagePath(file, imageSuffix)
 		if err != nil {
-			return fmt.Errorf("failed to get image timestamp for %s: %v", file, err)
+			return err
 		}
 
 		// Save converted image.
-		filenameConverted := filepath.Join("converted", fmt.Sprintf("%s_%s.jpg", timestamp, imageSuffix))
 		if err := imaging.Save(imgConverted, filenameConverted); err != nil {
 			return fmt.Errorf("failed to save converted image %s: %v", filenameConverted, err)
 		}
@@ -145,6 +141,40 @@ func ConvertImages(fullHD bool) error {
 	return nil
 }
 
+// convertedImagePath returns the path ConvertImages writes for a source image:
+// converted/<timestamp>_<suffix>.jpg.
+func convertedImagePath(file, imageSuffix string) (string, error) {
+	timestamp, err := FetchImageTimestamp(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to get image timestamp for %s: %v", file, err)
+	}
+	return filepath.Join("converted", fmt.Sprintf("%s_%s.jpg", timestamp, imageSuffix)), nil
+}
+
+// plannedConvertedImages lists the converted paths for the .jpg files in the working
+// directory without converting anything. Used by dry runs before the first conversion.
+func plannedConvertedImages(fullHD bool) ([]string, error) {
+	imageSuffix := "uhd"
+	if fullHD {
+		imageSuffix = "fhd"
+	}
+
+	files, err := filepath.Glob("*.jpg")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list .jpg files: %v", err)
+	}
+
+	planned := make([]string, 0, len(files))
+	for _, file := range files {
+		path, err := convertedImagePath(file, imageSuffix)
+		if err != nil {
+			return nil, err
+		}
+		planned = append(planned, path)
+	}
+	return planned, nil
+}
+
 func resizeImageToCanvas(img image.Image, targetWidth, targetHeight int) *image.NRGBA {
 	bounds := img.Bounds()
 	sourceWidth := bounds.Dx()
@@ -315,137 +345,112 @@ func ExtractCameraInfo(filename string) (*CameraInfo, error) {
 
 	// Extract date taken (DateTime or DateTimeOriginal)
 	if tag, err := x.Get(exif.DateTimeOriginal); err == nil {
-		dateStr := strings.TrimSpace(tag.String())
-		dateStr = strings.Trim(dateStr, `"`)
-		// Parse EXIF date format: "2006:01:02 15:04:05"
-		if t, err := time.Parse("2006:01:02 15:04:05", dateStr); err == nil {
+		if t, ok := parseEXIFDateTime(tag.String()); ok {
+			info.CapturedAt = t
 			info.DateTaken = t.Format("02/01/2006")
 		}
 	} else if tag, err := x.Get(exif.DateTime); err == nil {
 		// Fallback to DateTime if DateTimeOriginal is not available
-		dateStr := strings.TrimSpace(tag.String())
-		dateStr = strings.Trim(dateStr, `"`)
-		if t, err := time.Parse("2006:01:02 15:04:05", dateStr); err == nil {
+		if t, ok := parseEXIFDateTime(tag.String()); ok {
+			info.CapturedAt = t
 			info.DateTaken = t.Format("02/01/2006")
 		}
 	}
 
+	// Extract GPS position
+	if lat, lon, err := x.LatLong(); err == nil {
+		info.Location = formatCoordinates(lat, lon)
+	}
+
 	return info, nil
 }
 
 // FormatCameraInfoOverlay formats camera information and creates FFmpeg drawtext filter
-// with specified fontSize, positioned in the footer (bottom center)
+// with specified fontSize, positioned according to the active overlay style (bottom center by default)
 func FormatCameraInfoOverlay(info *CameraInfo, fontSize, imageIndex int) string {
 	if info == nil {
 		return ""
 	}
+	return formatOverlayDrawtext(newOverlayTemplateData(info, "", imageIndex, 0), fontSize, imageIndex, 0, 0)
+}
 
-	// Show only camera model in overlay (omit manufacturer).
-	cameraName := info.Model
-
-	// If no camera info, return empty
-	if cameraName == "" {
+// formatOverlayDrawtext renders the overlay template for one timeline item and
+// wraps the result in a drawtext filter. It returns "" when there is nothing to show.
+// duration and fadeDuration time the caption fade when it is enabled.
+func formatOverlayDrawtext(data OverlayTemplateData, fontSize, imageIndex int, duration, fadeDuration float64) string {
+	overlayText := overlayCaptionText(data)
+	if overlayText == "" {
 		return ""
 	}
 
-	// Build technical settings with dash separators
-	var techSettings []string
-
-	if info.FocalLength != "" {
-		techSettings = append(techSettings, info.FocalLength)
-	}
-	if info.FNumber != "" {
-		techSettings = append(techSettings, info.FNumber)
-	}
-	if info.ExposureTime != "" {
-		techSettings = append(techSettings, info.ExposureTime)
-	}
-	if info.ISO != "" {
-		techSettings = append(techSettings, info.ISO)
-	}
-
-	// Use photo date if available, otherwise current date as fallback
-	var dateStr string
-	if info.DateTaken != "" {
-		dateStr = info.DateTaken
-	} else {
-		// Fallback to current date if no date found in EXIF
-		currentTime := time.Now()
-		dateStr = currentTime.Format("02/01/2006")
-	}
-
-	// Build final string: "Camera - TechSettings - Date"
-	var overlayText string
-	if len(techSettings) > 0 {
-		overlayText = fmt.Sprintf("%s - %s - %s", cameraName, strings.Join(techSettings, " - "), dateStr)
-	} else {
-		overlayText = fmt.Sprintf("%s - %s", cameraName, dateStr)
-	}
+	style := buildDrawtextStyle(fontSize, duration, fadeDuration)
 
 	// Write text to a temporary file to avoid escaping issues
 	// Each image gets its own overlay file
-	textFile := fmt.Sprintf("converted/overlay_%d.txt", imageIndex)
+	textFile := generatedFile(fmt.Sprintf("converted/overlay_%d.txt", imageIndex))
 	if err := os.WriteFile(textFile, []byte(overlayText), 0644); err != nil {
-		// Fallback to inline text with escaping if file write fails
-		overlayText = strings.ReplaceAll(overlayText, "|", "-")
-		overlayText = strings.ReplaceAll(overlayText, ":", " ")
-		overlayText = strings.ReplaceAll(overlayText, "/", "\\/")
-		overlayText = strings.ReplaceAll(overlayText, " ", "\\ ")
-
-		xPosition := "(w-tw)/2"
-		yPosition := "h-th-40"
-		if activeResolution == resolutionFullHD {
-			yPosition = "h-th-30"
-		}
-		return fmt.Sprintf(",drawtext=text=%s:fontsize=%d:fontcolor=white:x=%s:y=%s:box=1:boxcolor=black@0.5:boxborderw=5",
-			overlayText, fontSize, xPosition, yPosition)
-	}
-
-	// Position fixed at footer (bottom center)
-	xPosition := "(w-tw)/2" // Horizontal center
-	yPosition := "h-th-40"  // Bottom with 40px margin in UHD, 30px in Full HD
-	if activeResolution == resolutionFullHD {
-		yPosition = "h-th-30"
+		// Fallback to inline text if file write fails; the text is escaped
+		// for drawtext and the filtergraph so ':' '/' and UTF-8 survive intact
+		return fmt.Sprintf(",drawtext=expansion=none:text=%s:%s", escapeDrawtextText(overlayText), style)
 	}
 
 	// Build the complete FFmpeg drawtext filter using textfile parameter with reload
 	// Add reload=1 to force FFmpeg to read the file content for each frame
-	drawtextFilter := fmt.Sprintf(",drawtext=textfile='%s':reload=1:fontsize=%d:fontcolor=white:x=%s:y=%s:box=1:boxcolor=black@0.5:boxborderw=5",
-		textFile, fontSize, xPosition, yPosition)
+	return fmt.Sprintf(",drawtext=textfile='%s':reload=1:%s", textFile, style)
+}
 
-	return drawtextFilter
+// existingConvertedImages lists the images ConvertImages wrote to "converted",
+// at either resolution (converted/<timestamp>_uhd.jpg or _fhd.jpg).
+func existingConvertedImages() ([]string, error) {
+	var files []string
+	for _, suffix := range []string{"uhd", "fhd"} {
+		matches, err := filepath.Glob(filepath.Join("converted", "*_"+suffix+".jpg"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
 }
 
 // GetOriginalFilename attempts to find the original image file corresponding to a converted file
 // by matching the timestamp pattern in the converted filename
 func GetOriginalFilename(convertedFile string) string {
-	// Extract timestamp from converted filename
-	// Format: converted/YYYYMMDD_HHMMSS_uhd.jpg or converted/YYYYMMDD_HHMMSS_fhd.jpg
-	baseName := filepath.Base(convertedFile)
-	timestamp := trimConvertedImageResolutionSuffix(baseName)
+	return originalFilenameFrom(convertedFile, originalFilesByTimestamp())
+}
 
-	// Look for original files with matching timestamps
+// originalFilesByTimestamp reads the EXIF timestamp of every .jpg in the working directory
+// and maps each timestamp to the first file that has it.
+func originalFilesByTimestamp() map[string]string {
+	originals := map[string]string{}
 	files, err := filepath.Glob("*.jpg")
 	if err != nil {
-		return ""
+		return originals
 	}
-
 	for _, file := range files {
 		// Skip if this is in the converted directory
 		if strings.Contains(file, "converted/") {
 			continue
 		}
-
-		// Extract timestamp from original file
-		originalTimestamp, err := FetchImageTimestamp(file)
+		timestamp, err := FetchImageTimestamp(file)
 		if err != nil {
 			continue
 		}
-
-		if originalTimestamp == timestamp {
-			return file
+		if _, ok := originals[timestamp]; !ok {
+			originals[timestamp] = file
 		}
 	}
+	return originals
+}
+
+// originalFilenameFrom looks a converted file up in an index built by originalFilesByTimestamp.
+func originalFilenameFrom(convertedFile string, originals map[string]string) string {
+	// Extract timestamp from converted filename
+	// Format: converted/YYYYMMDD_HHMMSS_uhd.jpg or converted/YYYYMMDD_HHMMSS_fhd.jpg
+	timestamp := trimConvertedImageResolutionSuffix(filepath.Base(convertedFile))
+	if file, ok := originals[timestamp]; ok {
+		return file
+	}
 
 	// Fallback: when timestamp matching fails, use the timestamp from the converted name
 	// This preserves the alphabetical ordering when original files can't be found.
@@ -456,3 +461,12 @@ func GetOriginalFilename(convertedFile string) string {
 
 	return ""
 }
+
+// originalFilename is GetOriginalFilename for the current run: it uses the index of the
+// source files built once by GenerateVideo, and scans the folder only outside a run.
+func originalFilename(convertedFile string) string {
+	if activeRun.OriginalFiles == nil {
+		return GetOriginalFilename(convertedFile)
+	}
+	return originalFilenameFrom(convertedFile, activeRun.OriginalFiles)
+}
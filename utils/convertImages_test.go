@@ -206,23 +206,40 @@ func TestConvertImages_MultipleImages(t *testing.T) {
 func TestConvertImages_ExistingConvertedDirectory(t *testing.T) {
 	tempDir := setupTestDir(t)
 
-	// Create converted directory first
+	// A converted folder holding only a card image (e.g. from an earlier run) has no
+	// converted photos, so conversion must still run.
 	convertedDir := filepath.Join(tempDir, "converted")
 	err := os.MkdirAll(convertedDir, os.ModePerm)
 	if err != nil {
 		t.Fatalf("Failed to create converted directory: %v", err)
 	}
+	if err := os.WriteFile(filepath.Join(convertedDir, "title_card.png"), []byte("png"), 0644); err != nil {
+		t.Fatalf("Failed to create card file: %v", err)
+	}
 
-	// Create a test image
-	createTestImage(t, "test_image.jpg", 1920, 1080)
+	createTestImage(t, "test_image1.jpg", 1920, 1080)
+	createTestImage(t, "test_image2.jpg", 1920, 1080)
 
 	err = ConvertImages(false)
 	if err != nil {
 		t.Errorf("ConvertImages should not fail when converted directory exists: %v", err)
 	}
 
-	// Should skip conversion and return early
-	// We can't easily test the skip message without capturing output
+	convertedFiles, err := existingConvertedImages()
+	if err != nil {
+		t.Fatalf("Failed to list converted files: %v", err)
+	}
+	if len(convertedFiles) != 2 {
+		t.Errorf("Expected 2 converted files next to the card, got %v", convertedFiles)
+	}
+
+	// With converted photos in place, a second run skips conversion.
+	if err := os.Remove("test_image1.jpg"); err != nil {
+		t.Fatalf("Failed to remove source image: %v", err)
+	}
+	if err := ConvertImages(false); err != nil {
+		t.Errorf("ConvertImages should skip existing converted images: %v", err)
+	}
 }
 
 func TestConvertImages_RebuildsOnResolutionMismatch(t *testing.T) {
@@ -737,7 +754,7 @@ func TestFormatCameraInfoOverlay(t *testing.T) {
 				DateTaken:    "15.08.2024",
 			},
 			fontSize: 48,
-			expected: ",drawtext=text=EOS\\ R5\\ -\\ 50mm\\ -\\ f\\/2.8\\ -\\ 1\\/125s\\ -\\ ISO\\ 400\\ -\\ 15.08.2024:fontsize=48:fontcolor=white:x=(w-tw)/2:y=h-th-40:box=1:boxcolor=black@0.5:boxborderw=5",
+			expected: ",drawtext=expansion=none:text=EOS R5 - 50mm - f/2.8 - 1/125s - ISO 400 - 15.08.2024:fontsize=48:fontcolor=white:x=(w-tw)/2:y=h-th-40:box=1:boxcolor=black@0.5:boxborderw=5",
 		},
 		{
 			name: "Camera with large font",
@@ -750,7 +767,7 @@ func TestFormatCameraInfoOverlay(t *testing.T) {
 				DateTaken:   "22.06.2024",
 			},
 			fontSize: 48,
-			expected: ",drawtext=text=A7R\\ IV\\ -\\ 85mm\\ -\\ f\\/1.4\\ -\\ ISO\\ 800\\ -\\ 22.06.2024:fontsize=48:fontcolor=white:x=(w-tw)/2:y=h-th-40:box=1:boxcolor=black@0.5:boxborderw=5",
+			expected: ",drawtext=expansion=none:text=A7R IV - 85mm - f/1.4 - ISO 800 - 22.06.2024:fontsize=48:fontcolor=white:x=(w-tw)/2:y=h-th-40:box=1:boxcolor=black@0.5:boxborderw=5",
 		},
 		{
 			name: "Basic camera info",
@@ -760,7 +777,7 @@ func TestFormatCameraInfoOverlay(t *testing.T) {
 				DateTaken: "10.03.2024",
 			},
 			fontSize: 24,
-			expected: ",drawtext=text=D850\\ -\\ 10.03.2024:fontsize=24:fontcolor=white:x=(w-tw)/2:y=h-th-40:box=1:boxcolor=black@0.5:boxborderw=5",
+			expected: ",drawtext=expansion=none:text=D850 - 10.03.2024:fontsize=24:fontcolor=white:x=(w-tw)/2:y=h-th-40:box=1:boxcolor=black@0.5:boxborderw=5",
 		},
 		{
 			name: "Camera with partial info",
@@ -772,7 +789,7 @@ func TestFormatCameraInfoOverlay(t *testing.T) {
 				DateTaken:   "10.03.2024",
 			},
 			fontSize: 32,
-			expected: ",drawtext=text=X-T4\\ -\\ 35mm\\ -\\ f\\/2.0\\ -\\ 10.03.2024:fontsize=32:fontcolor=white:x=(w-tw)/2:y=h-th-40:box=1:boxcolor=black@0.5:boxborderw=5",
+			expected: ",drawtext=expansion=none:text=X-T4 - 35mm - f/2.0 - 10.03.2024:fontsize=32:fontcolor=white:x=(w-tw)/2:y=h-th-40:box=1:boxcolor=black@0.5:boxborderw=5",
 		},
 	}
 
@@ -854,3 +871,361 @@ func TestGetOriginalFilename(t *testing.T) {
 	// which is complex in a unit test environment. The function is designed to handle
 	// cases gracefully when EXIF data is not available.
 }
+
+func TestOriginalFilenameUsesRunIndex(t *testing.T) {
+	oldRun := activeRun
+	defer func() {
+		activeRun = oldRun
+	}()
+
+	// Within a run the index is the only source: the folder is not scanned again.
+	activeRun = runState{OriginalFiles: map[string]string{"20230815_093045": "IMG_0042.jpg"}}
+	if got := originalFilename("converted/20230815_093045_uhd.jpg"); got != "IMG_0042.jpg" {
+		t.Errorf("originalFilename = %q, want IMG_0042.jpg", got)
+	}
+	if got := mediaSourcePath(MediaInput{Path: "converted/20230815_093045_fhd.jpg", IsImage: true}); got != "IMG_0042.jpg" {
+		t.Errorf("mediaSourcePath = %q, want IMG_0042.jpg", got)
+	}
+	if got := originalFilename("converted/20230101_120000_uhd.jpg"); got != "20230101_120000.jpg" {
+		t.Errorf("expected the timestamp fallback for unindexed files, got %q", got)
+	}
+}
+
+func TestRenderOverlayText_Template(t *testing.T) {
+	oldOverlay := activeOptions.Overlay
+	defer func() {
+		activeOptions.Overlay = oldOverlay
+	}()
+
+	info := &CameraInfo{
+		Make:        "Nikon",
+		Model:       "Z8",
+		LensModel:   "NIKKOR Z 24-120mm f/4 S",
+		FocalLength: "50mm",
+		CapturedAt:  time.Date(2024, 8, 15, 10, 30, 0, 0, time.UTC),
+		DateTaken:   "15/08/2024",
+	}
+
+	t.Run("Default layout", func(t *testing.T) {
+		activeOptions.Overlay = OverlayOptions{}
+		text, err := renderOverlayText(newOverlayTemplateData(info, "", 0, 3))
+		if err != nil {
+			t.Fatalf("renderOverlayText failed: %v", err)
+		}
+		if text != "Z8 - 50mm - 15/08/2024" {
+			t.Errorf("unexpected default overlay text %q", text)
+		}
+	})
+
+	t.Run("Custom template", func(t *testing.T) {
+		activeOptions.Overlay = OverlayOptions{Template: `{{.Make}} {{.Model}} · {{.Lens}} · {{.Date "2006-01-02"}} ({{.Index}}/{{.Count}})`}
+		text, err := renderOverlayText(newOverlayTemplateData(info, "", 1, 3))
+		if err != nil {
+			t.Fatalf("renderOverlayText failed: %v", err)
+		}
+		expected := "Nikon Z8 · NIKKOR Z 24-120mm f/4 S · 2024-08-15 (2/3)"
+		if text != expected {
+			t.Errorf("expected %q, got %q", expected, text)
+		}
+	})
+
+	t.Run("Missing date is omitted", func(t *testing.T) {
+		activeOptions.Overlay = OverlayOptions{}
+		text, err := renderOverlayText(newOverlayTemplateData(&CameraInfo{Model: "Z8"}, "", 0, 1))
+		if err != nil {
+			t.Fatalf("renderOverlayText failed: %v", err)
+		}
+		if text != "Z8" {
+			t.Errorf("expected only the model without an invented date, got %q", text)
+		}
+	})
+
+	t.Run("Invalid template", func(t *testing.T) {
+		if _, err := ParseOverlayTemplate("{{.Model"); err == nil {
+			t.Error("expected parse error for unterminated action")
+		}
+	})
+}
+
+func TestReadXMPPlace(t *testing.T) {
+	tempDir := t.TempDir()
+	file := filepath.Join(tempDir, "place.jpg")
+	xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF><rdf:Description photoshop:City="Lisboa" photoshop:Country="Portugal">` +
+		`<Iptc4xmpCore:Location>Belém</Iptc4xmpCore:Location></rdf:Description></rdf:RDF></x:xmpmeta>`
+	if err := os.WriteFile(file, []byte("\xff\xd8"+xmp+"\xff\xd9"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if place := readXMPPlace(file); place != "Belém, Lisboa, Portugal" {
+		t.Errorf("unexpected place %q", place)
+	}
+	if place := readXMPPlace(filepath.Join(tempDir, "missing.jpg")); place != "" {
+		t.Errorf("expected empty place for missing file, got %q", place)
+	}
+}
+
+func TestFormatCameraInfoOverlay_Style(t *testing.T) {
+	oldResolution := activeResolution
+	oldOverlay := activeOptions.Overlay
+	defer func() {
+		activeResolution = oldResolution
+		activeOptions.Overlay = oldOverlay
+	}()
+	activeResolution = resolution4K
+
+	info := &CameraInfo{Model: "Z8", DateTaken: "15/08/2024"}
+
+	t.Run("Top right with custom margins and colours", func(t *testing.T) {
+		activeOptions.Overlay = OverlayOptions{Position: "top-right", MarginX: 60, MarginY: 20, FontColor: "yellow", BoxColor: "navy", BoxOpacity: 0.3}
+		result := FormatCameraInfoOverlay(info, 36, 0)
+		for _, want := range []string{"fontcolor=yellow", "x=w-tw-60", "y=20", "boxcolor=navy@0.3"} {
+			if !strings.Contains(result, want) {
+				t.Errorf("expected %q in %q", want, result)
+			}
+		}
+	})
+
+	t.Run("No box with shadow and font file", func(t *testing.T) {
+		activeOptions.Overlay = OverlayOptions{DisableBox: true, Shadow: true, FontFile: "fonts/Inter.ttf"}
+		result := FormatCameraInfoOverlay(info, 48, 0)
+		if strings.Contains(result, "box=1") {
+			t.Errorf("did not expect a background box, got %q", result)
+		}
+		for _, want := range []string{"fontfile='fonts/Inter.ttf'", "shadowcolor=black@0.6", "shadowx=3"} {
+			if !strings.Contains(result, want) {
+				t.Errorf("expected %q in %q", want, result)
+			}
+		}
+	})
+
+	t.Run("Fade follows the crossfade", func(t *testing.T) {
+		activeOptions.Overlay = OverlayOptions{Fade: true}
+		result := formatOverlayDrawtext(newOverlayTemplateData(info, "", 0, 2), 48, 0, 5, 1)
+		expected := "alpha='if(lt(t,1.000),t/1.000,if(gt(t,4.000),(5.000-t)/1.000,1))'"
+		if !strings.Contains(result, expected) {
+			t.Errorf("expected fade expression %q in %q", expected, result)
+		}
+	})
+
+	t.Run("Invalid position", func(t *testing.T) {
+		if _, err := NormalizeOverlayPosition("upper-middle"); err == nil {
+			t.Error("expected error for unknown position")
+		}
+	})
+}
+
+func TestRenderCaptionImage(t *testing.T) {
+	oldOverlay := activeOptions.Overlay
+	defer func() {
+		activeOptions.Overlay = oldOverlay
+	}()
+	activeOptions.Overlay = OverlayOptions{Renderer: "image", Shadow: true}
+
+	output := filepath.Join(t.TempDir(), "caption.png")
+	if err := renderCaptionImage("Z8 - 50mm\n15/08/2024", 32, output); err != nil {
+		t.Fatalf("renderCaptionImage failed: %v", err)
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		t.Fatalf("failed to decode caption PNG: %v", err)
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() <= 2*overlayBoxPadding || bounds.Dy() <= 2*overlayBoxPadding {
+		t.Fatalf("caption image too small: %v", bounds)
+	}
+	// The shadow margin beyond the box stays transparent.
+	if _, _, _, a := img.At(bounds.Max.X-1, 0).RGBA(); a != 0 {
+		t.Errorf("expected transparent corner, got alpha %d", a)
+	}
+	// The box itself is semi-transparent black.
+	if _, _, _, a := img.At(1, 1).RGBA(); a == 0 || a == 0xffff {
+		t.Errorf("expected semi-transparent box pixel, got alpha %d", a)
+	}
+}
+
+func TestParseOverlayColor(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected color.NRGBA
+		wantErr  bool
+	}{
+		{"white", color.NRGBA{255, 255, 255, 255}, false},
+		{"black@0.5", color.NRGBA{0, 0, 0, 128}, false},
+		{"#FF8800", color.NRGBA{255, 136, 0, 255}, false},
+		{"0x00000080", color.NRGBA{0, 0, 0, 128}, false},
+		{"not-a-colour", color.NRGBA{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseOverlayColor(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOverlayColor(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.expected {
+				t.Errorf("parseOverlayColor(%q) = %v, want %v", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuildCaptionOverlayFilter(t *testing.T) {
+	oldOverlay := activeOptions.Overlay
+	oldResolution := activeResolution
+	defer func() {
+		activeOptions.Overlay = oldOverlay
+		activeResolution = oldResolution
+	}()
+	activeResolution = resolution4K
+	activeOptions.Overlay = OverlayOptions{Renderer: "image", Fade: true}
+
+	filter := buildCaptionOverlayFilter("base1", 3, 5, 1)
+	for _, want := range []string{"[3:v]", "fade=t=in:st=0:d=1.000:alpha=1", "fade=t=out:st=4.000:d=1.000:alpha=1", "[base1][cap3]overlay=x=(W-w)/2:y=H-h-40"} {
+		if !strings.Contains(filter, want) {
+			t.Errorf("expected %q in %q", want, filter)
+		}
+	}
+}
+
+func TestReadCaptionText(t *testing.T) {
+	tempDir := t.TempDir()
+
+	t.Run("Sidecar wins over XMP", func(t *testing.T) {
+		file := filepath.Join(tempDir, "sidecar.jpg")
+		xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><dc:description><rdf:Alt><rdf:li xml:lang="x-default">From XMP</rdf:li></rdf:Alt></dc:description></x:xmpmeta>`
+		if err := os.WriteFile(file, []byte("\xff\xd8"+xmp+"\xff\xd9"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file+".txt", []byte("\xef\xbb\xbfPôr do sol: 18:45 🌅\r\n\r\nLisboa/Belém\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := readCaptionText(file); got != "Pôr do sol: 18:45 🌅\nLisboa/Belém" {
+			t.Errorf("unexpected sidecar caption %q", got)
+		}
+	})
+
+	t.Run("XMP description", func(t *testing.T) {
+		file := filepath.Join(tempDir, "xmp.jpg")
+		xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><dc:description><rdf:Alt><rdf:li xml:lang="x-default">Tom &amp; Ana</rdf:li></rdf:Alt></dc:description></x:xmpmeta>`
+		if err := os.WriteFile(file, []byte("\xff\xd8"+xmp+"\xff\xd9"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := readCaptionText(file); got != "Tom & Ana" {
+			t.Errorf("unexpected XMP caption %q", got)
+		}
+	})
+
+	t.Run("IPTC caption in Latin-1", func(t *testing.T) {
+		caption := []byte("Caf\xe9 da manh\xe3")
+		iptc := append([]byte{0x1C, 2, 120, 0, byte(len(caption))}, caption...)
+		resource := append([]byte("8BIM\x04\x04\x00\x00"), 0, 0, 0, byte(len(iptc)))
+		resource = append(resource, iptc...)
+		payload := append([]byte("Photoshop 3.0\x00"), resource...)
+		segment := append([]byte{0xFF, 0xED, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}, payload...)
+		data := append([]byte{0xFF, 0xD8}, segment...)
+		data = append(data, 0xFF, 0xD9)
+
+		file := filepath.Join(tempDir, "iptc.jpg")
+		if err := os.WriteFile(file, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := readCaptionText(file); got != "Café da manhã" {
+			t.Errorf("unexpected IPTC caption %q", got)
+		}
+	})
+
+	t.Run("No caption", func(t *testing.T) {
+		file := filepath.Join(tempDir, "plain.jpg")
+		createTestImage(t, file, 10, 10)
+		if got := readCaptionText(file); got != "" {
+			t.Errorf("expected no caption, got %q", got)
+		}
+	})
+
+	t.Run("Clips use sidecars only", func(t *testing.T) {
+		file := filepath.Join(tempDir, "clip.mp4")
+		xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><dc:description><rdf:Alt><rdf:li xml:lang="x-default">Embedded</rdf:li></rdf:Alt></dc:description></x:xmpmeta>`
+		if err := os.WriteFile(file, []byte(xmp), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := mediaCaptionText(MediaInput{Path: file}); got != "" {
+			t.Errorf("expected clips to skip embedded metadata, got %q", got)
+		}
+		if err := os.WriteFile(file+".txt", []byte("Na praia"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := mediaCaptionText(MediaInput{Path: file}); got != "Na praia" {
+			t.Errorf("unexpected clip sidecar caption %q", got)
+		}
+	})
+
+	t.Run("XMP past the header is not read", func(t *testing.T) {
+		file := filepath.Join(tempDir, "late.jpg")
+		xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><dc:description><rdf:Alt><rdf:li xml:lang="x-default">Too late</rdf:li></rdf:Alt></dc:description></x:xmpmeta>`
+		data := append([]byte("\xff\xd8"), make([]byte, metadataHeaderSize)...)
+		if err := os.WriteFile(file, append(data, xmp...), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got := readXMPPacket(file); got != "" {
+			t.Errorf("expected only the header to be scanned, got %q", got)
+		}
+	})
+}
+
+func TestWrapCaptionText(t *testing.T) {
+	measure := func(s string) int { return len([]rune(s)) }
+
+	got := wrapCaptionText("one two three four\nfive", 9, measure)
+	want := []string{"one two", "three", "four", "five"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("wrapCaptionText() = %q, want %q", got, want)
+	}
+
+	got = wrapCaptionText("🌅🌅🌅🌅🌅", 2, measure)
+	want = []string{"🌅🌅", "🌅🌅", "🌅"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("wrapCaptionText() with emoji = %q, want %q", got, want)
+	}
+}
+
+func TestEscapeDrawtextText(t *testing.T) {
+	got := escapeDrawtextText(`18:45 it's a/b [x], 100%`)
+	want := `18\\:45 it\\\'s a/b \[x\]\, 100%`
+	if got != want {
+		t.Errorf("escapeDrawtextText() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatCaptionDrawtext(t *testing.T) {
+	oldOverlay := activeOptions.Overlay
+	oldResolution := activeResolution
+	defer func() {
+		activeOptions.Overlay = oldOverlay
+		activeResolution = oldResolution
+	}()
+	activeResolution = resolution4K
+	activeOptions.Overlay = OverlayOptions{}
+
+	// Without a converted/ folder the text is escaped inline.
+	tempDir := t.TempDir()
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	os.Chdir(tempDir)
+
+	filter := formatCaptionDrawtext([]string{"Lisboa: 18:45", "Belém"}, 64, 2, 5, 1)
+	for _, want := range []string{
+		`,drawtext=expansion=none:text=Lisboa\\: 18\\:45:fontsize=64:fontcolor=white:x=(w-tw)/2:y=h-h/8-166:`,
+		`,drawtext=expansion=none:text=Belém:fontsize=64:fontcolor=white:x=(w-tw)/2:y=h-h/8-83:`,
+	} {
+		if !strings.Contains(filter, want) {
+			t.Errorf("expected %q in %q", want, filter)
+		}
+	}
+}
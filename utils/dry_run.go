@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// dryRunCommandFile receives the ffmpeg command line of a dry run, ready to paste into a
+// shell from the working folder. It is written to the dry-run folder (see generatedFile).
+const dryRunCommandFile = "ffmpeg_command.txt"
+
+// generatedFile returns where the run writes a generated file (cards, caption images,
+// filter script, chapters, subtitles): path itself, or its base name inside
+// activeRun.DryRunDir on a dry run, so converted/ and the working folder stay as they were.
+func generatedFile(path string) string {
+	if activeRun.DryRunDir == "" {
+		return path
+	}
+	return filepath.ToSlash(filepath.Join(activeRun.DryRunDir, filepath.Base(path)))
+}
+
+// formatTimelineTable renders the manifest items as an aligned text table.
+func formatTimelineTable(manifest TimelineManifest) string {
+	var builder strings.Builder
+	writer := tabwriter.NewWriter(&builder, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "#\tStart\tEnd\tDuration\tType\tSource\tOriginal\tKen Burns")
+	for _, item := range manifest.Items {
+		original, kenBurns := item.Original, item.KenBurns
+		if original == "" || original == item.Source {
+			original = "-"
+		}
+		if kenBurns == "" {
+			kenBurns = "-"
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%.2fs\t%s\t%s\t%s\t%s\n", item.Index,
+			item.StartTimecode, item.EndTimecode, item.SegmentDuration, item.Type, item.Source, original, kenBurns)
+	}
+	writer.Flush()
+	fmt.Fprintf(&builder, "Total: %d items, %s (%.2fs), %s crossfades of %.2fs\n",
+		len(manifest.Items), formatSubtitleTimestamp(manifest.Duration, "."), manifest.Duration,
+		manifest.Transition.Type, manifest.Transition.Duration)
+	return builder.String()
+}
+
+// shellQuote quotes an argument for POSIX shells when it contains special characters.
+func shellQuote(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	if !strings.ContainsAny(arg, " \t\n'\"\\$`!*?[]{}()<>|&;#~=,:") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// formatCommandLine joins a program and its arguments into a copy-pasteable command line.
+func formatCommandLine(program string, args []string) string {
+	quoted := make([]string, 0, len(args)+1)
+	quoted = append(quoted, program)
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// printDryRun reports what a render would do: the ordered timeline, the filter graph
+// and the exact ffmpeg argv. The command line, the filter script and the other generated
+// inputs stay in activeRun.DryRunDir, so the render can be run by hand and the working
+// folder is left as it was.
+func printDryRun(manifest TimelineManifest, filterComplex, filterComplexFile string, args []string) {
+	fmt.Printf("\nDry run: nothing was encoded.\n")
+	fmt.Printf("Output: %s (%s, %d fps)\n\n", manifest.Output, manifest.Resolution, manifest.FPS)
+
+	fmt.Printf("Timeline:\n%s\n", formatTimelineTable(manifest))
+
+	fmt.Printf("filter_complex (%s):\n%s\n\n", filterComplexFile, strings.ReplaceAll(filterComplex, "; ", ";\n"))
+
+	fmt.Printf("ffmpeg argv:\n")
+	for i, arg := range args {
+		fmt.Printf("  [%d] %s\n", i, arg)
+	}
+
+	commandFile := generatedFile(dryRunCommandFile)
+	commandLine := formatCommandLine("ffmpeg", args)
+	if err := os.WriteFile(commandFile, []byte(commandLine+"\n"), 0644); err != nil {
+		fmt.Printf("Warning: failed to write %s: %v\n", commandFile, err)
+		return
+	}
+	fmt.Printf("\nCommand line written to %s (run it from this folder)\n", commandFile)
+}
@@ -1,9 +1,13 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 const (
@@ -22,6 +26,10 @@ const (
 // Set at the start of GenerateVideo() based on the fullHD flag.
 var activeResolution = resolution4K
 
+// activeSourceResolution holds the resolution of the converted images for the current run.
+// It differs from activeResolution only in preview renders.
+var activeSourceResolution = resolution4K
+
 // activeFPS holds the target output framerate for the current run.
 // Set at the start of GenerateVideo().
 var activeFPS = 30
@@ -30,26 +38,278 @@ var activeFPS = 30
 // Set at the start of GenerateVideo().
 var activeKenBurnsMode = kenBurnsModeHigh
 
+// activeOptions holds the optional features of the current run (see GenerateOptions).
+// Set at the start of GenerateVideo(); helpers read the field of their feature, such as
+// activeOptions.Overlay or activeOptions.Music.
+var activeOptions GenerateOptions
+
+// runState holds what GenerateVideo derives from the options for the current run.
+type runState struct {
+	DryRunDir     string            // Temporary folder receiving the files of a dry run; empty otherwise
+	MusicTracks   []musicTrack      // Parsed -music-tracks; empty when the music is found in the folder
+	OriginalFiles map[string]string // Source photos by EXIF timestamp (see originalFilename)
+	PreviewStart  float64           // Start of the -preview-range window on the timeline
+	PreviewEnd    float64           // End of the window; zero renders the whole timeline
+}
+
+// activeRun holds the derived state of the current run.
+// Reset at the start of GenerateVideo().
+var activeRun runState
+
+// OverlayOptions configures the caption drawn over each item when the EXIF overlay is enabled.
+type OverlayOptions struct {
+	// Template is a Go text/template for the caption text (see OverlayTemplateData).
+	// When empty, the default "Model - focal - f/ - shutter - ISO - date" layout is used.
+	Template string
+
+	// Position is one of top-left, top, top-right, left, center, right,
+	// bottom-left, bottom (default) or bottom-right.
+	Position string
+	// MarginX and MarginY are the distances in pixels from the frame edges.
+	// Zero uses the default footer margin (40px UHD, 30px Full HD).
+	MarginX int
+	MarginY int
+
+	FontFile    string  // Font file passed to drawtext; empty uses the fontconfig default
+	FontColor   string  // Text colour (default white)
+	BoxColor    string  // Background box colour (default black)
+	BoxOpacity  float64 // Background box opacity in [0, 1]; zero uses 0.5
+	DisableBox  bool    // Draw the text without a background box
+	Shadow      bool    // Draw a drop shadow behind the text
+	ShadowColor string  // Drop shadow colour (default black@0.6)
+	Fade        bool    // Fade the caption in and out together with the crossfade
+
+	// Renderer selects how captions are drawn: "drawtext" (default) uses FFmpeg's
+	// drawtext filter, "image" renders transparent PNGs in Go with an embedded font.
+	Renderer string
+}
+
+// CaptionOptions configures the lower-third description captions read from
+// sidecar text files, XMP dc:description or the IPTC caption of each item.
+// Captions share the font, colours, box, shadow, fade and renderer of OverlayOptions.
+type CaptionOptions struct {
+	Enabled bool
+	// FontSize is the caption font size in pixels; zero uses 64 (UHD) or 40 (Full HD).
+	FontSize int
+}
+
+// CardOptions configures the generated opening title card and closing credits card.
+// Cards are still segments at the output size, styled with the overlay font and colour.
+type CardOptions struct {
+	Title    string // Opening card title; a literal "\n" starts a new line
+	Subtitle string // Smaller line below the title
+	// Credits is the closing card text, or CreditsAuto to list the cameras,
+	// date range and music tracks of the timeline.
+	Credits string
+}
+
+// WatermarkOptions configures a logo composited over the finished video.
+type WatermarkOptions struct {
+	File     string  // Logo image (PNG with transparency recommended); empty disables the watermark
+	Position string  // Overlay position name (default bottom-right)
+	Scale    float64 // Logo width as a fraction of the output width, in (0, 1]
+	Opacity  float64 // Logo opacity in (0, 1]
+	Margin   int     // Distance in pixels from the frame edges; zero uses the overlay default
+	Fade     bool    // Fade the logo in and out with the start and end of the video
+}
+
+// ChapterOptions configures MP4 chapter markers and the YouTube chapter list.
+type ChapterOptions struct {
+	// Mode selects chapter boundaries: none (default), folder, day, cluster or every.
+	Mode  string
+	Every int           // Items per chapter in "every" mode; zero uses 10
+	Gap   time.Duration // Capture time gap that starts a new chapter in "cluster" mode; zero uses 2h
+}
+
+// SubtitleOptions configures the soft subtitle track: SRT and WebVTT files next to
+// the output and a mov_text stream muxed into the MP4 that viewers can toggle.
+type SubtitleOptions struct {
+	Enabled bool
+	// Text selects the cue text: caption (description), exif (overlay template) or both (default).
+	Text string
+}
+
+// ExportOptions configures the editor interchange files written next to the output
+// so the timeline can be rebuilt in Final Cut Pro, DaVinci Resolve or other editors.
+type ExportOptions struct {
+	// Formats is a comma-separated list of fcpxml, edl and otio, or all. Empty disables exports.
+	Formats string
+}
+
+// PreviewOptions configures fast low-resolution renders for reviewing order and pacing:
+// 640x360 at 30 fps, ultrafast encoding and no Ken Burns supersampling.
+type PreviewOptions struct {
+	Enabled bool
+	// Range limits the render to a window such as "60s-120s" or "1:00-2:00"; empty renders everything.
+	Range string
+}
+
+// ContactSheetOptions configures the storyboard PNG of the timeline written before
+// encoding: one thumbnail per item with its index, start time, duration and file name.
+type ContactSheetOptions struct {
+	Enabled bool
+	Columns int // Thumbnails per row; zero uses 5
+	Rows    int // Rows per page before a new PNG is started; zero uses 6
+}
+
+// MusicOptions configures how the background music tracks are joined and how
+// they make room for kept clip audio.
+type MusicOptions struct {
+	Crossfade   float64 // Seconds of acrossfade between consecutive tracks; zero joins them back to back
+	TrimSilence bool    // Trim leading and trailing silence from each track before joining
+
+	// Ducking is "mute" (music fades out for the whole length of every clip with audio)
+	// or "duck" (music dips only while the clip audio is actually loud).
+	Ducking     string
+	DuckLevel   float64 // Music level in dB while ducked; zero uses -18 dB
+	DuckAttack  float64 // Milliseconds to reach the duck level; zero uses 20 ms
+	DuckRelease float64 // Milliseconds to recover after the clip goes quiet; zero uses 400 ms
+
+	// Fit is the policy for music shorter than the slideshow: loop, truncate-video,
+	// silence, or fit-audio. Empty uses fit-audio with -fit-audio, otherwise truncate-video.
+	Fit string
+	// FitTrimClips lets fit-audio shorten clips, down to FitMinClip seconds (zero uses 3s),
+	// when photos alone cannot absorb the difference with the music.
+	FitTrimClips bool
+	FitMinClip   float64
+
+	// Tracks replaces the music found in the working directory with an explicit list
+	// of [ITEMS=]FILE[@START[-END]] entries (see ParseMusicTracks).
+	Tracks string
+}
+
+// AudioOptions configures loudness normalization of the final mix and the audio encoder.
+type AudioOptions struct {
+	Loudness   float64 // Integrated loudness target in LUFS; zero uses -16
+	TwoPass    bool    // Measure the mix in an extra audio-only pass, then normalize linearly
+	MusicGain  float64 // Gain in dB applied to the music before mixing
+	ClipGain   float64 // Gain in dB applied to kept clip audio before mixing
+	Codec      string  // aac (default), opus or flac
+	Bitrate    string  // e.g. "192k"; empty derives AAC from the source and uses 160k for Opus
+	SampleRate int     // 44100, 48000 or 96000; zero uses 48000
+	// SilentTrack adds a silent audio stream when there is no music, clip audio or narration.
+	SilentTrack bool
+}
+
+// GenerateOptions carries optional features of GenerateVideo that go beyond
+// the core timing, resolution and ordering parameters.
+type GenerateOptions struct {
+	Overlay   OverlayOptions
+	Captions  CaptionOptions
+	Cards     CardOptions
+	Watermark WatermarkOptions
+	Chapters  ChapterOptions
+	Subtitles SubtitleOptions
+	Export    ExportOptions
+	Preview   PreviewOptions
+	Music     MusicOptions
+	// Video selects the video codec and container.
+	Video VideoOptions
+	// Audio sets the loudness target, per-source gains and the audio codec.
+	Audio AudioOptions
+	// Beats snaps transitions to beats of the background music.
+	Beats BeatSyncOptions
+	// Narration mixes voice-over over the music, which ducks under it.
+	Narration NarrationOptions
+	// ContactSheet writes a storyboard of the timeline before encoding.
+	ContactSheet ContactSheetOptions
+	// DryRun plans the timeline and prints the filter graph and ffmpeg command without encoding.
+	DryRun bool
+}
+
+// validateGenerateOptions checks the optional features before anything is rendered and
+// reports every problem at once. overlayText is set when the overlay style is used
+// (EXIF overlay, captions or the subtitle track).
+func validateGenerateOptions(opts GenerateOptions, overlayText bool) error {
+	errs := []error{
+		validateWatermark(opts.Watermark),
+		validateMusicDucking(opts.Music),
+		validateAudioOptions(opts.Audio),
+		validateVideoOptions(opts.Video, opts.Audio),
+		validateBeatSync(opts.Beats),
+		validateNarration(opts.Narration),
+	}
+	if overlayText {
+		errs = append(errs, validateOverlay(opts.Overlay))
+	}
+	if _, err := NormalizeChapterMode(opts.Chapters.Mode); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := NormalizeSubtitleText(opts.Subtitles.Text); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := NormalizeExportFormats(opts.Export.Formats); err != nil {
+		errs = append(errs, err)
+	}
+	if _, _, err := ParsePreviewRange(opts.Preview.Range); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := NormalizeMusicFit(opts.Music.Fit); err != nil {
+		errs = append(errs, err)
+	}
+	if opts.Music.Crossfade < 0 {
+		errs = append(errs, fmt.Errorf("music crossfade must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
 // GenerateVideo creates a video from converted images with crossfade transitions,
 // audio fades, and optionally a Ken Burns effect applied to each image.
 // If applyKenBurns is false, the images remain static.
 // If exifOverlay is true, camera info will be displayed in the footer with specified fontSize.
 // If fullHD is true, the output resolution will be Full HD (1920x1080) instead of 4K UHD (3840x2160).
-func GenerateVideo(duration, fadeDuration int, applyKenBurns, exifOverlay bool, fontSize int, fitAudio, includeVideos, keepVideoAudio, fullHD bool, fps int, orderByFilename, randomOrder bool, kenBurnsMode string) {
+// opts configures optional features such as the overlay text template.
+func GenerateVideo(duration, fadeDuration int, applyKenBurns, exifOverlay bool, fontSize int, fitAudio, includeVideos, keepVideoAudio, fullHD bool, fps int, orderByFilename, randomOrder bool, kenBurnsMode string, opts GenerateOptions) {
 	// Set active resolution based on the fullHD flag.
 	if fullHD {
 		activeResolution = resolutionFullHD
 	} else {
 		activeResolution = resolution4K
 	}
+	activeSourceResolution = activeResolution
 
 	if fps != 60 {
 		fps = 30
 	}
 	activeFPS = fps
+
+	if opts.Preview.Enabled {
+		activeResolution = resolutionPreview
+		activeFPS = previewFPS
+		// Sizes given for the requested resolution shrink with the frame.
+		fontSize = previewScaled(fontSize)
+		opts.Captions.FontSize = previewScaled(opts.Captions.FontSize)
+		opts.Overlay.MarginX = previewScaled(opts.Overlay.MarginX)
+		opts.Overlay.MarginY = previewScaled(opts.Overlay.MarginY)
+		opts.Watermark.Margin = previewScaled(opts.Watermark.Margin)
+	}
 	activeKenBurnsMode = normalizeKenBurnsMode(kenBurnsMode)
+	activeOptions = opts
+	activeRun = runState{}
+
+	if fadeDuration <= 0 {
+		log.Fatalf("transition duration must be greater than 0")
+	}
+	if err := validateGenerateOptions(opts, exifOverlay || opts.Captions.Enabled || opts.Subtitles.Enabled); err != nil {
+		log.Fatalf("%v", err)
+	}
+	tracks, err := ParseMusicTracks(opts.Music.Tracks)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	activeRun.MusicTracks = tracks
+	if opts.DryRun {
+		dir, err := os.MkdirTemp("", "go24k-dry-run-")
+		if err != nil {
+			log.Fatalf("Failed to create dry-run folder: %v", err)
+		}
+		activeRun.DryRunDir = filepath.ToSlash(dir)
+	}
 	outputFilename := outputVideoFilename()
 
+	// Source photos are indexed once; every item looks its original up in the index
+	activeRun.OriginalFiles = originalFilesByTimestamp()
+
 	durationSec := float64(duration)
 	fadeSec := float64(fadeDuration)
 
@@ -62,9 +322,8 @@ func GenerateVideo(duration, fadeDuration int, applyKenBurns, exifOverlay bool,
 		fmt.Printf("Both -random-order and -order-by-filename were set; using random order.\n")
 	}
 
-	if fadeSec <= 0 {
-		log.Fatalf("transition duration must be greater than 0")
-	}
+	musicFit := resolveMusicFit(activeOptions.Music.Fit, fitAudio)
+	fitAudio = fitAudio || musicFit == musicFitAudio
 
 	imageCount, videoCount, err := validateMediaInputs(mediaInputs, fadeSec)
 	if err != nil {
@@ -83,36 +342,114 @@ func GenerateVideo(duration, fadeDuration int, applyKenBurns, exifOverlay bool,
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
+	musicFiles = withoutNarrationFiles(musicFiles, mediaInputs)
+
+	mediaInputs, err = addTitleAndCreditsCards(mediaInputs, durationSec, musicFiles)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if musicSectioned() {
+		// Only the item ranges are checked here; the times follow once durations are final
+		if _, _, err := musicSectionTimes(mediaInputs, fadeSec, 0); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	// Narration lengths are known before fitting, so fit-audio and beat sync keep photos
+	// long enough for their recordings
+	itemNarration := collectItemNarration(mediaInputs)
 
 	durationSec, fadeSec, err = applyFitAudioSettings(mediaInputs, durationSec, fadeSec, fitAudio, musicFiles, videoCount)
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
 
+	beatCuts := applyBeatSync(mediaInputs, fadeSec, durationSec, musicFiles)
+	narration := prepareNarration(mediaInputs, itemNarration, fadeSec)
+
+	if applyKenBurns {
+		assignKenBurnsVariants(mediaInputs)
+	}
+
+	if activeOptions.Preview.Enabled {
+		// Items outside the window are replaced by black frames in the filter graph
+		last := len(mediaInputs) - 1
+		timelineLength := buildTimelineOffsets(mediaInputs, fadeSec)[last] + mediaInputs[last].SegmentDuration
+		if activeRun.PreviewStart, activeRun.PreviewEnd, err = previewWindow(timelineLength); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
 	inputs, filterComplex, finalLength := buildVideoFilterGraph(mediaInputs, fadeSec, applyKenBurns, exifOverlay, fontSize)
 
+	// The contact sheet comes before encoding so the order can be signed off early
+	if activeOptions.ContactSheet.Enabled {
+		sheets, err := writeContactSheet(mediaInputs, fadeSec, finalLength, outputFilename, applyKenBurns, keepVideoAudio)
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		} else {
+			fmt.Printf("Contact sheet written to %s\n", strings.Join(sheets, ", "))
+		}
+	}
+
 	// Setup audio processing
 	totalDuration := finalLength
-	audioConfig := setupAudioProcessing(inputs, mediaInputs, totalDuration, fadeSec, musicFiles, keepVideoAudio)
+	fitPlan := planMusicFit(musicFit, musicFiles, mediaInputs, fadeSec, finalLength)
+	audioConfig := setupAudioProcessing(inputs, mediaInputs, totalDuration, fadeSec, musicFiles, keepVideoAudio, fitPlan, narration)
+
+	// Previews and dry runs keep the single-pass loudness normalization
+	if activeOptions.Audio.TwoPass && audioConfig.MixLabel != "" && !activeOptions.DryRun && !activeOptions.Preview.Enabled {
+		applyTwoPassLoudness(&audioConfig)
+	}
 
 	// Add audio filter to filter complex if audio is present
 	if audioConfig.HasAudio {
 		filterComplex += audioConfig.AudioFilter
 	}
+	outputLength := finalLength
+	if activeRun.PreviewEnd > 0 {
+		filterComplex, audioConfig.MapArgs, outputLength = applyPreviewWindow(filterComplex, audioConfig.MapArgs, audioConfig.HasAudio)
+	}
 
 	// Write filter complex to a file to avoid Windows command line length limits
-	filterComplexFile := "filter_complex.txt"
+	filterComplexFile := generatedFile("filter_complex.txt")
 	if err := os.WriteFile(filterComplexFile, []byte(filterComplex), 0644); err != nil {
 		log.Fatalf("Failed to write filter complex file: %v", err)
 	}
-	defer os.Remove(filterComplexFile)
+	// A dry run keeps the files the printed command refers to, in activeRun.DryRunDir
+	if !activeOptions.DryRun {
+		defer os.Remove(filterComplexFile)
+	}
+
+	// Previews only review pacing: chapters and subtitles are left to the full render,
+	// so their files next to the real video are not overwritten.
+	var chapterInputs, chapterMapArgs, subtitleInputs, subtitleOutputArgs []string
+	if !activeOptions.Preview.Enabled {
+		// Chapter metadata is an extra ffmetadata input after all media and audio inputs
+		chapterInputs, chapterMapArgs = prepareChapters(mediaInputs, fadeSec, finalLength, countFFmpegInputs(audioConfig.Inputs))
+		if chapterInputs != nil && !activeOptions.DryRun {
+			defer os.Remove(chapterMetadataFile)
+		}
+
+		// The soft subtitle track follows as an SRT input muxed in the container's format
+		subtitleInputs, subtitleOutputArgs = prepareSubtitleTrack(mediaInputs, fadeSec, finalLength, outputFilename,
+			countFFmpegInputs(audioConfig.Inputs)+countFFmpegInputs(chapterInputs))
+	}
 
 	// Build complete FFmpeg command
 	args := []string{"-y"}
 	args = append(args, audioConfig.Inputs...)
+	args = append(args, chapterInputs...)
+	args = append(args, subtitleInputs...)
 	args = append(args, "-filter_complex_script", filterComplexFile)
 	args = append(args, audioConfig.MapArgs...)
-	args = append(args, getOptimalVideoSettings()...)
+	args = append(args, chapterMapArgs...)
+	args = append(args, subtitleOutputArgs...)
+	if activeOptions.Preview.Enabled {
+		args = append(args, previewVideoSettings()...)
+	} else {
+		args = append(args, getOptimalVideoSettings()...)
+	}
 
 	// Add audio encoding settings if audio is present, preserving input bitrate
 	if audioConfig.HasAudio {
@@ -120,26 +457,31 @@ func GenerateVideo(duration, fadeDuration int, applyKenBurns, exifOverlay bool,
 		if audioBitrateSource == "" && len(musicFiles) > 0 {
 			audioBitrateSource = musicFiles[0]
 		}
-		audioBitrate := "192k"
-		if audioBitrateSource != "" {
-			audioBitrate = getAudioBitrateStr(audioBitrateSource)
-		}
-		args = append(args, "-c:a", "aac", "-b:a", audioBitrate)
+		args = append(args, audioEncoderArgs(audioBitrateSource)...)
 	}
 
-	args = append(args, "-t", formatSeconds(finalLength))
+	args = append(args, "-t", formatSeconds(outputLength))
 	args = append(args, outputFilename)
 
+	if activeOptions.DryRun {
+		printDryRun(buildTimelineManifest(mediaInputs, fadeSec, finalLength, outputFilename, applyKenBurns, keepVideoAudio), filterComplex, filterComplexFile, args)
+		printBeatSyncSummary(beatCuts)
+		return
+	}
+
 	// Execute FFmpeg command
 	if err := runFFmpegCommand(args, audioConfig.HasAudio); err != nil {
 		log.Fatalf("Video generation failed: %v", err)
 	}
 
-	// Clean up concat file if it was created
-	if len(musicFiles) > 1 {
-		os.Remove("audio_concat.txt")
+	if !activeOptions.Preview.Enabled {
+		if err := writeTimelineManifest(buildTimelineManifest(mediaInputs, fadeSec, finalLength, outputFilename, applyKenBurns, keepVideoAudio)); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		exportTimelineFiles(mediaInputs, fadeSec, finalLength, outputFilename, musicFiles)
 	}
 
 	// Display final information
 	displayVideoInfo(outputFilename, finalLength)
+	printBeatSyncSummary(beatCuts)
 }
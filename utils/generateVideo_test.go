@@ -1,13 +1,20 @@
 package utils
 
 import (
+	"encoding/json"
+	"image/color"
+	"image/png"
+	"math"
 	"os"
+	"path/filepath"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/disintegration/imaging"
 )
 
 // TestIsWSL tests the WSL detection function
@@ -888,7 +895,7 @@ func TestSetupAudioProcessing_MixesMusicAndClipAudio(t *testing.T) {
 		{Path: "clip.mp4", IsImage: false, HasAudio: true, SegmentDuration: 12},
 	}
 
-	config := setupAudioProcessing([]string{"-loop", "1", "-t", "8", "-i", "converted/a.jpg", "-i", "clip.mp4"}, mediaInputs, 18, 2, []string{"soundtrack.mp3"}, true)
+	config := setupAudioProcessing([]string{"-loop", "1", "-t", "8", "-i", "converted/a.jpg", "-i", "clip.mp4"}, mediaInputs, 18, 2, []string{"soundtrack.mp3"}, true, musicFitPlan{}, nil)
 
 	if !config.HasAudio {
 		t.Fatal("expected mixed audio output to be enabled")
@@ -905,8 +912,59 @@ func TestSetupAudioProcessing_MixesMusicAndClipAudio(t *testing.T) {
 	if !strings.Contains(config.AudioFilter, "clipaudio0") {
 		t.Fatalf("expected delayed clip audio label in audio filter, got %s", config.AudioFilter)
 	}
-	if len(config.MapArgs) < 4 || config.MapArgs[3] != "[mixedaudio]" {
-		t.Fatalf("expected mixed audio mapping, got %v", config.MapArgs)
+	if len(config.MapArgs) < 4 || config.MapArgs[3] != "[audioout]" || config.MixLabel != "mixedaudio" {
+		t.Fatalf("expected the normalized mix to be mapped, got %v from %s", config.MapArgs, config.MixLabel)
+	}
+}
+
+func TestSetupAudioProcessing_DucksMusicUnderClipAudio(t *testing.T) {
+	oldMusic := activeOptions.Music
+	defer func() {
+		activeOptions.Music = oldMusic
+	}()
+	activeOptions.Music = MusicOptions{Ducking: "duck", DuckLevel: -20, DuckAttack: 10, DuckRelease: 500}
+
+	mediaInputs := []MediaInput{
+		{Path: "converted/a.jpg", IsImage: true, SegmentDuration: 8},
+		{Path: "clip.mp4", IsImage: false, HasAudio: true, SegmentDuration: 12},
+	}
+
+	config := setupAudioProcessing([]string{"-loop", "1", "-t", "8", "-i", "converted/a.jpg", "-i", "clip.mp4"}, mediaInputs, 18, 2, []string{"soundtrack.mp3"}, true, musicFitPlan{}, nil)
+
+	for _, want := range []string{
+		"[clipaudio0]asplit=2[clipmix][clipsidechain]; ",
+		"[musicout][clipsidechain]sidechaincompress=threshold=0.003:ratio=20:attack=10:release=500:mix=0.9000[musicducked]; ",
+		"[musicducked][clipmix]amix=inputs=2",
+	} {
+		if !strings.Contains(config.AudioFilter, want) {
+			t.Errorf("audio filter missing %q: %s", want, config.AudioFilter)
+		}
+	}
+	if strings.Contains(config.AudioFilter, "musicmuted") {
+		t.Errorf("did not expect the mute expression in duck mode: %s", config.AudioFilter)
+	}
+	if len(config.MapArgs) < 4 || config.MapArgs[3] != "[audioout]" || config.MixLabel != "mixedaudio" {
+		t.Fatalf("expected the normalized mix to be mapped, got %v from %s", config.MapArgs, config.MixLabel)
+	}
+
+	activeOptions.Music = MusicOptions{Ducking: "duck"}
+	if filter := buildMusicDuckFilter("a", "b", "c"); !strings.Contains(filter, "attack=20:release=400:mix=0.8741") {
+		t.Errorf("expected default duck settings, got %s", filter)
+	}
+}
+
+func TestValidateMusicDucking(t *testing.T) {
+	valid := []MusicOptions{{}, {Ducking: "mute"}, {Ducking: "Duck", DuckLevel: -12, DuckAttack: 5, DuckRelease: 300}}
+	for _, options := range valid {
+		if err := validateMusicDucking(options); err != nil {
+			t.Errorf("validateMusicDucking(%+v) returned %v", options, err)
+		}
+	}
+	invalid := []MusicOptions{{Ducking: "pump"}, {Ducking: "duck", DuckLevel: 3}, {Ducking: "duck", DuckRelease: -1}}
+	for _, options := range invalid {
+		if err := validateMusicDucking(options); err == nil {
+			t.Errorf("expected validateMusicDucking(%+v) to fail", options)
+		}
 	}
 }
 
@@ -915,7 +973,7 @@ func TestSetupAudioProcessing_UsesClipAudioWithoutMusic(t *testing.T) {
 		{Path: "clip.mp4", IsImage: false, HasAudio: true, SegmentDuration: 10},
 	}
 
-	config := setupAudioProcessing([]string{"-i", "clip.mp4"}, mediaInputs, 10, 2, nil, true)
+	config := setupAudioProcessing([]string{"-i", "clip.mp4"}, mediaInputs, 10, 2, nil, true, musicFitPlan{}, nil)
 
 	if !config.HasAudio {
 		t.Fatal("expected clip audio to be preserved when requested")
@@ -926,8 +984,8 @@ func TestSetupAudioProcessing_UsesClipAudioWithoutMusic(t *testing.T) {
 	if strings.Contains(config.AudioFilter, "sidechaincompress") {
 		t.Fatalf("did not expect sidechaincompress without music, got %s", config.AudioFilter)
 	}
-	if len(config.MapArgs) < 4 || config.MapArgs[3] != "[clipaudio0]" {
-		t.Fatalf("expected clip audio mapping, got %v", config.MapArgs)
+	if len(config.MapArgs) < 4 || config.MapArgs[3] != "[audioout]" || config.MixLabel != "clipaudio0" {
+		t.Fatalf("expected normalized clip audio mapping, got %v from %s", config.MapArgs, config.MixLabel)
 	}
 }
 
@@ -973,3 +1031,1735 @@ func TestGetVideoDetails_ErrorHandling(t *testing.T) {
 		})
 	}
 }
+
+func TestParseVideoCameraInfo(t *testing.T) {
+	t.Run("Apple QuickTime tags", func(t *testing.T) {
+		output := []byte(`{"streams":[{"tags":{"creation_time":"2024-08-15T09:30:00.000000Z"}}],
+			"format":{"tags":{"com.apple.quicktime.make":"Apple","com.apple.quicktime.model":"iPhone 15 Pro",
+			"com.apple.quicktime.creationdate":"2024-08-15T10:30:00+0100",
+			"com.apple.quicktime.location.ISO6709":"+38.6916-009.2160+010.000/"}}}`)
+		info, err := parseVideoCameraInfo(output)
+		if err != nil {
+			t.Fatalf("parseVideoCameraInfo failed: %v", err)
+		}
+		if info.Make != "Apple" || info.Model != "iPhone 15 Pro" {
+			t.Errorf("unexpected device %q %q", info.Make, info.Model)
+		}
+		if info.DateTaken != "15/08/2024" || info.CapturedAt.Hour() != 10 {
+			t.Errorf("unexpected capture time %q %v", info.DateTaken, info.CapturedAt)
+		}
+		if info.Location != "38.6916°N, 9.2160°W" {
+			t.Errorf("unexpected location %q", info.Location)
+		}
+	})
+
+	t.Run("Android tags", func(t *testing.T) {
+		output := []byte(`{"format":{"tags":{"com.android.manufacturer":"Google","com.android.model":"Pixel 8",
+			"creation_time":"2024-01-02T03:04:05.000000Z","location":"-33.8688+151.2093/"}}}`)
+		info, err := parseVideoCameraInfo(output)
+		if err != nil {
+			t.Fatalf("parseVideoCameraInfo failed: %v", err)
+		}
+		if info.Make != "Google" || info.Model != "Pixel 8" {
+			t.Errorf("unexpected device %q %q", info.Make, info.Model)
+		}
+		if info.Location != "33.8688°S, 151.2093°E" {
+			t.Errorf("unexpected location %q", info.Location)
+		}
+	})
+
+	t.Run("No metadata", func(t *testing.T) {
+		info, err := parseVideoCameraInfo([]byte(`{"format":{}}`))
+		if err != nil {
+			t.Fatalf("parseVideoCameraInfo failed: %v", err)
+		}
+		if info.Model != "" || !info.CapturedAt.IsZero() || info.Location != "" {
+			t.Errorf("expected empty info, got %+v", info)
+		}
+	})
+}
+
+func TestAddTitleAndCreditsCards(t *testing.T) {
+	tempDir := t.TempDir()
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	os.Chdir(tempDir)
+
+	oldCards, oldResolution := activeOptions.Cards, activeResolution
+	defer func() {
+		activeOptions.Cards = oldCards
+		activeResolution = oldResolution
+	}()
+	activeResolution = resolutionFullHD
+
+	day := time.Date(2024, 8, 15, 10, 0, 0, 0, time.UTC)
+	mediaInputs := []MediaInput{
+		{Path: "converted/a.jpg", IsImage: true, SegmentDuration: 5, CapturedAt: day, HasCapturedAt: true},
+		{Path: "converted/b.jpg", IsImage: true, SegmentDuration: 5, CapturedAt: day.AddDate(0, 0, 3), HasCapturedAt: true},
+	}
+
+	activeOptions.Cards = CardOptions{}
+	if got, err := addTitleAndCreditsCards(mediaInputs, 5, nil); err != nil || len(got) != 2 {
+		t.Fatalf("expected timeline unchanged without cards, got %d items (err %v)", len(got), err)
+	}
+
+	activeOptions.Cards = CardOptions{Title: "Lisboa", Subtitle: "Agosto 2024", Credits: "Fotos: Ana"}
+	got, err := addTitleAndCreditsCards(mediaInputs, 5, nil)
+	if err != nil {
+		t.Fatalf("addTitleAndCreditsCards failed: %v", err)
+	}
+	if len(got) != 4 || !got[0].IsCard || got[0].Path != titleCardFile || !got[3].IsCard || got[3].Path != creditsCardFile {
+		t.Fatalf("expected title and credits cards around the timeline, got %+v", got)
+	}
+	if got[0].SegmentDuration != 5 || !got[0].IsImage {
+		t.Errorf("expected title card to be a 5s still image, got %+v", got[0])
+	}
+
+	file, err := os.Open(titleCardFile)
+	if err != nil {
+		t.Fatalf("title card not written: %v", err)
+	}
+	defer file.Close()
+	config, err := png.DecodeConfig(file)
+	if err != nil || config.Width != 1920 || config.Height != 1080 {
+		t.Errorf("expected 1920x1080 title card, got %dx%d (err %v)", config.Width, config.Height, err)
+	}
+
+	if dates := timelineDateRange(mediaInputs); dates != "15/08/2024 – 18/08/2024" {
+		t.Errorf("unexpected date range %q", dates)
+	}
+}
+
+func TestBuildVideoFilterGraph_CardsSkipMotion(t *testing.T) {
+	oldResolution, oldFPS := activeResolution, activeFPS
+	defer func() {
+		activeResolution = oldResolution
+		activeFPS = oldFPS
+	}()
+	activeResolution = resolution4K
+	activeFPS = 30
+
+	mediaInputs := []MediaInput{
+		{Path: titleCardFile, IsImage: true, IsCard: true, SegmentDuration: 5},
+		{Path: "converted/a.jpg", IsImage: true, SegmentDuration: 5},
+	}
+	_, filterComplex, finalLength := buildVideoFilterGraph(mediaInputs, 1, true, false, 48)
+
+	cardFilter := strings.SplitN(filterComplex, "[v0];", 2)[0]
+	if strings.Contains(cardFilter, "zoompan") || strings.Contains(cardFilter, "crop") {
+		t.Errorf("expected no Ken Burns on the title card, got %q", cardFilter)
+	}
+	if !strings.Contains(filterComplex, "[v0][v1]xfade=transition=fade") {
+		t.Errorf("expected the card to join the crossfade chain, got %q", filterComplex)
+	}
+	if finalLength != 9 {
+		t.Errorf("expected final length 9, got %v", finalLength)
+	}
+}
+
+func TestParseMusicTrackTitle(t *testing.T) {
+	tests := []struct {
+		output   string
+		expected string
+	}{
+		{`{"format":{"tags":{"title":"Saudade","artist":"Ana Moura"}}}`, "Ana Moura – Saudade"},
+		{`{"format":{"tags":{"TITLE":"Saudade"}}}`, "Saudade"},
+		{`{"format":{}}`, "track01"},
+		{`{"format":{},"streams":[{"tags":{"TITLE":"Fado","ARTIST":"Mariza"}}]}`, "Mariza – Fado"},
+		{`not json`, "track01"},
+	}
+	for _, tt := range tests {
+		if got := parseMusicTrackTitle([]byte(tt.output), "track01"); got != tt.expected {
+			t.Errorf("parseMusicTrackTitle(%s) = %q, want %q", tt.output, got, tt.expected)
+		}
+	}
+}
+
+func TestCameraDisplayName(t *testing.T) {
+	if got := cameraDisplayName(&CameraInfo{Make: "Canon", Model: "Canon EOS R5"}); got != "Canon EOS R5" {
+		t.Errorf("unexpected name %q", got)
+	}
+	if got := cameraDisplayName(&CameraInfo{Make: "Sony", Model: "ILCE-7M4"}); got != "Sony ILCE-7M4" {
+		t.Errorf("unexpected name %q", got)
+	}
+}
+
+func TestBuildVideoFilterGraph_Watermark(t *testing.T) {
+	oldResolution, oldFPS, oldWatermark := activeResolution, activeFPS, activeOptions.Watermark
+	defer func() {
+		activeResolution = oldResolution
+		activeFPS = oldFPS
+		activeOptions.Watermark = oldWatermark
+	}()
+	activeResolution = resolutionFullHD
+	activeFPS = 30
+	activeOptions.Watermark = WatermarkOptions{File: "logo.png", Position: "top-left", Scale: 0.15, Opacity: 0.5, Fade: true}
+
+	mediaInputs := []MediaInput{
+		{Path: "converted/a.jpg", IsImage: true, SegmentDuration: 5},
+		{Path: "converted/b.jpg", IsImage: true, SegmentDuration: 5},
+	}
+	inputs, filterComplex, finalLength := buildVideoFilterGraph(mediaInputs, 1, false, false, 48)
+
+	if countFFmpegInputs(inputs) != 3 || inputs[len(inputs)-1] != "logo.png" {
+		t.Fatalf("expected logo as the third input, got %v", inputs)
+	}
+	for _, want := range []string{
+		"fade=t=out:st=8.000:d=1.000[xfbase]; ",
+		"[2:v]fps=30,settb=AVTB,format=rgba,scale=288:-1:flags=lanczos,colorchannelmixer=aa=0.5",
+		"fade=t=out:st=8.000:d=1.000:alpha=1[wm]",
+		"[xfbase][wm]overlay=x=30:y=30:format=auto,format=yuv420p[xfout]; ",
+	} {
+		if !strings.Contains(filterComplex, want) {
+			t.Errorf("expected %q in %q", want, filterComplex)
+		}
+	}
+	if finalLength != 9 {
+		t.Errorf("expected final length 9, got %v", finalLength)
+	}
+
+	activeOptions.Watermark = WatermarkOptions{}
+	_, filterComplex, _ = buildVideoFilterGraph(mediaInputs, 1, false, false, 48)
+	if strings.Contains(filterComplex, "[wm]") || !strings.HasSuffix(filterComplex, "[xfout]; ") {
+		t.Errorf("expected no watermark without a logo, got %q", filterComplex)
+	}
+}
+
+func TestValidateWatermark(t *testing.T) {
+	logo := t.TempDir() + "/logo.png"
+	if err := os.WriteFile(logo, []byte("png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateWatermark(WatermarkOptions{}); err != nil {
+		t.Errorf("expected no error without watermark, got %v", err)
+	}
+	if err := validateWatermark(WatermarkOptions{File: logo, Position: "bottom-right", Scale: 0.2, Opacity: 1}); err != nil {
+		t.Errorf("expected valid watermark, got %v", err)
+	}
+	if err := validateWatermark(WatermarkOptions{File: logo, Position: "nowhere"}); err == nil {
+		t.Error("expected error for invalid position")
+	}
+	if err := validateWatermark(WatermarkOptions{File: logo, Scale: 1.5, Opacity: 0.8}); err == nil {
+		t.Error("expected error for scale above 1")
+	}
+	if err := validateWatermark(WatermarkOptions{File: logo, Scale: 0, Opacity: 0.8}); err == nil {
+		t.Error("expected error for a scale of zero")
+	}
+	if err := validateWatermark(WatermarkOptions{File: logo, Scale: 0.15, Opacity: 0}); err == nil {
+		t.Error("expected error for an opacity of zero")
+	}
+	if err := validateWatermark(WatermarkOptions{File: "missing.png"}); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestBuildChapters(t *testing.T) {
+	oldChapters := activeOptions.Chapters
+	defer func() { activeOptions.Chapters = oldChapters }()
+
+	day1 := time.Date(2024, 8, 15, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 8, 16, 9, 0, 0, 0, time.UTC)
+	mediaInputs := []MediaInput{
+		{Path: titleCardFile, IsImage: true, IsCard: true, SegmentDuration: 5},
+		{Path: "a.mp4", SegmentDuration: 10, CapturedAt: day1, HasCapturedAt: true},
+		{Path: "b.mp4", SegmentDuration: 10, CapturedAt: day1.Add(30 * time.Minute), HasCapturedAt: true},
+		{Path: "c.mp4", SegmentDuration: 10, CapturedAt: day1.Add(5 * time.Hour), HasCapturedAt: true},
+		{Path: "d.mp4", SegmentDuration: 10},
+		{Path: "e.mp4", SegmentDuration: 10, CapturedAt: day2, HasCapturedAt: true},
+	}
+	offsets := buildTimelineOffsets(mediaInputs, 1)
+
+	summarize := func(chapters []Chapter) string {
+		var parts []string
+		for _, c := range chapters {
+			parts = append(parts, c.Title+"@"+strconv.FormatFloat(c.Start, 'f', -1, 64)+"-"+strconv.FormatFloat(c.End, 'f', -1, 64))
+		}
+		return strings.Join(parts, "|")
+	}
+
+	tests := []struct {
+		mode     string
+		every    int
+		expected string
+	}{
+		{"none", 0, ""},
+		{"day", 0, "15/08/2024@0-40|16/08/2024@40-50"},
+		{"cluster", 0, "15/08/2024 10:00@0-22|15/08/2024 15:00@22-40|16/08/2024 09:00@40-50"},
+		{"every", 2, "Part 1@0-22|Part 2@22-40|Part 3@40-50"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			activeOptions.Chapters = ChapterOptions{Mode: tt.mode, Every: tt.every}
+			if got := summarize(buildChapters(mediaInputs, offsets, 50)); got != tt.expected {
+				t.Errorf("buildChapters(%s) = %q, want %q", tt.mode, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatChapterFiles(t *testing.T) {
+	chapters := []Chapter{
+		{Title: "Dia 1; praia=sol", Start: 0, End: 72.5},
+		{Title: "Dia 2", Start: 72.5, End: 3725},
+		{Title: "Final", Start: 3725, End: 3800},
+	}
+
+	metadata := formatFFMetadataChapters(chapters)
+	for _, want := range []string{";FFMETADATA1\n", "[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=72500\ntitle=Dia 1\\; praia\\=sol\n", "START=3725000\nEND=3800000\n"} {
+		if !strings.Contains(metadata, want) {
+			t.Errorf("expected %q in ffmetadata:\n%s", want, metadata)
+		}
+	}
+
+	expected := "00:00 Dia 1; praia=sol\n01:12 Dia 2\n1:02:05 Final\n"
+	if got := formatYouTubeChapters(chapters); got != expected {
+		t.Errorf("formatYouTubeChapters() = %q, want %q", got, expected)
+	}
+}
+
+func TestNormalizeChapterMode(t *testing.T) {
+	for input, expected := range map[string]string{"": "none", "DAY": "day", "event": "cluster", "every": "every", "folder": "folder"} {
+		if got, err := NormalizeChapterMode(input); err != nil || got != expected {
+			t.Errorf("NormalizeChapterMode(%q) = %q, %v; want %q", input, got, err, expected)
+		}
+	}
+	if _, err := NormalizeChapterMode("weekly"); err == nil {
+		t.Error("expected error for unknown chapter mode")
+	}
+}
+
+func TestBuildSubtitleCues(t *testing.T) {
+	mediaInputs := []MediaInput{
+		{Path: titleCardFile, IsImage: true, IsCard: true, SegmentDuration: 5},
+		{Path: "converted/a.jpg", IsImage: true, SegmentDuration: 5},
+		{Path: "converted/b.jpg", IsImage: true, SegmentDuration: 5},
+		{Path: "clip.mp4", SegmentDuration: 8},
+	}
+	texts := []string{"", "Praia <3 & sol", "", "Canon R6\nLisboa"}
+
+	cues := buildSubtitleCues(mediaInputs, texts, 1, 20)
+	if len(cues) != 2 {
+		t.Fatalf("expected 2 cues, got %+v", cues)
+	}
+	if cues[0].Start != 4.5 || cues[0].End != 8.5 {
+		t.Errorf("unexpected first cue timing %+v", cues[0])
+	}
+	if cues[1].Start != 12.5 || cues[1].End != 20 {
+		t.Errorf("unexpected last cue timing %+v", cues[1])
+	}
+
+	srt := formatSRT(cues)
+	expectedSRT := "1\n00:00:04,500 --> 00:00:08,500\nPraia <3 & sol\n\n2\n00:00:12,500 --> 00:00:20,000\nCanon R6\nLisboa\n\n"
+	if srt != expectedSRT {
+		t.Errorf("formatSRT() = %q, want %q", srt, expectedSRT)
+	}
+
+	vtt := formatWebVTT(cues)
+	if !strings.HasPrefix(vtt, "WEBVTT\n\n00:00:04.500 --> 00:00:08.500\nPraia &lt;3 &amp; sol\n\n") {
+		t.Errorf("unexpected WebVTT output %q", vtt)
+	}
+}
+
+func TestPrepareSubtitleTrack(t *testing.T) {
+	tempDir := t.TempDir()
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	os.Chdir(tempDir)
+
+	oldSubtitles := activeOptions.Subtitles
+	defer func() { activeOptions.Subtitles = oldSubtitles }()
+
+	if err := os.WriteFile("clip.mp4.txt", []byte("Mergulho: 12m"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mediaInputs := []MediaInput{
+		{Path: "clip.mp4", SegmentDuration: 8},
+		{Path: "other.mp4", SegmentDuration: 8},
+	}
+
+	activeOptions.Subtitles = SubtitleOptions{}
+	if inputs, outputs := prepareSubtitleTrack(mediaInputs, 1, 15, "video_uhd.mp4", 3); inputs != nil || outputs != nil {
+		t.Fatalf("expected no subtitle track when disabled, got %v %v", inputs, outputs)
+	}
+
+	activeOptions.Subtitles = SubtitleOptions{Enabled: true, Text: "caption"}
+	inputs, outputs := prepareSubtitleTrack(mediaInputs, 1, 15, "video_uhd.mp4", 3)
+	if strings.Join(inputs, " ") != "-f srt -i video_uhd.srt" {
+		t.Errorf("unexpected subtitle inputs %v", inputs)
+	}
+	if !strings.Contains(strings.Join(outputs, " "), "-map 3:s -c:s mov_text") {
+		t.Errorf("unexpected subtitle output args %v", outputs)
+	}
+	srt, err := os.ReadFile("video_uhd.srt")
+	if err != nil || string(srt) != "1\n00:00:00,000 --> 00:00:07,500\nMergulho: 12m\n\n" {
+		t.Errorf("unexpected SRT file %q (err %v)", srt, err)
+	}
+	if _, err := os.Stat("video_uhd.vtt"); err != nil {
+		t.Errorf("expected WebVTT file: %v", err)
+	}
+}
+
+func TestBuildTimelineManifest(t *testing.T) {
+	oldResolution, oldFPS := activeResolution, activeFPS
+	defer func() {
+		activeResolution = oldResolution
+		activeFPS = oldFPS
+	}()
+	activeResolution = resolution4K
+	activeFPS = 30
+
+	captured := time.Date(2024, 8, 15, 10, 30, 0, 0, time.UTC)
+	mediaInputs := []MediaInput{
+		{Path: titleCardFile, IsImage: true, IsCard: true, SegmentDuration: 5},
+		{Path: "converted/nonexistent_uhd.jpg", IsImage: true, SegmentDuration: 5, KenBurnsVariant: "zoom-pan-left", CapturedAt: captured, HasCapturedAt: true},
+		{Path: "clip.mp4", HasAudio: true, SegmentDuration: 223},
+	}
+
+	manifest := buildTimelineManifest(mediaInputs, 1, 231, "video_uhd.mp4", true, true)
+	if manifest.Transition.Type != "fade" || manifest.Transition.Duration != 1 || manifest.FPS != 30 || manifest.Duration != 231 {
+		t.Fatalf("unexpected manifest header %+v", manifest)
+	}
+	if len(manifest.Items) != 3 {
+		t.Fatalf("expected 3 items, got %d", len(manifest.Items))
+	}
+
+	title, photo, clip := manifest.Items[0], manifest.Items[1], manifest.Items[2]
+	if title.Type != "title" || title.TransitionIn != "fade-in" || title.Original != "" {
+		t.Errorf("unexpected title item %+v", title)
+	}
+	if photo.Type != "image" || photo.Start != 4 || photo.End != 9 || photo.KenBurns != "zoom-pan-left" || photo.CapturedAt != "2024-08-15T10:30:00Z" {
+		t.Errorf("unexpected photo item %+v", photo)
+	}
+	if clip.Type != "video" || clip.Original != "clip.mp4" || clip.StartTimecode != "00:00:08.000" || clip.EndTimecode != "00:03:51.000" || clip.TransitionOut != "fade-out" || !clip.KeepsClipAudio {
+		t.Errorf("unexpected clip item %+v", clip)
+	}
+
+	if got := timelineManifestFilename("video_uhd.mp4"); got != "video_uhd.timeline.json" {
+		t.Errorf("unexpected manifest filename %q", got)
+	}
+}
+
+func TestGetKenBurnsEffectVariant(t *testing.T) {
+	oldMode, oldResolution, oldFPS := activeKenBurnsMode, activeResolution, activeFPS
+	defer func() {
+		activeKenBurnsMode = oldMode
+		activeResolution = oldResolution
+		activeFPS = oldFPS
+	}()
+	activeKenBurnsMode = kenBurnsModeHigh
+	activeResolution = resolution4K
+	activeFPS = 30
+
+	effect := getKenBurnsEffectVariant(5, "zoom-pan-up-left")
+	if !strings.Contains(effect, "x='iw/2-(iw/zoom/2)-154'") || !strings.Contains(effect, "y='ih/2-(ih/zoom/2)-84'") {
+		t.Errorf("unexpected effect for zoom-pan-up-left: %s", effect)
+	}
+
+	mediaInputs := []MediaInput{
+		{Path: titleCardFile, IsImage: true, IsCard: true},
+		{Path: "converted/a.jpg", IsImage: true},
+		{Path: "clip.mp4"},
+	}
+	assignKenBurnsVariants(mediaInputs)
+	if mediaInputs[0].KenBurnsVariant != "" || mediaInputs[2].KenBurnsVariant != "" || mediaInputs[1].KenBurnsVariant == "" {
+		t.Errorf("expected a variant only for the photo, got %+v", mediaInputs)
+	}
+}
+
+func TestNormalizeExportFormats(t *testing.T) {
+	formats, err := NormalizeExportFormats(" EDL, all ,otio")
+	if err != nil || strings.Join(formats, ",") != "edl,fcpxml,otio" {
+		t.Errorf("unexpected formats %v (err %v)", formats, err)
+	}
+	if formats, err := NormalizeExportFormats(""); err != nil || len(formats) != 0 {
+		t.Errorf("expected no formats for empty value, got %v (err %v)", formats, err)
+	}
+	if _, err := NormalizeExportFormats("fcpxml,aaf"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func TestTimelineExportFormats(t *testing.T) {
+	oldResolution, oldFPS := activeResolution, activeFPS
+	defer func() {
+		activeResolution = oldResolution
+		activeFPS = oldFPS
+	}()
+	activeResolution = resolution4K
+	activeFPS = 30
+
+	mediaInputs := []MediaInput{
+		{Path: titleCardFile, IsImage: true, IsCard: true, SegmentDuration: 5},
+		{Path: "converted/a & b_uhd.jpg", IsImage: true, SegmentDuration: 5},
+		{Path: "clip.mp4", HasAudio: true, SegmentDuration: 10},
+	}
+	timeline := buildExportTimeline(mediaInputs, 1, 18, "video_uhd.mp4", []string{"song.mp3", "song2.mp3"}, []float64{10, 0}, nil)
+
+	photo := timeline.Clips[1]
+	if photo.RecordStart != 4.5 || photo.RecordEnd != 8.5 || photo.SourceIn != 0.5 {
+		t.Errorf("unexpected photo range %+v", photo)
+	}
+	if len(timeline.Music) != 2 || timeline.Music[1].Start != 10 || timeline.Music[1].Duration != 8 {
+		t.Errorf("unexpected music lane %+v", timeline.Music)
+	}
+
+	edl := formatEDL(timeline)
+	for _, want := range []string{
+		"TITLE: video_uhd\nFCM: NON-DROP FRAME",
+		"001  AX       V     C        00:00:00:00 00:00:04:00 00:00:00:00 00:00:04:00",
+		"002  AX       V     C        00:00:04:00 00:00:04:00 00:00:04:00 00:00:04:00",
+		"002  AX       V     D    030 00:00:00:00 00:00:04:00 00:00:04:00 00:00:08:00",
+		"003  AX       V     D    030 00:00:00:00 00:00:10:00 00:00:08:00 00:00:18:00",
+		"005  AX       AA    C        00:00:00:00 00:00:08:00 00:00:10:00 00:00:18:00",
+		"* TO CLIP NAME: clip.mp4",
+	} {
+		if !strings.Contains(edl, want) {
+			t.Errorf("EDL missing %q:\n%s", want, edl)
+		}
+	}
+
+	fcpxml := formatFCPXML(timeline)
+	for _, want := range []string{
+		`<sequence format="r0" duration="540/30s"`,
+		`<transition name="Cross Dissolve" offset="120/30s" duration="30/30s">`,
+		`name="a &amp; b_uhd.jpg" start="15/30s" duration="120/30s"/>`,
+		`<asset-clip ref="r4" lane="-1" offset="300/30s" name="song2.mp3" start="0s" duration="240/30s" audioRole="music"/>`,
+		`src="file:///`,
+		`hasAudio="1" audioSources="1" audioChannels="2">`,
+	} {
+		if !strings.Contains(fcpxml, want) {
+			t.Errorf("FCPXML missing %q:\n%s", want, fcpxml)
+		}
+	}
+
+	otio, err := formatOTIO(timeline)
+	if err != nil {
+		t.Fatalf("formatOTIO failed: %v", err)
+	}
+	var decoded struct {
+		Schema string `json:"OTIO_SCHEMA"`
+		Tracks struct {
+			Children []struct {
+				Kind     string `json:"kind"`
+				Children []struct {
+					Schema      string `json:"OTIO_SCHEMA"`
+					SourceRange struct {
+						StartTime struct{ Value float64 } `json:"start_time"`
+						Duration  struct{ Value float64 } `json:"duration"`
+					} `json:"source_range"`
+				} `json:"children"`
+			} `json:"children"`
+		} `json:"tracks"`
+	}
+	if err := json.Unmarshal([]byte(otio), &decoded); err != nil {
+		t.Fatalf("invalid OTIO JSON: %v", err)
+	}
+	if decoded.Schema != "Timeline.1" || len(decoded.Tracks.Children) != 2 {
+		t.Fatalf("unexpected OTIO timeline %+v", decoded)
+	}
+	video := decoded.Tracks.Children[0].Children
+	if len(video) != 5 || video[1].Schema != "Transition.1" || video[2].SourceRange.StartTime.Value != 15 || video[2].SourceRange.Duration.Value != 120 {
+		t.Errorf("unexpected OTIO video track %+v", video)
+	}
+	if decoded.Tracks.Children[1].Kind != "Audio" || len(decoded.Tracks.Children[1].Children) != 2 {
+		t.Errorf("unexpected OTIO audio track %+v", decoded.Tracks.Children[1])
+	}
+}
+
+func TestCollectMediaInputs_DryRunPlansConvertedImages(t *testing.T) {
+	tempDir := t.TempDir()
+	oldWd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd failed: %v", err)
+	}
+	oldDryRun, oldSource := activeOptions.DryRun, activeSourceResolution
+	defer func() {
+		_ = os.Chdir(oldWd)
+		activeOptions.DryRun = oldDryRun
+		activeSourceResolution = oldSource
+	}()
+
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("Chdir failed: %v", err)
+	}
+	for _, name := range []string{"b.jpg", "a.jpg"} {
+		if err := os.WriteFile(name, []byte("not exif"), 0644); err != nil {
+			t.Fatalf("WriteFile(%s) failed: %v", name, err)
+		}
+	}
+	activeSourceResolution = resolutionFullHD
+
+	activeOptions.DryRun = false
+	if _, err := collectMediaInputs(5, false, false, false); err == nil {
+		t.Fatalf("expected an error without converted images outside a dry run")
+	}
+
+	activeOptions.DryRun = true
+	media, err := collectMediaInputs(5, false, false, false)
+	if err != nil {
+		t.Fatalf("collectMediaInputs returned error: %v", err)
+	}
+	if len(media) != 2 || media[0].Path != filepath.Join("converted", "a_fhd.jpg") || media[1].Path != filepath.Join("converted", "b_fhd.jpg") {
+		t.Errorf("unexpected planned media %+v", media)
+	}
+	if _, err := os.Stat("converted"); !os.IsNotExist(err) {
+		t.Errorf("dry run must not create the converted folder")
+	}
+}
+
+func TestDryRunWritesCardsToItsOwnFolder(t *testing.T) {
+	tempDir := t.TempDir()
+	dryRunDir := t.TempDir()
+	originalDir, _ := os.Getwd()
+	defer os.Chdir(originalDir)
+	os.Chdir(tempDir)
+
+	oldCards, oldResolution, oldDryRunDir := activeOptions.Cards, activeResolution, activeRun.DryRunDir
+	defer func() {
+		activeOptions.Cards = oldCards
+		activeResolution = oldResolution
+		activeRun.DryRunDir = oldDryRunDir
+	}()
+	activeResolution = resolutionFullHD
+	activeRun.DryRunDir = filepath.ToSlash(dryRunDir)
+	activeOptions.Cards = CardOptions{Title: "Lisboa", Credits: "Fotos: Ana"}
+
+	mediaInputs := []MediaInput{{Path: "converted/a.jpg", IsImage: true, SegmentDuration: 5}}
+	got, err := addTitleAndCreditsCards(mediaInputs, 5, nil)
+	if err != nil {
+		t.Fatalf("addTitleAndCreditsCards returned %v", err)
+	}
+	for _, card := range []MediaInput{got[0], got[2]} {
+		if filepath.Dir(card.Path) != filepath.Clean(dryRunDir) {
+			t.Errorf("card %s must be written to the dry-run folder %s", card.Path, activeRun.DryRunDir)
+		}
+		if _, err := os.Stat(card.Path); err != nil {
+			t.Errorf("card %s was not rendered: %v", card.Path, err)
+		}
+	}
+	if timelineItemType(got[2]) != "credits" {
+		t.Errorf("credits card in the dry-run folder typed as %q", timelineItemType(got[2]))
+	}
+	if _, err := os.Stat("converted"); !os.IsNotExist(err) {
+		t.Errorf("dry run must not create the converted folder")
+	}
+	if got := generatedFile("filter_complex.txt"); got != activeRun.DryRunDir+"/filter_complex.txt" {
+		t.Errorf("generatedFile = %s, want it inside the dry-run folder", got)
+	}
+
+	printDryRun(TimelineManifest{}, "", generatedFile("filter_complex.txt"), []string{"-y", "out.mp4"})
+	if _, err := os.Stat(filepath.Join(dryRunDir, dryRunCommandFile)); err != nil {
+		t.Errorf("command line not written to the dry-run folder: %v", err)
+	}
+	if _, err := os.Stat(dryRunCommandFile); !os.IsNotExist(err) {
+		t.Errorf("dry run must not write %s to the working folder", dryRunCommandFile)
+	}
+}
+
+func TestFormatTimelineTable(t *testing.T) {
+	manifest := TimelineManifest{
+		Duration:   9,
+		Transition: TimelineTransition{Type: "fade", Duration: 1},
+		Items: []TimelineManifestItem{
+			{Index: 1, Type: "title", Source: titleCardFile, StartTimecode: "00:00:00.000", EndTimecode: "00:00:05.000", SegmentDuration: 5},
+			{Index: 2, Type: "image", Source: "converted/a_uhd.jpg", Original: "a.jpg", StartTimecode: "00:00:04.000", EndTimecode: "00:00:09.000", KenBurns: "zoom-pan-up", SegmentDuration: 5},
+		},
+	}
+	table := formatTimelineTable(manifest)
+	lines := strings.Split(table, "\n")
+	if !strings.HasPrefix(lines[0], "#  Start") || strings.Join(strings.Fields(lines[2]), " ") != "2 00:00:04.000 00:00:09.000 5.00s image converted/a_uhd.jpg a.jpg zoom-pan-up" {
+		t.Errorf("unexpected table:\n%s", table)
+	}
+	if !strings.HasSuffix(strings.Join(strings.Fields(lines[1]), " "), "title converted/title_card.png - -") {
+		t.Errorf("expected placeholders for the card row:\n%s", table)
+	}
+	if !strings.Contains(table, "Total: 2 items, 00:00:09.000 (9.00s), fade crossfades of 1.00s") {
+		t.Errorf("unexpected total line:\n%s", table)
+	}
+}
+
+func TestFormatCommandLine(t *testing.T) {
+	got := formatCommandLine("ffmpeg", []string{"-y", "-i", "converted/a b.jpg", "-metadata:s:s:0", "handler_name=It's", "out.mp4"})
+	want := `ffmpeg -y -i 'converted/a b.jpg' '-metadata:s:s:0' 'handler_name=It'\''s' out.mp4`
+	if got != want {
+		t.Errorf("formatCommandLine = %s, want %s", got, want)
+	}
+}
+
+func TestParsePreviewRange(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end float64
+		wantErr    bool
+	}{
+		{value: "", start: 0, end: 0},
+		{value: "60s-120s", start: 60, end: 120},
+		{value: "1:00-2:30", start: 60, end: 150},
+		{value: "0:01:05-75.5", start: 65, end: 75.5},
+		{value: "90s-", start: 90, end: 0},
+		{value: "-30", start: 0, end: 30},
+		{value: "120-60", wantErr: true},
+		{value: "60s", wantErr: true},
+		{value: "a-b", wantErr: true},
+	}
+	for _, tt := range tests {
+		start, end, err := ParsePreviewRange(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePreviewRange(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (start != tt.start || end != tt.end) {
+			t.Errorf("ParsePreviewRange(%q) = %v, %v; want %v, %v", tt.value, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestPreviewRender(t *testing.T) {
+	oldPreview, oldResolution, oldSource, oldFPS, oldMode := activeOptions.Preview, activeResolution, activeSourceResolution, activeFPS, activeKenBurnsMode
+	defer func() {
+		activeOptions.Preview = oldPreview
+		activeResolution = oldResolution
+		activeSourceResolution = oldSource
+		activeFPS = oldFPS
+		activeKenBurnsMode = oldMode
+	}()
+	activeOptions.Preview = PreviewOptions{Enabled: true, Range: "60s-"}
+	activeResolution = resolutionPreview
+	activeSourceResolution = resolution4K
+	activeFPS = previewFPS
+	activeKenBurnsMode = kenBurnsModeHigh
+
+	static := processImageFilter("converted/a_uhd.jpg", 1, 1, 2, 5, 1, false, false, 0, "")
+	if static != "[1:v]scale=640:360,fps=30,settb=AVTB,setsar=1,format=yuv420p" {
+		t.Errorf("unexpected static preview filter: %s", static)
+	}
+	motion := processImageFilter("converted/a_uhd.jpg", 1, 1, 2, 5, 1, true, false, 0, "zoom-pan-up-left")
+	if !strings.HasPrefix(motion, "[1:v]scale=640:360,zoompan=") || !strings.Contains(motion, "x='iw/2-(iw/zoom/2)-13'") || !strings.Contains(motion, ":s=640x360,") {
+		t.Errorf("expected an unsupersampled preview zoompan, got: %s", motion)
+	}
+
+	if got := previewScaled(48); got != 8 {
+		t.Errorf("previewScaled(48) = %d, want 8", got)
+	}
+	if got := outputVideoFilename(); got != outputVideoPreview {
+		t.Errorf("outputVideoFilename() = %s, want %s", got, outputVideoPreview)
+	}
+
+	start, end, err := previewWindow(100)
+	if err != nil || start != 60 || end != 100 {
+		t.Errorf("previewWindow(100) = %v, %v (err %v); want 60, 100", start, end, err)
+	}
+	activeOptions.Preview.Range = "120s-180s"
+	if _, _, err := previewWindow(100); err == nil {
+		t.Errorf("expected error for a range past the end of the video")
+	}
+	activeOptions.Preview.Range = ""
+	if start, end, _ := previewWindow(100); start != 0 || end != 0 {
+		t.Errorf("expected no window without a range, got %v, %v", start, end)
+	}
+}
+
+func TestPreviewWindowFilterGraph(t *testing.T) {
+	oldRun, oldResolution, oldFPS := activeRun, activeResolution, activeFPS
+	defer func() {
+		activeRun = oldRun
+		activeResolution = oldResolution
+		activeFPS = oldFPS
+	}()
+	activeResolution = resolutionPreview
+	activeFPS = previewFPS
+	activeRun = runState{PreviewStart: 10, PreviewEnd: 14}
+
+	if !previewSkipsItem(0, 5) || !previewSkipsItem(14, 5) || previewSkipsItem(4, 7) || previewSkipsItem(12, 5) {
+		t.Errorf("unexpected previewSkipsItem results for the 10s-14s window")
+	}
+	if got := previewPlaceholderFilter(5); got != "color=c=black:s=640x360:r=30:d=5.000,settb=AVTB,setsar=1,format=yuv420p" {
+		t.Errorf("unexpected placeholder filter: %s", got)
+	}
+
+	filterComplex, mapArgs, length := applyPreviewWindow("", []string{"-map", "[xfout]", "-map", "[audioout]", "-shortest"}, true)
+	if !strings.Contains(filterComplex, "[xfout]trim=start=10.000:end=14.000,setpts=PTS-STARTPTS[xfpreview]; ") ||
+		!strings.Contains(filterComplex, "[audioout]atrim=start=10.000:end=14.000,asetpts=PTS-STARTPTS[audiopreview]; ") {
+		t.Errorf("expected the window to be trimmed in the graph, got: %s", filterComplex)
+	}
+	if strings.Join(mapArgs, " ") != "-map [xfpreview] -map [audiopreview] -shortest" || length != 4 {
+		t.Errorf("unexpected preview mapping %v, length %v", mapArgs, length)
+	}
+	if filterComplex, _, _ := applyPreviewWindow("", []string{"-map", "[xfout]"}, false); strings.Contains(filterComplex, "atrim") {
+		t.Errorf("expected no audio trim without audio, got: %s", filterComplex)
+	}
+}
+
+func TestWriteContactSheet(t *testing.T) {
+	tempDir := t.TempDir()
+	oldWd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd failed: %v", err)
+	}
+	oldSheet, oldOverlay := activeOptions.ContactSheet, activeOptions.Overlay
+	defer func() {
+		_ = os.Chdir(oldWd)
+		activeOptions.ContactSheet = oldSheet
+		activeOptions.Overlay = oldOverlay
+	}()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("Chdir failed: %v", err)
+	}
+	if err := os.Mkdir("converted", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	red := color.NRGBA{255, 0, 0, 255}
+	for _, name := range []string{"converted/a_uhd.png", "converted/b_uhd.png"} {
+		if err := imaging.Save(imaging.New(320, 180, red), name); err != nil {
+			t.Fatalf("Save(%s) failed: %v", name, err)
+		}
+	}
+	activeOptions.Overlay = OverlayOptions{}
+	activeOptions.ContactSheet = ContactSheetOptions{Enabled: true, Columns: 2, Rows: 1}
+
+	mediaInputs := []MediaInput{
+		{Path: "converted/a_uhd.png", IsImage: true, SegmentDuration: 5},
+		{Path: "converted/b_uhd.png", IsImage: true, SegmentDuration: 5},
+		{Path: "missing_clip.mp4", SegmentDuration: 4},
+	}
+	sheets, err := writeContactSheet(mediaInputs, 1, 12, "video_uhd.mp4", false, false)
+	if err != nil {
+		t.Fatalf("writeContactSheet returned error: %v", err)
+	}
+	if strings.Join(sheets, ",") != "video_uhd_contact_sheet_01.png,video_uhd_contact_sheet_02.png" {
+		t.Fatalf("unexpected contact sheet files %v", sheets)
+	}
+
+	first, err := imaging.Open(sheets[0])
+	if err != nil {
+		t.Fatalf("Open(%s) failed: %v", sheets[0], err)
+	}
+	wantWidth := 2*contactSheetThumbWidth + 3*contactSheetPadding
+	if first.Bounds().Dx() != wantWidth {
+		t.Errorf("sheet width = %d, want %d", first.Bounds().Dx(), wantWidth)
+	}
+	thumbCenterY := contactSheetLineHeight(contactSheetHeaderSize) + 2*contactSheetPadding + contactSheetThumbHeight/2
+	if got := color.NRGBAModel.Convert(first.At(contactSheetPadding+contactSheetThumbWidth/2, thumbCenterY)); got != red {
+		t.Errorf("expected the still thumbnail at the first cell, got %v", got)
+	}
+
+	second, err := imaging.Open(sheets[1])
+	if err != nil {
+		t.Fatalf("Open(%s) failed: %v", sheets[1], err)
+	}
+	if got := color.NRGBAModel.Convert(second.At(contactSheetPadding+contactSheetThumbWidth/2, thumbCenterY)); got != contactSheetPlaceholder {
+		t.Errorf("expected a placeholder for a clip without a frame, got %v", got)
+	}
+
+	if got := contactSheetLabels(TimelineManifestItem{Index: 3, Type: "image", Source: "converted/x_uhd.jpg", Original: "/photos/IMG_1.jpg", StartTimecode: "00:00:08.000", SegmentDuration: 5}); strings.Join(got, "|") != "#3  00:00:08.000|5.00s · image|IMG_1.jpg" {
+		t.Errorf("unexpected labels %v", got)
+	}
+}
+
+func TestFindMusicFiles_AcceptsCommonFormats(t *testing.T) {
+	tempDir := t.TempDir()
+	oldWd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd failed: %v", err)
+	}
+	defer func() {
+		_ = os.Chdir(oldWd)
+	}()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatalf("Chdir failed: %v", err)
+	}
+
+	for _, name := range []string{"b.FLAC", "a.mp3", "c.Opus", "d.m4a", "e.wav", "f.ogg", "g.aac", "notes.txt", "clip.mp4"} {
+		if err := os.WriteFile(name, []byte("test"), 0644); err != nil {
+			t.Fatalf("WriteFile(%s) failed: %v", name, err)
+		}
+	}
+
+	files, err := findMusicFiles()
+	if err != nil {
+		t.Fatalf("findMusicFiles returned error: %v", err)
+	}
+	if got := strings.Join(files, ","); got != "a.mp3,b.FLAC,c.Opus,d.m4a,e.wav,f.ogg,g.aac" {
+		t.Errorf("unexpected music files %s", got)
+	}
+}
+
+func TestSetupAudioProcessing_JoinsTracksInFilterGraph(t *testing.T) {
+	mediaInputs := []MediaInput{
+		{Path: "converted/a.jpg", IsImage: true, SegmentDuration: 8},
+		{Path: "converted/b.jpg", IsImage: true, SegmentDuration: 8},
+	}
+	inputs := []string{"-loop", "1", "-t", "8", "-i", "converted/a.jpg", "-loop", "1", "-t", "8", "-i", "converted/b.jpg"}
+
+	config := setupAudioProcessing(inputs, mediaInputs, 14, 2, []string{"a.mp3", "b.flac"}, false, musicFitPlan{}, nil)
+
+	if got := strings.Join(config.Inputs[len(inputs):], " "); got != "-i a.mp3 -i b.flac" {
+		t.Errorf("expected one input per track, got %s", got)
+	}
+	for _, want := range []string{
+		"[2:a]aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=stereo,aresample=48000,asetpts=PTS-STARTPTS[music0]; ",
+		"[3:a]aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=stereo,aresample=48000,asetpts=PTS-STARTPTS[music1]; ",
+		"[music0][music1]concat=n=2:v=0:a=1[musicjoined]; ",
+		"[musicjoined]anull,atrim=duration=14.000",
+		"[musicout]loudnorm=I=-16:TP=-1.5:LRA=11,aresample=48000[audioout]; ",
+	} {
+		if !strings.Contains(config.AudioFilter, want) {
+			t.Errorf("audio filter missing %q: %s", want, config.AudioFilter)
+		}
+	}
+}
+
+func TestChooseAACBitrate(t *testing.T) {
+	tests := []struct {
+		codec    string
+		bps      int
+		expected string
+	}{
+		{"mp3", 320000, "320k"},
+		{"mp3", 128000, "128k"},
+		{"opus", 64000, "96k"},
+		{"aac", 512000, "320k"},
+		{"flac", 900000, "256k"},
+		{"pcm_s16le", 1411200, "256k"},
+		{"alac", 0, "256k"},
+		{"vorbis", 0, "192k"},
+	}
+	for _, tt := range tests {
+		if got := chooseAACBitrate(tt.codec, tt.bps); got != tt.expected {
+			t.Errorf("chooseAACBitrate(%s, %d) = %s, want %s", tt.codec, tt.bps, got, tt.expected)
+		}
+	}
+}
+
+func TestBuildMusicConcatFilter_Crossfade(t *testing.T) {
+	oldMusic := activeOptions.Music
+	defer func() {
+		activeOptions.Music = oldMusic
+	}()
+	activeOptions.Music = MusicOptions{Crossfade: 4, TrimSilence: true}
+
+	filter := buildMusicConcatFilter(5, 3, "musicjoined")
+	for _, want := range []string{
+		"[5:a]aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=stereo,aresample=48000,silenceremove=start_periods=1:start_threshold=-50dB,areverse,silenceremove=start_periods=1:start_threshold=-50dB,areverse,asetpts=PTS-STARTPTS[music0]; ",
+		"[music0][music1]acrossfade=d=4.000:c1=tri:c2=tri[musicxf1]; ",
+		"[musicxf1][music2]acrossfade=d=4.000:c1=tri:c2=tri[musicjoined]; ",
+	} {
+		if !strings.Contains(filter, want) {
+			t.Errorf("music filter missing %q: %s", want, filter)
+		}
+	}
+	if strings.Contains(filter, "concat=") {
+		t.Errorf("expected acrossfade instead of concat: %s", filter)
+	}
+	if got := musicCrossfadeOverlap(3); got != 8 {
+		t.Errorf("musicCrossfadeOverlap(3) = %v, want 8", got)
+	}
+
+	activeOptions.Music = MusicOptions{}
+	if got := musicCrossfadeOverlap(3); got != 0 {
+		t.Errorf("expected no overlap without crossfade, got %v", got)
+	}
+
+	// Excerpts play as chosen: only whole files are trimmed, as musicTrackPlayback assumes.
+	oldRun := activeRun
+	defer func() {
+		activeRun = oldRun
+	}()
+	activeOptions.Music = MusicOptions{TrimSilence: true}
+	activeRun.MusicTracks = []musicTrack{{Path: "a.mp3", Start: 65, End: 200}, {Path: "b.mp3"}}
+	if excerpt := musicTrackFilter(0, 0); strings.Contains(excerpt, "silenceremove") || !strings.Contains(excerpt, "atrim=start=65.000:end=200.000") {
+		t.Errorf("expected the excerpt cut without silence trimming: %s", excerpt)
+	}
+	if whole := musicTrackFilter(1, 1); !strings.Contains(whole, "silenceremove") {
+		t.Errorf("expected a whole file to be trimmed: %s", whole)
+	}
+}
+
+func TestParseSilenceDetect(t *testing.T) {
+	output := `[silencedetect @ 0x1] silence_start: 0
+[silencedetect @ 0x1] silence_end: 1.25 | silence_duration: 1.25
+[silencedetect @ 0x1] silence_start: 60.5
+[silencedetect @ 0x1] silence_end: 62 | silence_duration: 1.5
+[silencedetect @ 0x1] silence_start: 178
+`
+	lead, trail := parseSilenceDetect(output, 180)
+	if lead != 1.25 || trail != 2 {
+		t.Errorf("parseSilenceDetect = %v, %v; want 1.25, 2", lead, trail)
+	}
+
+	if lead, trail := parseSilenceDetect("[silencedetect @ 0x1] silence_start: 0\n", 30); lead != 0 || trail != 0 {
+		t.Errorf("expected a fully silent track to be left alone, got %v, %v", lead, trail)
+	}
+}
+
+func TestResolveMusicFit(t *testing.T) {
+	tests := []struct {
+		mode     string
+		fitAudio bool
+		want     string
+	}{
+		{"", false, musicFitTruncate},
+		{"", true, musicFitAudio},
+		{"Loop", false, musicFitLoop},
+		{"pad", true, musicFitSilence},
+		{"truncate", false, musicFitTruncate},
+	}
+	for _, tt := range tests {
+		if got := resolveMusicFit(tt.mode, tt.fitAudio); got != tt.want {
+			t.Errorf("resolveMusicFit(%q, %v) = %q, want %q", tt.mode, tt.fitAudio, got, tt.want)
+		}
+	}
+	if _, err := NormalizeMusicFit("stretch"); err == nil {
+		t.Error("expected an invalid music fit policy to fail")
+	}
+}
+
+func TestMusicLoopPlan(t *testing.T) {
+	oldMusic := activeOptions.Music
+	defer func() {
+		activeOptions.Music = oldMusic
+	}()
+	activeOptions.Music = MusicOptions{}
+
+	// 60s of music with 2s loop crossfades adds 58s per extra play.
+	if got := musicLoopCount(60, 150); got != 3 {
+		t.Errorf("musicLoopCount(60, 150) = %d, want 3", got)
+	}
+	if got := musicLoopCount(60, 50); got != 1 {
+		t.Errorf("musicLoopCount(60, 50) = %d, want 1", got)
+	}
+
+	activeOptions.Music.Crossfade = 3
+	got := musicJoinCrossfades(2, 2, 60)
+	want := []float64{3, 3, 3}
+	if len(got) != len(want) {
+		t.Fatalf("musicJoinCrossfades = %v, want %v", got, want)
+	}
+
+	activeOptions.Music.Crossfade = 0
+	got = musicJoinCrossfades(2, 2, 60)
+	want = []float64{0, 2, 0}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("musicJoinCrossfades = %v, want %v", got, want)
+		}
+	}
+
+	mediaInputs := []MediaInput{
+		{Path: "a.jpg", IsImage: true, SegmentDuration: 10},
+		{Path: "b.jpg", IsImage: true, SegmentDuration: 10},
+		{Path: "c.jpg", IsImage: true, SegmentDuration: 10},
+	}
+	if got := droppedTimelineItems(mediaInputs, 2, 15); got != 1 {
+		t.Errorf("droppedTimelineItems = %d, want 1", got)
+	}
+}
+
+func TestSetupAudioProcessing_MusicFitPolicies(t *testing.T) {
+	oldMusic := activeOptions.Music
+	defer func() {
+		activeOptions.Music = oldMusic
+	}()
+	activeOptions.Music = MusicOptions{}
+
+	mediaInputs := []MediaInput{
+		{Path: "converted/a.jpg", IsImage: true, SegmentDuration: 40},
+	}
+	inputs := []string{"-loop", "1", "-t", "40", "-i", "converted/a.jpg"}
+
+	config := setupAudioProcessing(inputs, mediaInputs, 40, 2, []string{"song.mp3"}, false,
+		musicFitPlan{Policy: musicFitLoop, MusicLength: 15, Loops: 3}, nil)
+	if got := countFFmpegInputs(config.Inputs); got != 4 {
+		t.Errorf("expected the song three times after the photo, got %d inputs: %v", got, config.Inputs)
+	}
+	for _, want := range []string{
+		"[music0][music1]acrossfade=d=2.000:c1=tri:c2=tri[musicxf1]; ",
+		"[musicxf1][music2]acrossfade=d=2.000:c1=tri:c2=tri[musicjoined]; ",
+		"apad,atrim=duration=40.000",
+		"afade=t=out:st=38.000",
+	} {
+		if !strings.Contains(config.AudioFilter, want) {
+			t.Errorf("loop filter missing %q: %s", want, config.AudioFilter)
+		}
+	}
+	for _, arg := range config.MapArgs {
+		if arg == "-shortest" {
+			t.Errorf("loop must not cut the video to the audio: %v", config.MapArgs)
+		}
+	}
+
+	config = setupAudioProcessing(inputs, mediaInputs, 40, 2, []string{"song.mp3"}, false,
+		musicFitPlan{Policy: musicFitSilence, MusicLength: 15, Loops: 1}, nil)
+	if !strings.Contains(config.AudioFilter, "apad,atrim=duration=40.000") || !strings.Contains(config.AudioFilter, "afade=t=out:st=13.000") {
+		t.Errorf("expected padded music fading out at its end, got %s", config.AudioFilter)
+	}
+
+	config = setupAudioProcessing(inputs, mediaInputs, 40, 2, []string{"song.mp3"}, false,
+		musicFitPlan{Policy: musicFitTruncate, MusicLength: 15, Loops: 1}, nil)
+	if strings.Contains(config.AudioFilter, "apad") || config.MapArgs[len(config.MapArgs)-1] != "-shortest" {
+		t.Errorf("expected truncate-video to keep -shortest without padding, got %v / %s", config.MapArgs, config.AudioFilter)
+	}
+}
+
+func TestFitMixedTimelineToMusic(t *testing.T) {
+	oldMusic := activeOptions.Music
+	defer func() {
+		activeOptions.Music = oldMusic
+	}()
+	activeOptions.Music = MusicOptions{}
+
+	newTimeline := func() []MediaInput {
+		return []MediaInput{
+			{Path: "a.jpg", IsImage: true, SegmentDuration: 5},
+			{Path: "clip.mp4", SegmentDuration: 20},
+			{Path: "b.jpg", IsImage: true, SegmentDuration: 5},
+			{Path: "long.mp4", SegmentDuration: 40},
+		}
+	}
+	totalLength := func(mediaInputs []MediaInput, fade float64) float64 {
+		total := 0.0
+		for _, media := range mediaInputs {
+			total += media.SegmentDuration
+		}
+		return total - float64(len(mediaInputs)-1)*fade
+	}
+
+	// 60s of clips minus 3s of transitions leaves 23s for two photos.
+	mediaInputs := newTimeline()
+	hold, trimmed, err := fitMixedTimelineToMusic(mediaInputs, 1, 80)
+	if err != nil {
+		t.Fatalf("fitMixedTimelineToMusic returned %v", err)
+	}
+	if hold != 11.5 || trimmed != 0 {
+		t.Errorf("hold, trimmed = %v, %v; want 11.5, 0", hold, trimmed)
+	}
+	if mediaInputs[1].SegmentDuration != 20 || mediaInputs[3].SegmentDuration != 40 {
+		t.Errorf("clips must keep their length: %+v", mediaInputs)
+	}
+	if got := totalLength(mediaInputs, 1); got != 80 {
+		t.Errorf("timeline length = %v, want 80", got)
+	}
+
+	// a.jpg needs 15s + 0.5s + two 1s transitions for its narration; b.jpg gets the rest.
+	mediaInputs = newTimeline()
+	mediaInputs[0].NarrationLength = 15
+	hold, _, err = fitMixedTimelineToMusic(mediaInputs, 1, 80)
+	if err != nil {
+		t.Fatalf("fitMixedTimelineToMusic returned %v", err)
+	}
+	if hold != 5.5 || mediaInputs[0].SegmentDuration != 17.5 || mediaInputs[2].SegmentDuration != 5.5 {
+		t.Errorf("hold %v, photos %v and %v; want 5.5, 17.5 and 5.5", hold, mediaInputs[0].SegmentDuration, mediaInputs[2].SegmentDuration)
+	}
+	if got := totalLength(mediaInputs, 1); got != 80 {
+		t.Errorf("timeline length with narration = %v, want 80", got)
+	}
+
+	// 50s of music with photos at 2s needs 11s cut from the clips.
+	mediaInputs = newTimeline()
+	if _, _, err := fitMixedTimelineToMusic(mediaInputs, 1, 50); err == nil {
+		t.Fatal("expected an error when clips may not be trimmed")
+	}
+
+	activeOptions.Music.FitTrimClips = true
+	mediaInputs = newTimeline()
+	hold, trimmed, err = fitMixedTimelineToMusic(mediaInputs, 1, 50)
+	if err != nil {
+		t.Fatalf("fitMixedTimelineToMusic returned %v", err)
+	}
+	if hold != 2 || math.Abs(trimmed-11) > 1e-9 {
+		t.Errorf("hold, trimmed = %v, %v; want 2, 11", hold, trimmed)
+	}
+	if got := totalLength(mediaInputs, 1); math.Abs(got-50) > 1e-9 {
+		t.Errorf("timeline length = %v, want 50", got)
+	}
+	// Slack above 3s is 17s and 37s, so the long clip gives up more.
+	if math.Abs(mediaInputs[1].SegmentDuration-(20-17*11.0/54)) > 1e-9 || mediaInputs[3].SegmentDuration >= 40-11.0/2 {
+		t.Errorf("unexpected clip lengths: %v, %v", mediaInputs[1].SegmentDuration, mediaInputs[3].SegmentDuration)
+	}
+
+	mediaInputs = newTimeline()
+	if _, _, err := fitMixedTimelineToMusic(mediaInputs, 1, 5); err == nil {
+		t.Error("expected an error when even minimum clips are longer than the music")
+	}
+
+	// Clips only, with shorter music: fit-audio steps aside for the truncate fallback.
+	activeOptions.Music.FitTrimClips = false
+	clips := []MediaInput{{Path: "a.mp4", SegmentDuration: 20}, {Path: "b.mp4", SegmentDuration: 30}}
+	hold, trimmed, err = fitMixedTimelineToMusic(clips, 1, 30)
+	if err != nil || hold != 0 || trimmed != 0 {
+		t.Errorf("clips only: hold, trimmed, err = %v, %v, %v; want 0, 0, nil", hold, trimmed, err)
+	}
+	if clips[0].SegmentDuration != 20 || clips[1].SegmentDuration != 30 {
+		t.Errorf("clips must keep their length: %+v", clips)
+	}
+}
+
+func TestDetectBeats_ClickTrack(t *testing.T) {
+	// 20 seconds of 10 ms clicks at 120 BPM, starting at 0.25s.
+	samples := make([]float64, 20*beatAnalysisRate)
+	for click := 0.25; click < 20; click += 0.5 {
+		start := int(click * beatAnalysisRate)
+		for i := start; i < start+beatAnalysisRate/100 && i < len(samples); i++ {
+			samples[i] = 0.8
+		}
+	}
+
+	beats, bpm := detectBeats(samples, beatAnalysisRate)
+	if math.Abs(bpm-120) > 2 {
+		t.Fatalf("detectBeats tempo = %.1f BPM, want 120", bpm)
+	}
+	if len(beats) < 35 {
+		t.Fatalf("expected about 40 beats, got %d", len(beats))
+	}
+	hop := float64(beatHopSize) / beatAnalysisRate
+	for _, beat := range beats {
+		phase := math.Mod(beat-0.25, 0.5)
+		if phase > 0.25 {
+			phase -= 0.5
+		}
+		if math.Abs(phase) > 1.5*hop {
+			t.Fatalf("beat at %.3fs is off the clicks", beat)
+		}
+	}
+
+	if beats, _ := detectBeats(make([]float64, 20*beatAnalysisRate), beatAnalysisRate); len(beats) != 0 {
+		t.Errorf("expected no beats in silence, got %d", len(beats))
+	}
+}
+
+func TestSnapCutsToBeats(t *testing.T) {
+	oldBeats := activeOptions.Beats
+	defer func() {
+		activeOptions.Beats = oldBeats
+	}()
+	activeOptions.Beats = BeatSyncOptions{Enabled: true, Every: 2}
+
+	// Beats every 0.5s; every second one gives a 1s grid at .3s.
+	var beats []float64
+	for beat := 0.3; beat < 40; beat += 0.5 {
+		beats = append(beats, beat)
+	}
+	mediaInputs := []MediaInput{
+		{Path: "a.jpg", IsImage: true, SegmentDuration: 5},
+		{Path: "clip.mp4", SegmentDuration: 6.2},
+		{Path: "b.jpg", IsImage: true, SegmentDuration: 5},
+		{Path: "c.jpg", IsImage: true, SegmentDuration: 5},
+	}
+	cuts := snapCutsToBeats(mediaInputs, 1, 5, beats)
+	if len(cuts) != 3 {
+		t.Fatalf("expected 3 cuts, got %d", len(cuts))
+	}
+
+	// a.jpg: the original cut at 4.0 snaps to the nearest grid beat, 4.3.
+	if math.Abs(cuts[0].Time-4.3) > 1e-9 || !cuts[0].OnBeat || math.Abs(mediaInputs[0].SegmentDuration-5.3) > 1e-9 {
+		t.Errorf("first cut = %+v, hold %.2f", cuts[0], mediaInputs[0].SegmentDuration)
+	}
+	// The clip keeps its length: 4.3 + 6.2 - 1 = 9.5, off the 1s grid.
+	if math.Abs(cuts[1].Time-9.5) > 1e-9 || cuts[1].OnBeat || mediaInputs[1].SegmentDuration != 6.2 {
+		t.Errorf("clip cut = %+v", cuts[1])
+	}
+	// b.jpg: original cut at 13.2 snaps to 13.3.
+	if math.Abs(cuts[2].Time-13.3) > 1e-9 || !cuts[2].OnBeat {
+		t.Errorf("third cut = %+v", cuts[2])
+	}
+	// The last photo still ends where the timeline ended before snapping (13.2 + 5).
+	if end := cuts[2].Time + mediaInputs[3].SegmentDuration; math.Abs(end-18.2) > 1e-9 {
+		t.Errorf("timeline ends at %.2f, want 18.2", end)
+	}
+
+	// A photo with narration is never snapped below its narration hold (5 + 0.5 + 2).
+	narrated := []MediaInput{
+		{Path: "a.jpg", IsImage: true, SegmentDuration: 5, NarrationLength: 5},
+		{Path: "b.jpg", IsImage: true, SegmentDuration: 5, NarrationLength: 6},
+	}
+	snapCutsToBeats(narrated, 1, 5, beats)
+	if narrated[0].SegmentDuration < 7.5 || narrated[1].SegmentDuration < 8.5 {
+		t.Errorf("narrated holds %.2f and %.2f are shorter than their narration", narrated[0].SegmentDuration, narrated[1].SegmentDuration)
+	}
+
+	if err := validateBeatSync(BeatSyncOptions{MinDuration: 6, MaxDuration: 4}); err == nil {
+		t.Error("expected a minimum above the maximum to fail")
+	}
+}
+
+func TestParseMusicTracks(t *testing.T) {
+	tracks, err := ParseMusicTracks("1-40=song.mp3@1:05-3:20, 41-=other.flac@30s")
+	if err != nil {
+		t.Fatalf("ParseMusicTracks returned %v", err)
+	}
+	want := []musicTrack{
+		{Path: "song.mp3", Start: 65, End: 200, FirstItem: 1, LastItem: 40},
+		{Path: "other.flac", Start: 30, FirstItem: 41},
+	}
+	if len(tracks) != len(want) {
+		t.Fatalf("ParseMusicTracks = %+v, want %+v", tracks, want)
+	}
+	for i := range want {
+		if tracks[i] != want[i] {
+			t.Errorf("track %d = %+v, want %+v", i, tracks[i], want[i])
+		}
+	}
+
+	if tracks, err := ParseMusicTracks("a.mp3,b.mp3@10-20"); err != nil || len(tracks) != 2 || tracks[1].Start != 10 || tracks[1].End != 20 || tracks[0].FirstItem != 0 {
+		t.Errorf("unexpected sequential tracks %+v (%v)", tracks, err)
+	}
+
+	for _, invalid := range []string{
+		"1-10=a.mp3,b.mp3",
+		"5-=a.mp3",
+		"1-10=a.mp3,12-=b.mp3",
+		"1-=a.mp3,1-=b.mp3",
+		"a.mp3@2:00-1:00",
+		"x=a.mp3",
+		"@1:00",
+		",",
+		" , ",
+		"1-=best=of.mp3",
+		"live@home.mp3@1:00",
+	} {
+		if _, err := ParseMusicTracks(invalid); err == nil {
+			t.Errorf("expected ParseMusicTracks(%q) to fail", invalid)
+		}
+	}
+}
+
+func TestSetupAudioProcessing_MusicSections(t *testing.T) {
+	oldMusic, oldTracks := activeOptions.Music, activeRun.MusicTracks
+	defer func() {
+		activeOptions.Music, activeRun.MusicTracks = oldMusic, oldTracks
+	}()
+	activeOptions.Music = MusicOptions{}
+	var err error
+	if activeRun.MusicTracks, err = ParseMusicTracks("1-2=a.mp3@1:05, 3-=b.mp3"); err != nil {
+		t.Fatal(err)
+	}
+
+	mediaInputs := []MediaInput{
+		{Path: "converted/a.jpg", IsImage: true, SegmentDuration: 5},
+		{Path: "converted/b.jpg", IsImage: true, SegmentDuration: 5},
+		{Path: "converted/c.jpg", IsImage: true, SegmentDuration: 5},
+		{Path: "converted/d.jpg", IsImage: true, SegmentDuration: 5},
+	}
+	inputs := []string{}
+	for _, media := range mediaInputs {
+		inputs = append(inputs, "-loop", "1", "-t", "5", "-i", media.Path)
+	}
+
+	// Item 3 starts at 8s; the video ends at 17s.
+	config := setupAudioProcessing(inputs, mediaInputs, 17, 1, []string{"a.mp3", "b.mp3"}, false, musicFitPlan{Policy: musicFitSilence, Loops: 1}, nil)
+	for _, want := range []string{
+		"[4:a]atrim=start=65.000,asetpts=PTS-STARTPTS,aformat=",
+		"apad,atrim=duration=9.000,asetpts=PTS-STARTPTS[musicsec0]; ",
+		"[5:a]aformat=",
+		"apad,atrim=duration=9.000,asetpts=PTS-STARTPTS[musicsec1]; ",
+		"[musicsec0][musicsec1]acrossfade=d=1.000:c1=tri:c2=tri[musicjoined]; ",
+		"[musicjoined]anull,apad,atrim=duration=17.000",
+	} {
+		if !strings.Contains(config.AudioFilter, want) {
+			t.Errorf("section filter missing %q: %s", want, config.AudioFilter)
+		}
+	}
+
+	if _, _, err := musicSectionTimes(mediaInputs[:2], 1, 9); err == nil {
+		t.Error("expected a section past the end of the timeline to fail")
+	}
+
+	// A title card does not count as an item; a closed last range ends the music.
+	if activeRun.MusicTracks, err = ParseMusicTracks("1-2=a.mp3, 3-3=b.mp3"); err != nil {
+		t.Fatal(err)
+	}
+	withTitle := append([]MediaInput{{Path: "title_card.png", IsImage: true, IsCard: true, SegmentDuration: 5}}, mediaInputs...)
+	starts, end, err := musicSectionTimes(withTitle, 1, 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Photo 3 is the fourth item: it starts at 12s and ends at 17s.
+	if len(starts) != 2 || starts[0] != 0 || starts[1] != 12 || end != 17 {
+		t.Errorf("starts, end = %v, %v; want [0 12], 17", starts, end)
+	}
+	filter := buildMusicSectionFilter(4, starts, end, 21, 1, "musicjoined")
+	if !strings.Contains(filter, "apad,atrim=duration=5.000,asetpts=PTS-STARTPTS,afade=t=out:st=4.000:d=1.000[musicsec1]; ") {
+		t.Errorf("expected the last section to end at photo 3: %s", filter)
+	}
+	if activeRun.MusicTracks, err = ParseMusicTracks("1-2=a.mp3, 3-9=b.mp3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := musicSectionTimes(withTitle, 1, 21); err == nil {
+		t.Error("expected a section ending past the timeline to fail")
+	}
+}
+
+func TestLoudnessStageAndMeasurement(t *testing.T) {
+	oldAudio := activeOptions.Audio
+	defer func() {
+		activeOptions.Audio = oldAudio
+	}()
+	activeOptions.Audio = AudioOptions{Loudness: -14, SampleRate: 44100}
+
+	if got := loudnessStage("mixedaudio", nil); got != "[mixedaudio]loudnorm=I=-14:TP=-1.5:LRA=11,aresample=44100[audioout]; " {
+		t.Errorf("single-pass stage = %q", got)
+	}
+
+	output := `[Parsed_loudnorm_0 @ 0x1]
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "18.06",
+	"input_thresh" : "-39.20",
+	"output_i" : "-14.02",
+	"target_offset" : "0.02"
+}
+`
+	measured, err := parseLoudnessMeasurement(output)
+	if err != nil {
+		t.Fatalf("parseLoudnessMeasurement returned %v", err)
+	}
+	want := "[mixedaudio]loudnorm=I=-14:TP=-1.5:LRA=11:measured_I=-27.61:measured_TP=-4.47:measured_LRA=18.06:measured_thresh=-39.20:offset=0.02:linear=true,aresample=44100[audioout]; "
+	if got := loudnessStage("mixedaudio", measured); got != want {
+		t.Errorf("two-pass stage = %q, want %q", got, want)
+	}
+
+	config := AudioConfig{AudioFilter: "[0:a]anull[mix]; " + loudnessStage("mix", nil), MixLabel: "mix"}
+	config.AudioFilter = strings.TrimSuffix(config.AudioFilter, loudnessStage("mix", nil)) + loudnessStage("mix", measured)
+	if !strings.HasPrefix(config.AudioFilter, "[0:a]anull[mix]; [mix]loudnorm=I=-14:TP=-1.5:LRA=11:measured_I=-27.61") {
+		t.Errorf("unexpected two-pass filter %q", config.AudioFilter)
+	}
+
+	if _, err := parseLoudnessMeasurement(`{"input_i" : "-inf", "input_tp" : "-inf"}`); err == nil {
+		t.Error("expected silent audio to have no usable measurement")
+	}
+	if _, err := parseLoudnessMeasurement("Error opening input"); err == nil {
+		t.Error("expected output without JSON to fail")
+	}
+}
+
+func TestAudioEncoderArgsAndGains(t *testing.T) {
+	oldAudio := activeOptions.Audio
+	defer func() {
+		activeOptions.Audio = oldAudio
+	}()
+
+	tests := []struct {
+		options AudioOptions
+		want    string
+	}{
+		{AudioOptions{}, "-c:a aac -b:a 192k -ar 48000"},
+		{AudioOptions{Codec: "AAC", Bitrate: "256"}, "-c:a aac -b:a 256k -ar 48000"},
+		{AudioOptions{Codec: "opus", SampleRate: 44100}, "-c:a libopus -b:a 160k -ar 48000"},
+		{AudioOptions{Codec: "flac", SampleRate: 96000}, "-c:a flac -ar 96000 -strict experimental"},
+	}
+	for _, tt := range tests {
+		activeOptions.Audio = tt.options
+		if got := strings.Join(audioEncoderArgs(""), " "); got != tt.want {
+			t.Errorf("audioEncoderArgs(%+v) = %q, want %q", tt.options, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []AudioOptions{{Codec: "mp3"}, {Loudness: -2}, {SampleRate: 22050}, {Bitrate: "fast"}} {
+		if err := validateAudioOptions(invalid); err == nil {
+			t.Errorf("expected validateAudioOptions(%+v) to fail", invalid)
+		}
+	}
+
+	activeOptions.Audio = AudioOptions{MusicGain: -3, ClipGain: 2.5}
+	mediaInputs := []MediaInput{
+		{Path: "converted/a.jpg", IsImage: true, SegmentDuration: 8},
+		{Path: "clip.mp4", IsImage: false, HasAudio: true, SegmentDuration: 12},
+	}
+	config := setupAudioProcessing([]string{"-loop", "1", "-t", "8", "-i", "converted/a.jpg", "-i", "clip.mp4"}, mediaInputs, 18, 2, []string{"soundtrack.mp3"}, true, musicFitPlan{}, nil)
+	for _, want := range []string{",volume=2.5dB[clipaudio0]; ", "aresample=48000,volume=-3dB,atrim=duration=18.000"} {
+		if !strings.Contains(config.AudioFilter, want) {
+			t.Errorf("audio filter missing %q: %s", want, config.AudioFilter)
+		}
+	}
+}
+
+func TestItemNarrationFilesAreNotMusic(t *testing.T) {
+	tempDir := t.TempDir()
+	oldWd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldNarration := activeOptions.Narration
+	defer func() {
+		_ = os.Chdir(oldWd)
+		activeOptions.Narration = oldNarration
+	}()
+	if err := os.Chdir(tempDir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"clip.mp4", "clip.WAV", "intro.mp3", "song.mp3"} {
+		if err := os.WriteFile(name, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mediaInputs := []MediaInput{{Path: "clip.mp4", SegmentDuration: 10}, {Path: "card.png", IsImage: true, IsCard: true}}
+	if got := itemNarrationFile(mediaInputs[0]); got != "clip.WAV" {
+		t.Errorf("expected clip.WAV as the narration of clip.mp4, got %q", got)
+	}
+	if got := itemNarrationFile(mediaInputs[1]); got != "" {
+		t.Errorf("cards have no narration, got %q", got)
+	}
+
+	activeOptions.Narration = NarrationOptions{File: "intro.mp3", PerItem: true}
+	musicFiles := withoutNarrationFiles([]string{"clip.WAV", "intro.mp3", "song.mp3"}, mediaInputs)
+	if len(musicFiles) != 1 || musicFiles[0] != "song.mp3" {
+		t.Errorf("expected only song.mp3 to stay music, got %v", musicFiles)
+	}
+}
+
+func TestExtendAndPlaceNarration(t *testing.T) {
+	oldNarration := activeOptions.Narration
+	defer func() {
+		activeOptions.Narration = oldNarration
+	}()
+	activeOptions.Narration = NarrationOptions{File: "voice.wav", Offset: 3, PerItem: true}
+
+	mediaInputs := []MediaInput{
+		{Path: "converted/a.jpg", IsImage: true, SegmentDuration: 5, NarrationLength: 7.5},
+		{Path: "clip.mp4", SegmentDuration: 6, NarrationLength: 20},
+		{Path: "converted/b.jpg", IsImage: true, SegmentDuration: 5, NarrationLength: 1},
+	}
+	clips := []narrationClip{
+		{Path: "a.wav", Duration: 7.5},
+		{Path: "clip.wav", Duration: 20},
+		{Path: "b.wav", Duration: 1},
+	}
+	if extended := extendItemsForNarration(mediaInputs, 1); extended != 1 {
+		t.Errorf("expected one photo to be extended, got %d", extended)
+	}
+	if mediaInputs[0].SegmentDuration != 10 || mediaInputs[1].SegmentDuration != 6 || mediaInputs[2].SegmentDuration != 5 {
+		t.Fatalf("unexpected durations after extension: %+v", mediaInputs)
+	}
+
+	placed := placeNarration(mediaInputs, clips, 1)
+	if len(placed) != 4 || placed[0].Path != "voice.wav" || placed[0].Start != 3 {
+		t.Fatalf("expected the narration track first at 3s, got %+v", placed)
+	}
+	for i, want := range []float64{0, 10, 15} {
+		if math.Abs(placed[i+1].Start-want) > 1e-9 {
+			t.Errorf("narration %s starts at %.3f, want %.3f", placed[i+1].Path, placed[i+1].Start, want)
+		}
+	}
+}
+
+func TestSetupAudioProcessing_MixesNarrationOverDuckedMusic(t *testing.T) {
+	mediaInputs := []MediaInput{{Path: "converted/a.jpg", IsImage: true, SegmentDuration: 12}}
+	narration := []narrationClip{{Path: "voice.wav", Start: 1.5}, {Path: "a.wav", Start: 0}}
+
+	config := setupAudioProcessing([]string{"-loop", "1", "-t", "12", "-i", "converted/a.jpg"}, mediaInputs, 12, 1, []string{"song.mp3"}, false, musicFitPlan{}, narration)
+	if got := countFFmpegInputs(config.Inputs); got != 4 {
+		t.Fatalf("expected photo, music and two narration inputs, got %v", config.Inputs)
+	}
+	for _, want := range []string{
+		"[2:a]aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=stereo,aresample=48000,adelay=1500|1500[narration0]; ",
+		"[narration0][narration1]amix=inputs=2:duration=longest:normalize=0[narrationjoined]; ",
+		"[narrationjoined]apad,atrim=duration=12.000,asetpts=PTS-STARTPTS[narrationbus]; ",
+		"[narrationbus]asplit=2[narrationmix][narrationsidechain]; ",
+		"[musicout][narrationsidechain]sidechaincompress=",
+		"[musicnarrated][narrationmix]amix=inputs=2:duration=first:normalize=0[narratedaudio]; ",
+	} {
+		if !strings.Contains(config.AudioFilter, want) {
+			t.Errorf("audio filter missing %q: %s", want, config.AudioFilter)
+		}
+	}
+	if config.MixLabel != "narratedaudio" {
+		t.Errorf("expected the narrated mix to be normalized, got %s", config.MixLabel)
+	}
+
+	config = setupAudioProcessing([]string{"-loop", "1", "-t", "12", "-i", "converted/a.jpg"}, mediaInputs, 12, 1, nil, false, musicFitPlan{}, narration[:1])
+	if !config.HasAudio || config.MixLabel != "narrationbus" || config.AudioBitrateSource != "voice.wav" {
+		t.Errorf("expected the narration alone as the audio, got %s from %s", config.MixLabel, config.AudioBitrateSource)
+	}
+}
+
+func TestSetupAudioProcessing_SilentTrackWithoutAudio(t *testing.T) {
+	oldAudio := activeOptions.Audio
+	defer func() {
+		activeOptions.Audio = oldAudio
+	}()
+	mediaInputs := []MediaInput{{Path: "converted/a.jpg", IsImage: true, SegmentDuration: 9}}
+	inputs := []string{"-loop", "1", "-t", "9", "-i", "converted/a.jpg"}
+
+	activeOptions.Audio = AudioOptions{}
+	if config := setupAudioProcessing(inputs, mediaInputs, 9, 1, nil, false, musicFitPlan{}, nil); config.HasAudio || len(config.MapArgs) != 2 {
+		t.Fatalf("expected no audio by default, got %v", config.MapArgs)
+	}
+
+	activeOptions.Audio = AudioOptions{SilentTrack: true}
+	config := setupAudioProcessing(inputs, mediaInputs, 9, 1, nil, false, musicFitPlan{}, nil)
+	if !config.HasAudio || config.MixLabel != "" {
+		t.Fatalf("expected a silent track that skips loudness, got HasAudio=%v MixLabel=%q", config.HasAudio, config.MixLabel)
+	}
+	if want := "anullsrc=channel_layout=stereo:sample_rate=48000,atrim=duration=9.000[audioout]; "; config.AudioFilter != want {
+		t.Errorf("expected %q, got %q", want, config.AudioFilter)
+	}
+	if strings.Join(config.MapArgs, " ") != "-map [xfout] -map [audioout]" {
+		t.Errorf("unexpected map args %v", config.MapArgs)
+	}
+}
+
+func TestVideoCodecAndContainer(t *testing.T) {
+	oldVideo, oldAudio, oldPreview, oldResolution := activeOptions.Video, activeOptions.Audio, activeOptions.Preview, activeResolution
+	defer func() {
+		activeOptions.Video, activeOptions.Audio, activeOptions.Preview, activeResolution = oldVideo, oldAudio, oldPreview, oldResolution
+	}()
+	activeOptions.Preview = PreviewOptions{}
+	activeResolution = resolution4K
+
+	for input, want := range map[string]string{"": "h264", "H265": "hevc", "av1": "av1", "vp9": "vp9"} {
+		if got, err := NormalizeVideoCodec(input); err != nil || got != want {
+			t.Errorf("NormalizeVideoCodec(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := NormalizeVideoCodec("mpeg2"); err == nil {
+		t.Error("expected an unknown codec to fail")
+	}
+	if _, err := NormalizeContainer("avi"); err == nil {
+		t.Error("expected an unknown container to fail")
+	}
+
+	if err := validateVideoOptions(VideoOptions{Codec: "hevc", Container: "webm"}, AudioOptions{Codec: "opus"}); err == nil {
+		t.Error("expected HEVC in WebM to fail")
+	}
+	if err := validateVideoOptions(VideoOptions{Codec: "vp9", Container: "webm"}, AudioOptions{}); err == nil {
+		t.Error("expected AAC in WebM to fail")
+	}
+	if err := validateVideoOptions(VideoOptions{Codec: "vp9", Container: "webm"}, AudioOptions{Codec: "opus"}); err != nil {
+		t.Errorf("expected VP9 with Opus in WebM to pass, got %v", err)
+	}
+
+	cases := []struct {
+		video     VideoOptions
+		audio     string
+		filename  string
+		subtitles string
+	}{
+		{VideoOptions{}, "aac", "video_uhd.mp4", "mov_text"},
+		{VideoOptions{Codec: "hevc"}, "aac", "video_uhd.mp4", "mov_text"},
+		{VideoOptions{Codec: "av1", Container: "mkv"}, "flac", "video_uhd.mkv", "srt"},
+		{VideoOptions{Codec: "vp9"}, "opus", "video_uhd.webm", "webvtt"},
+		{VideoOptions{Codec: "vp9"}, "aac", "video_uhd.mkv", "srt"},
+	}
+	for _, tc := range cases {
+		activeOptions.Video, activeOptions.Audio = tc.video, AudioOptions{Codec: tc.audio}
+		if got := outputVideoFilename(); got != tc.filename {
+			t.Errorf("%+v with %s audio: expected %s, got %s", tc.video, tc.audio, tc.filename, got)
+		}
+		if got := containerSubtitleCodec(); got != tc.subtitles {
+			t.Errorf("%+v with %s audio: expected %s subtitles, got %s", tc.video, tc.audio, tc.subtitles, got)
+		}
+	}
+
+	activeOptions.Preview = PreviewOptions{Enabled: true}
+	activeResolution = resolutionPreview
+	if got := outputVideoFilename(); got != outputVideoPreview {
+		t.Errorf("previews stay MP4, got %s", got)
+	}
+
+	names := generatedOutputVideoNames()
+	for _, name := range []string{"video_uhd.mkv", "video_fhd.webm", "video_uhd.mp4"} {
+		if _, ok := names[name]; !ok {
+			t.Errorf("expected %s to be skipped as a generated video", name)
+		}
+	}
+	for codec, software := range map[string]string{"hevc": "libx265", "av1": "libsvtav1", "vp9": "libvpx-vp9"} {
+		encoders := videoEncoders(codec)
+		if len(encoders) == 0 || encoders[len(encoders)-1].Name != software {
+			t.Errorf("expected %s to fall back to %s, got %+v", codec, software, encoders)
+		}
+		for _, encoder := range encoders {
+			if strings.HasSuffix(encoder.Name, "_vaapi") {
+				t.Errorf("%s lists %s, which cannot open without a VAAPI device", codec, encoder.Name)
+			}
+		}
+		if checkHardwareEncoder(encoders[0].Name, " V....D libx264") {
+			t.Errorf("%s must not be selected when ffmpeg does not list it", encoders[0].Name)
+		}
+	}
+}
+
+func TestItemPositionsSkipCards(t *testing.T) {
+	mediaInputs := []MediaInput{
+		{Path: "title_card.png", IsImage: true, IsCard: true},
+		{Path: "converted/a.jpg", IsImage: true},
+		{Path: "clip.mp4"},
+		{Path: "credits_card.png", IsImage: true, IsCard: true},
+	}
+	positions, count := itemPositions(mediaInputs)
+	if count != 2 {
+		t.Fatalf("expected 2 photos and clips, got %d", count)
+	}
+	if positions[1] != 0 || positions[2] != 1 {
+		t.Errorf("expected the first photo at 0 and the clip at 1, got %v", positions)
+	}
+}
+
+func TestTimelineExportMusicTracks(t *testing.T) {
+	oldResolution, oldFPS, oldTracks := activeResolution, activeFPS, activeRun.MusicTracks
+	defer func() {
+		activeResolution, activeFPS, activeRun.MusicTracks = oldResolution, oldFPS, oldTracks
+	}()
+	activeResolution = resolution4K
+	activeFPS = 30
+	var err error
+	if activeRun.MusicTracks, err = ParseMusicTracks("1-1=a.mp3@1:05, 2-=b.mp3"); err != nil {
+		t.Fatal(err)
+	}
+
+	mediaInputs := []MediaInput{
+		{Path: titleCardFile, IsImage: true, IsCard: true, SegmentDuration: 5},
+		{Path: "converted/a_uhd.jpg", IsImage: true, SegmentDuration: 5},
+		{Path: "converted/b_uhd.jpg", IsImage: true, SegmentDuration: 5},
+	}
+	// Photo 2 starts at 8s; the excerpt of a.mp3 lasts 5s and b.mp3 4s, so both leave silence.
+	timeline := buildExportTimeline(mediaInputs, 1, 13, "video_uhd.mp4", []string{"a.mp3", "b.mp3"}, []float64{5, 4}, []float64{65, 0})
+	if len(timeline.Music) != 2 {
+		t.Fatalf("expected two sections, got %+v", timeline.Music)
+	}
+	if music := timeline.Music[0]; music.Start != 0 || music.Duration != 5 || music.SourceIn != 65 {
+		t.Errorf("unexpected first section %+v", music)
+	}
+	if music := timeline.Music[1]; music.Start != 8 || music.Duration != 4 {
+		t.Errorf("unexpected second section %+v", music)
+	}
+
+	if edl := formatEDL(timeline); !strings.Contains(edl, "AA    C        00:01:05:00 00:01:10:00 00:00:00:00 00:00:05:00") {
+		t.Errorf("EDL music must start at the excerpt:\n%s", edl)
+	}
+	if fcpxml := formatFCPXML(timeline); !strings.Contains(fcpxml, `name="a.mp3" start="1950/30s" duration="150/30s"`) {
+		t.Errorf("FCPXML music must start at the excerpt:\n%s", fcpxml)
+	}
+	otio, err := formatOTIO(timeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(otio, `"OTIO_SCHEMA": "Gap.1"`) {
+		t.Errorf("expected a gap placing the second section at 8s: %s", otio)
+	}
+}
+
+func TestValidateGenerateOptions(t *testing.T) {
+	if err := validateGenerateOptions(GenerateOptions{}, true); err != nil {
+		t.Fatalf("default options must be valid, got %v", err)
+	}
+
+	opts := GenerateOptions{
+		Overlay:   OverlayOptions{BoxOpacity: 2},
+		Chapters:  ChapterOptions{Mode: "weekly"},
+		Narration: NarrationOptions{Offset: -1},
+	}
+	err := validateGenerateOptions(opts, true)
+	if err == nil {
+		t.Fatal("expected invalid options to fail")
+	}
+	for _, want := range []string{"box opacity", "weekly", "narration offset"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q among the reported problems, got %v", want, err)
+		}
+	}
+	if err := validateGenerateOptions(GenerateOptions{Overlay: OverlayOptions{BoxOpacity: 2}}, false); err != nil {
+		t.Errorf("overlay style must not be checked without overlay text, got %v", err)
+	}
+}
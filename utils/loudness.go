@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultLoudnessTarget = -16.0 // LUFS
+	loudnessTruePeak      = -1.5  // dBTP
+	loudnessRange         = 11.0  // LU
+	defaultAudioRate      = 48000
+
+	audioCodecAAC  = "aac"
+	audioCodecOpus = "opus"
+	audioCodecFLAC = "flac"
+
+	defaultOpusBitrate = "160k"
+
+	// audioMixLabel is the final audio stream mapped into the output, after loudness.
+	audioMixLabel = "audioout"
+)
+
+// loudnessMeasurement holds the first-pass loudnorm analysis of the mix.
+type loudnessMeasurement struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// NormalizeAudioCodec validates an output audio codec. An empty value uses AAC.
+func NormalizeAudioCodec(codec string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(codec)) {
+	case "", audioCodecAAC, "m4a":
+		return audioCodecAAC, nil
+	case audioCodecOpus, "libopus":
+		return audioCodecOpus, nil
+	case audioCodecFLAC:
+		return audioCodecFLAC, nil
+	default:
+		return "", fmt.Errorf("invalid audio codec %q. Use aac, opus, or flac", codec)
+	}
+}
+
+// validateAudioOptions rejects loudness targets outside loudnorm's range, unsupported
+// sample rates and malformed bitrates.
+func validateAudioOptions(options AudioOptions) error {
+	if _, err := NormalizeAudioCodec(options.Codec); err != nil {
+		return err
+	}
+	if options.Loudness != 0 && (options.Loudness < -70 || options.Loudness > -5) {
+		return fmt.Errorf("loudness target must be between -70 and -5 LUFS, got %.1f", options.Loudness)
+	}
+	switch options.SampleRate {
+	case 0, 44100, 48000, 96000:
+	default:
+		return fmt.Errorf("audio sample rate must be 44100, 48000 or 96000, got %d", options.SampleRate)
+	}
+	if bitrate := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(options.Bitrate)), "k"); bitrate != "" {
+		if kbps, err := strconv.Atoi(bitrate); err != nil || kbps < 32 || kbps > 512 {
+			return fmt.Errorf("invalid audio bitrate %q. Use kbps such as 192k", options.Bitrate)
+		}
+	}
+	return nil
+}
+
+// loudnessTarget returns the integrated loudness the mix is normalized to.
+func loudnessTarget() float64 {
+	if activeOptions.Audio.Loudness != 0 {
+		return activeOptions.Audio.Loudness
+	}
+	return defaultLoudnessTarget
+}
+
+// audioSampleRate returns the output sample rate.
+func audioSampleRate() int {
+	if activeOptions.Audio.SampleRate != 0 {
+		return activeOptions.Audio.SampleRate
+	}
+	return defaultAudioRate
+}
+
+// gainFilter returns ",volume=XdB" for a non-zero gain, or "".
+func gainFilter(gainDB float64) string {
+	if gainDB == 0 {
+		return ""
+	}
+	return fmt.Sprintf(",volume=%gdB", gainDB)
+}
+
+// loudnessStage normalizes mixLabel into audioMixLabel. Without a measurement loudnorm
+// runs in its single-pass dynamic mode; with one it applies the measured values linearly.
+func loudnessStage(mixLabel string, measured *loudnessMeasurement) string {
+	filter := fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g", loudnessTarget(), loudnessTruePeak, loudnessRange)
+	if measured != nil {
+		filter += fmt.Sprintf(":measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+			measured.InputI, measured.InputTP, measured.InputLRA, measured.InputThresh, measured.TargetOffset)
+	}
+	// loudnorm works at 192 kHz internally
+	return fmt.Sprintf("[%s]%s,aresample=%d[%s]; ", mixLabel, filter, audioSampleRate(), audioMixLabel)
+}
+
+// parseLoudnessMeasurement extracts the JSON block loudnorm prints with print_format=json.
+func parseLoudnessMeasurement(output string) (*loudnessMeasurement, error) {
+	start := strings.LastIndex(output, "{")
+	end := strings.LastIndex(output, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no loudnorm measurement in the ffmpeg output")
+	}
+	var measured loudnessMeasurement
+	if err := json.Unmarshal([]byte(output[start:end+1]), &measured); err != nil {
+		return nil, fmt.Errorf("failed to parse the loudnorm measurement: %v", err)
+	}
+	if value, err := strconv.ParseFloat(measured.InputI, 64); err != nil || math.IsInf(value, 0) {
+		// Silence measures as -inf, which loudnorm cannot take back as a parameter.
+		return nil, fmt.Errorf("the mix has no measurable loudness (%s)", measured.InputI)
+	}
+	return &measured, nil
+}
+
+// measureLoudness runs the first loudnorm pass over the audio mix of the render and
+// returns its measurement.
+func measureLoudness(config AudioConfig) (*loudnessMeasurement, error) {
+	mix := strings.TrimSuffix(config.AudioFilter, loudnessStage(config.MixLabel, nil))
+	filter := mix + fmt.Sprintf("[%s]loudnorm=I=%g:TP=%g:LRA=%g:print_format=json[measured]",
+		config.MixLabel, loudnessTarget(), loudnessTruePeak, loudnessRange)
+
+	// A script file, like the render, avoids Windows command line length limits; it is
+	// temporary, so the measurement leaves nothing in the working folder
+	script, err := os.CreateTemp("", "go24k-loudness-*.txt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the loudness filter script: %v", err)
+	}
+	defer os.Remove(script.Name())
+	_, err = script.WriteString(filter)
+	if closeErr := script.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write %s: %v", script.Name(), err)
+	}
+
+	args := append([]string{"-hide_banner", "-nostats"}, config.Inputs...)
+	args = append(args, "-filter_complex_script", script.Name(), "-map", "[measured]", "-f", "null", "-")
+	output, err := newExecCommand("ffmpeg", args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("loudness measurement failed: %v", err)
+	}
+	return parseLoudnessMeasurement(string(output))
+}
+
+// applyTwoPassLoudness measures the mix and replaces the single-pass loudness stage with
+// a linear one at the measured values. On failure the single pass stays.
+func applyTwoPassLoudness(config *AudioConfig) {
+	fmt.Printf("Measuring loudness (target %.1f LUFS)...\n", loudnessTarget())
+	measured, err := measureLoudness(*config)
+	if err != nil {
+		fmt.Printf("Warning: %v; using single-pass loudness normalization\n", err)
+		return
+	}
+	fmt.Printf("Measured %s LUFS, %s dBTP; normalizing to %.1f LUFS\n", measured.InputI, measured.InputTP, loudnessTarget())
+	config.AudioFilter = strings.TrimSuffix(config.AudioFilter, loudnessStage(config.MixLabel, nil)) + loudnessStage(config.MixLabel, measured)
+}
+
+// audioEncoderArgs returns the audio codec options. AAC keeps the bitrate chosen from
+// the source unless one is set; Opus defaults to defaultOpusBitrate; FLAC is lossless.
+func audioEncoderArgs(bitrateSource string) []string {
+	codec, _ := NormalizeAudioCodec(activeOptions.Audio.Codec)
+	bitrate := strings.TrimSpace(activeOptions.Audio.Bitrate)
+	if bitrate != "" && !strings.HasSuffix(strings.ToLower(bitrate), "k") {
+		bitrate += "k"
+	}
+	rate := strconv.Itoa(audioSampleRate())
+
+	switch codec {
+	case audioCodecOpus:
+		if bitrate == "" {
+			bitrate = defaultOpusBitrate
+		}
+		// Opus only runs at 48 kHz
+		return []string{"-c:a", "libopus", "-b:a", bitrate, "-ar", "48000"}
+	case audioCodecFLAC:
+		// FLAC in MP4 is still flagged experimental by ffmpeg
+		return []string{"-c:a", "flac", "-ar", rate, "-strict", "experimental"}
+	default:
+		if bitrate == "" {
+			bitrate = defaultAACBitrate
+			if bitrateSource != "" {
+				bitrate = getAudioBitrateStr(bitrateSource)
+			}
+		}
+		return []string{"-c:a", "aac", "-b:a", bitrate, "-ar", rate}
+	}
+}
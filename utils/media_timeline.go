@@ -20,6 +20,9 @@ type MediaInput struct {
 	CapturedAt      time.Time
 	HasCapturedAt   bool
 	SortName        string
+	IsCard          bool    // Generated title or credits card: no motion, overlays or captions
+	KenBurnsVariant string  // Motion variant for photos (see kenBurnsVariants); empty picks one at random
+	NarrationLength float64 // Length of the item's -narration-per-item recording; zero for none
 }
 
 // findVideoFiles returns video files in the current directory based on selected options.
@@ -64,18 +67,41 @@ func findVideoFiles(includeVideos bool) ([]string, error) {
 }
 
 func generatedOutputVideoNames() map[string]struct{} {
-	return map[string]struct{}{
-		strings.ToLower(outputVideoLegacy): {},
-		strings.ToLower(outputVideoUHD):    {},
-		strings.ToLower(outputVideoFHD):    {},
+	names := map[string]struct{}{
+		strings.ToLower(outputVideoLegacy):  {},
+		strings.ToLower(outputVideoPreview): {},
 	}
+	for _, name := range []string{outputVideoUHD, outputVideoFHD} {
+		for _, container := range []string{containerMP4, containerMKV, containerWebM} {
+			names[strings.ToLower(withContainerExtension(name, container))] = struct{}{}
+		}
+	}
+	return names
+}
+
+// itemPositions returns the 0-based position of each item among the photos and clips,
+// leaving out title and credits cards, and how many photos and clips there are.
+// Cards get the position of the next item.
+func itemPositions(mediaInputs []MediaInput) ([]int, int) {
+	positions := make([]int, len(mediaInputs))
+	count := 0
+	for i, media := range mediaInputs {
+		positions[i] = count
+		if !media.IsCard {
+			count++
+		}
+	}
+	return positions, count
 }
 
 func outputVideoFilename() string {
+	if activeResolution == resolutionPreview {
+		return outputVideoPreview
+	}
 	if activeResolution == resolutionFullHD {
-		return outputVideoFHD
+		return withContainerExtension(outputVideoFHD, outputContainer())
 	}
-	return outputVideoUHD
+	return withContainerExtension(outputVideoUHD, outputContainer())
 }
 
 // collectMediaInputs builds a sorted timeline from converted images and optional videos.
@@ -87,6 +113,12 @@ func collectMediaInputs(imageDuration float64, includeVideos, orderByFilename, r
 	if err != nil {
 		return nil, fmt.Errorf("failed to list converted images: %v", err)
 	}
+	if len(imageFiles) == 0 && activeOptions.DryRun {
+		// Nothing converted yet: plan the timeline with the names ConvertImages would use.
+		if imageFiles, err = plannedConvertedImages(activeSourceResolution == resolutionFullHD); err != nil {
+			return nil, err
+		}
+	}
 	sort.Strings(imageFiles)
 
 	var media []MediaInput
@@ -190,13 +222,24 @@ func mediaSortName(path string) string {
 // resolveImageSortName returns the preferred sort key for converted images.
 // In filename-order mode we sort by original source filename when possible.
 func resolveImageSortName(convertedPath string) string {
-	original := GetOriginalFilename(convertedPath)
+	original := originalFilename(convertedPath)
 	if original != "" {
 		return mediaSortName(original)
 	}
 	return mediaSortName(convertedPath)
 }
 
+// mediaSourcePath returns the original file behind a timeline item: the source photo
+// of a converted image, or the clip itself.
+func mediaSourcePath(media MediaInput) string {
+	if media.IsImage {
+		if originalFile := originalFilename(media.Path); originalFile != "" {
+			return originalFile
+		}
+	}
+	return media.Path
+}
+
 func extractImageTimestampFromConvertedName(path string) (time.Time, bool) {
 	base := filepath.Base(path)
 	base = trimConvertedImageResolutionSuffix(base)
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	musicFitLoop     = "loop"
+	musicFitTruncate = "truncate-video"
+	musicFitSilence  = "silence"
+	musicFitAudio    = "fit-audio"
+
+	// defaultMusicLoopCrossfade joins the end of the music to its start when looping
+	// and no -music-crossfade is set.
+	defaultMusicLoopCrossfade = 2.0
+)
+
+// musicFitPlan is the policy applied when the music and the timeline differ in length.
+type musicFitPlan struct {
+	Policy      string
+	MusicLength float64 // Seconds of joined music before looping; zero when unknown
+	Loops       int     // Times the music list is played with the loop policy
+}
+
+// NormalizeMusicFit validates the policy for music shorter than the slideshow.
+// An empty value keeps the default: fit-audio with -fit-audio, otherwise truncate-video.
+func NormalizeMusicFit(mode string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "":
+		return "", nil
+	case musicFitLoop, "repeat":
+		return musicFitLoop, nil
+	case musicFitTruncate, "truncate", "shortest":
+		return musicFitTruncate, nil
+	case musicFitSilence, "pad":
+		return musicFitSilence, nil
+	case musicFitAudio, "fit":
+		return musicFitAudio, nil
+	default:
+		return "", fmt.Errorf("invalid music fit policy %q. Use loop, truncate-video, silence, or fit-audio", mode)
+	}
+}
+
+// resolveMusicFit picks the policy for this run from -music-fit and -fit-audio.
+func resolveMusicFit(mode string, fitAudio bool) string {
+	policy, _ := NormalizeMusicFit(mode)
+	if policy == "" {
+		if fitAudio {
+			return musicFitAudio
+		}
+		return musicFitTruncate
+	}
+	return policy
+}
+
+// musicLoopCrossfade returns the crossfade between the end of the music and its restart.
+func musicLoopCrossfade(musicLength float64) float64 {
+	crossfade := activeOptions.Music.Crossfade
+	if crossfade <= 0 {
+		crossfade = defaultMusicLoopCrossfade
+	}
+	return math.Min(crossfade, musicLength/2)
+}
+
+// musicLoopCount returns how many times music of the given length must play, joined by
+// loop crossfades, to cover finalLength.
+func musicLoopCount(musicLength, finalLength float64) int {
+	if musicLength <= 0 || musicLength >= finalLength {
+		return 1
+	}
+	step := musicLength - musicLoopCrossfade(musicLength)
+	return 1 + int(math.Ceil((finalLength-musicLength)/step-1e-9))
+}
+
+// droppedTimelineItems counts the items that start at or after cutAt.
+func droppedTimelineItems(mediaInputs []MediaInput, fadeSec, cutAt float64) int {
+	dropped := 0
+	for _, offset := range buildTimelineOffsets(mediaInputs, fadeSec) {
+		if offset >= cutAt {
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// planMusicFit measures the music against the timeline and reports which policy takes
+// effect. A fit-audio run whose music still falls short (a timeline of only clips has
+// nothing to stretch) is truncated like before, with a warning.
+func planMusicFit(policy string, musicFiles []string, mediaInputs []MediaInput, fadeSec, finalLength float64) musicFitPlan {
+	plan := musicFitPlan{Policy: policy, Loops: 1}
+	if len(musicFiles) == 0 {
+		return plan
+	}
+	if musicSectioned() {
+		// Every section is padded to its stretch of the timeline.
+		fmt.Printf("Music fit: %d sections from -music-tracks cover the whole video\n", len(musicFiles))
+		plan.Policy = musicFitSilence
+		return plan
+	}
+
+	musicLength, err := getTotalAudioDurationSeconds(musicFiles)
+	if err != nil || musicLength <= 0 {
+		fmt.Printf("Music fit: %s (could not read the music length)\n", policy)
+		return plan
+	}
+	plan.MusicLength = musicLength
+
+	if musicLength >= finalLength-0.05 {
+		fmt.Printf("Music fit: %s (music %.1fs covers the %.1fs video and is trimmed to it)\n", policy, musicLength, finalLength)
+		return plan
+	}
+
+	if policy == musicFitAudio {
+		fmt.Printf("Warning: fit-audio could not stretch the timeline to the music; falling back to %s\n", musicFitTruncate)
+		plan.Policy = musicFitTruncate
+	}
+
+	switch plan.Policy {
+	case musicFitLoop:
+		plan.Loops = musicLoopCount(musicLength, finalLength)
+		fmt.Printf("Music fit: loop (music %.1fs played %d times with %.1fs crossfades to cover %.1fs)\n",
+			musicLength, plan.Loops, musicLoopCrossfade(musicLength), finalLength)
+	case musicFitSilence:
+		fmt.Printf("Music fit: silence (music %.1fs ends, the last %.1fs of the video are silent)\n", musicLength, finalLength-musicLength)
+	default:
+		fmt.Printf("Music fit: truncate-video (music %.1fs is shorter than the %.1fs video)\n", musicLength, finalLength)
+		fmt.Printf("Warning: the video will be cut at %.1fs, dropping %d timeline item(s). Use -music-fit loop, silence, or fit-audio to keep them.\n",
+			musicLength, droppedTimelineItems(mediaInputs, fadeSec, musicLength))
+	}
+	return plan
+}
+
+// musicEnd returns when the music stops in the final video under a plan.
+func musicEnd(plan musicFitPlan, finalLength float64) float64 {
+	if plan.Policy == musicFitLoop || plan.MusicLength <= 0 {
+		return finalLength
+	}
+	return math.Min(plan.MusicLength, finalLength)
+}
+
+// musicJoinCrossfades returns the crossfade of each join when the music list of
+// tracks files plays loops times: -music-crossfade between tracks, and the loop
+// crossfade where the list starts over.
+func musicJoinCrossfades(tracks, loops int, musicLength float64) []float64 {
+	if loops < 1 {
+		loops = 1
+	}
+	joins := make([]float64, 0, tracks*loops-1)
+	for i := 1; i < tracks*loops; i++ {
+		if i%tracks == 0 {
+			joins = append(joins, musicLoopCrossfade(musicLength))
+		} else {
+			joins = append(joins, math.Max(activeOptions.Music.Crossfade, 0))
+		}
+	}
+	return joins
+}
@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// musicTrack is one entry of -music-tracks: a file, the excerpt to play and, for
+// per-section music, the first and last timeline items (1-based) it plays under.
+type musicTrack struct {
+	Path      string
+	Start     float64 // Excerpt start in the file, in seconds
+	End       float64 // Excerpt end in the file; zero plays to the end
+	FirstItem int     // First timeline item of the section; zero when not sectioned
+	LastItem  int     // Last timeline item of the section; zero runs to the next section
+}
+
+// ParseMusicTracks parses a comma-separated track list. Each entry is
+// [ITEMS=]FILE[@START[-END]], e.g. "1-40=song.mp3@1:05-3:20, 41-=other.flac@30s".
+// ITEMS is a range of timeline items ("41-80", "41-" or "41"); either every entry has
+// one or none has. START and END use the -preview-range time formats. File names may
+// not contain ",", "=" or "@", which separate the fields.
+func ParseMusicTracks(value string) ([]musicTrack, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	var tracks []musicTrack
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var track musicTrack
+
+		if items, rest, ok := strings.Cut(entry, "="); ok {
+			first, last, err := parseItemRange(items)
+			if err != nil {
+				return nil, fmt.Errorf("invalid music track %q: %v", entry, err)
+			}
+			track.FirstItem, track.LastItem = first, last
+			entry = strings.TrimSpace(rest)
+		}
+
+		if at := strings.LastIndex(entry, "@"); at >= 0 {
+			excerpt := entry[at+1:]
+			if !strings.Contains(excerpt, "-") {
+				excerpt += "-"
+			}
+			start, end, err := ParsePreviewRange(excerpt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid excerpt in music track %q: %v", entry, err)
+			}
+			track.Start, track.End = start, end
+			entry = strings.TrimSpace(entry[:at])
+		}
+		if entry == "" {
+			return nil, fmt.Errorf("music track without a file in %q", value)
+		}
+		if strings.ContainsAny(entry, "=@") {
+			return nil, fmt.Errorf("invalid music track file %q: file names may not contain \",\", \"=\" or \"@\"; rename the file", entry)
+		}
+		track.Path = entry
+		tracks = append(tracks, track)
+	}
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("no music track in %q", value)
+	}
+
+	sectioned := tracks[0].FirstItem > 0
+	for i, track := range tracks {
+		if (track.FirstItem > 0) != sectioned {
+			return nil, fmt.Errorf("either every music track has an item range or none has")
+		}
+		if !sectioned {
+			continue
+		}
+		if i == 0 && track.FirstItem != 1 {
+			return nil, fmt.Errorf("the first music section must start at item 1, not %d", track.FirstItem)
+		}
+		if i > 0 {
+			previous := tracks[i-1]
+			if track.FirstItem <= previous.FirstItem {
+				return nil, fmt.Errorf("music sections must be in timeline order: item %d follows item %d", track.FirstItem, previous.FirstItem)
+			}
+			if previous.LastItem > 0 && previous.LastItem+1 != track.FirstItem {
+				return nil, fmt.Errorf("music section ending at item %d must be followed by one starting at item %d, not %d", previous.LastItem, previous.LastItem+1, track.FirstItem)
+			}
+		}
+	}
+	return tracks, nil
+}
+
+// parseItemRange parses "41-80", "41-" or "41" into 1-based first and last items;
+// the last item is zero when open.
+func parseItemRange(value string) (int, int, error) {
+	firstText, lastText, _ := strings.Cut(strings.TrimSpace(value), "-")
+	first, err := strconv.Atoi(strings.TrimSpace(firstText))
+	if err != nil || first < 1 {
+		return 0, 0, fmt.Errorf("item range %q must start with an item number from 1", value)
+	}
+	last := 0
+	if lastText = strings.TrimSpace(lastText); lastText != "" {
+		if last, err = strconv.Atoi(lastText); err != nil || last < first {
+			return 0, 0, fmt.Errorf("item range %q must end at or after item %d", value, first)
+		}
+	}
+	return first, last, nil
+}
+
+// musicTrackFiles returns the files of -music-tracks, checking that each exists.
+func musicTrackFiles(tracks []musicTrack) ([]string, error) {
+	files := make([]string, 0, len(tracks))
+	for _, track := range tracks {
+		if _, err := os.Stat(track.Path); err != nil {
+			return nil, fmt.Errorf("music track not found: %s", track.Path)
+		}
+		files = append(files, track.Path)
+	}
+	return files, nil
+}
+
+// musicExcerpt returns the excerpt of the music track at index (tracks repeat when the
+// music loops). Discovered music files play whole.
+func musicExcerpt(index int) (float64, float64) {
+	if len(activeRun.MusicTracks) == 0 {
+		return 0, 0
+	}
+	track := activeRun.MusicTracks[index%len(activeRun.MusicTracks)]
+	return track.Start, track.End
+}
+
+// musicExcerptLength returns how much of a file of the given duration the excerpt at
+// index plays.
+func musicExcerptLength(index int, duration float64) float64 {
+	start, end := musicExcerpt(index)
+	if end > 0 && end < duration {
+		duration = end
+	}
+	return math.Max(duration-start, 0)
+}
+
+// musicExcerptFilter returns the atrim that cuts the excerpt at index, or "".
+func musicExcerptFilter(index int) string {
+	start, end := musicExcerpt(index)
+	switch {
+	case end > 0:
+		return fmt.Sprintf("atrim=start=%s:end=%s,asetpts=PTS-STARTPTS", formatSeconds(start), formatSeconds(end))
+	case start > 0:
+		return fmt.Sprintf("atrim=start=%s,asetpts=PTS-STARTPTS", formatSeconds(start))
+	default:
+		return ""
+	}
+}
+
+// musicSectioned reports whether -music-tracks assigns tracks to timeline ranges.
+func musicSectioned() bool {
+	return len(activeRun.MusicTracks) > 0 && activeRun.MusicTracks[0].FirstItem > 0
+}
+
+// timelineItemIndex returns the index in mediaInputs of the item-th photo or clip
+// (1-based, title and credits cards not counted), or -1 past the end.
+func timelineItemIndex(mediaInputs []MediaInput, item int) int {
+	positions, count := itemPositions(mediaInputs)
+	if item < 1 || item > count {
+		return -1
+	}
+	for i, media := range mediaInputs {
+		if !media.IsCard && positions[i] == item-1 {
+			return i
+		}
+	}
+	return -1
+}
+
+// musicSectionTimes returns when each music section starts in the video (the start of
+// its first item, so the music changes together with the picture) and when the music
+// ends: at the end of the last item of a closed last range, otherwise at finalLength.
+// Items are counted without the title and credits cards, and the first section also
+// covers a title card.
+func musicSectionTimes(mediaInputs []MediaInput, fadeSec, finalLength float64) ([]float64, float64, error) {
+	_, itemCount := itemPositions(mediaInputs)
+	offsets := buildTimelineOffsets(mediaInputs, fadeSec)
+	starts := make([]float64, len(activeRun.MusicTracks))
+	for i, track := range activeRun.MusicTracks {
+		index := timelineItemIndex(mediaInputs, track.FirstItem)
+		if index < 0 {
+			return nil, 0, fmt.Errorf("music section %s starts at item %d but the timeline has %d photos and clips", track.Path, track.FirstItem, itemCount)
+		}
+		if i > 0 {
+			starts[i] = offsets[index]
+		}
+	}
+
+	end := finalLength
+	if last := activeRun.MusicTracks[len(activeRun.MusicTracks)-1]; last.LastItem > 0 {
+		index := timelineItemIndex(mediaInputs, last.LastItem)
+		if index < 0 {
+			return nil, 0, fmt.Errorf("music section %s ends at item %d but the timeline has %d photos and clips", last.Path, last.LastItem, itemCount)
+		}
+		end = math.Min(offsets[index]+mediaInputs[index].SegmentDuration, finalLength)
+	}
+	return starts, end, nil
+}
+
+// musicSectionCrossfade is the crossfade between sections: -music-crossfade, or the
+// video transition when none is set.
+func musicSectionCrossfade(fadeSec float64) float64 {
+	if activeOptions.Music.Crossfade > 0 {
+		return activeOptions.Music.Crossfade
+	}
+	return fadeSec
+}
+
+// buildMusicSectionFilter plays one track per section starting at firstInput: each is
+// padded and trimmed to its section (plus the crossfade into the next), and the
+// sections are joined with acrossfade into outputLabel, each fade starting where
+// the next section begins. The last section runs to end and fades out there when the
+// music ends before finalLength.
+func buildMusicSectionFilter(firstInput int, starts []float64, end, finalLength, crossfade float64, outputLabel string) string {
+	var builder strings.Builder
+	count := len(starts)
+	labels := make([]string, 0, count)
+	for i, start := range starts {
+		length := end - start
+		fadeOut := ""
+		if i+1 < count {
+			length = starts[i+1] - start + crossfade
+		} else if end < finalLength {
+			fadeOut = fmt.Sprintf(",afade=t=out:st=%s:d=%s", formatSeconds(math.Max(length-crossfade, 0)), formatSeconds(crossfade))
+		}
+		label := fmt.Sprintf("musicsec%d", i)
+		if count == 1 {
+			label = outputLabel
+		}
+		fmt.Fprintf(&builder, "%s,apad,atrim=duration=%s,asetpts=PTS-STARTPTS%s[%s]; ", musicTrackFilter(firstInput+i, i), formatSeconds(length), fadeOut, label)
+		labels = append(labels, label)
+	}
+
+	current := labels[0]
+	for i := 1; i < count; i++ {
+		next := fmt.Sprintf("musicsecxf%d", i)
+		if i == count-1 {
+			next = outputLabel
+		}
+		fmt.Fprintf(&builder, "[%s][%s]acrossfade=d=%s:c1=tri:c2=tri[%s]; ", current, labels[i], formatSeconds(crossfade), next)
+		current = next
+	}
+	return builder.String()
+}
@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// narrationPadding is the pause kept after an item's narration before its transition out.
+const narrationPadding = 0.5
+
+// NarrationOptions adds voice-over on top of the music, which ducks under it.
+type NarrationOptions struct {
+	File    string  // Narration track for the whole video; empty for none
+	Offset  float64 // Seconds into the video where File starts
+	PerItem bool    // Play <item basename>.wav (or another music format) over each item
+}
+
+// validateNarration checks the narration offset and that the narration file exists.
+func validateNarration(opts NarrationOptions) error {
+	if opts.Offset < 0 {
+		return fmt.Errorf("narration offset must not be negative")
+	}
+	if opts.File != "" {
+		if _, err := os.Stat(opts.File); err != nil {
+			return fmt.Errorf("narration file not found: %s", opts.File)
+		}
+	}
+	return nil
+}
+
+// narrationClip is one narration recording placed on the timeline.
+type narrationClip struct {
+	Path     string
+	Start    float64 // Seconds into the video
+	Duration float64 // Zero when unknown
+}
+
+// itemNarrationFile returns the narration recorded for an item: an audio file named
+// after the item's source, e.g. IMG_0042.wav for IMG_0042.jpg, or "" when there is none.
+func itemNarrationFile(media MediaInput) string {
+	if media.IsCard {
+		return ""
+	}
+	source := mediaSourcePath(media)
+	base := strings.TrimSuffix(source, filepath.Ext(source))
+
+	extensions := make([]string, 0, len(musicExtensions))
+	for ext := range musicExtensions {
+		extensions = append(extensions, ext)
+	}
+	sort.Strings(extensions)
+	for _, ext := range extensions {
+		for _, candidate := range []string{base + ext, base + strings.ToUpper(ext)} {
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate
+			}
+		}
+	}
+	return ""
+}
+
+// withoutNarrationFiles drops the narration recordings of the run from the music found
+// in the folder, so IMG_0042.wav or the -narration file is not also played as music.
+func withoutNarrationFiles(musicFiles []string, mediaInputs []MediaInput) []string {
+	if len(activeRun.MusicTracks) > 0 {
+		return musicFiles
+	}
+	used := map[string]bool{}
+	if file := strings.TrimSpace(activeOptions.Narration.File); file != "" {
+		used[filepath.Clean(file)] = true
+	}
+	if activeOptions.Narration.PerItem {
+		for _, media := range mediaInputs {
+			if path := itemNarrationFile(media); path != "" {
+				used[filepath.Clean(path)] = true
+			}
+		}
+	}
+	if len(used) == 0 {
+		return musicFiles
+	}
+	kept := musicFiles[:0:0]
+	for _, file := range musicFiles {
+		if !used[filepath.Clean(file)] {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// collectItemNarration finds the per-item narration files and measures them, recording
+// each length on its item so fit-audio and beat sync can hold photos long enough.
+// It returns one entry per item; items without narration have an empty path.
+func collectItemNarration(mediaInputs []MediaInput) []narrationClip {
+	clips := make([]narrationClip, len(mediaInputs))
+	if !activeOptions.Narration.PerItem {
+		return clips
+	}
+	for i, media := range mediaInputs {
+		path := itemNarrationFile(media)
+		if path == "" {
+			continue
+		}
+		clips[i].Path = path
+		if duration, err := getAudioDurationSeconds(path); err == nil {
+			clips[i].Duration = duration
+			mediaInputs[i].NarrationLength = duration
+		} else {
+			fmt.Printf("Warning: could not read the length of narration %s; the item keeps its duration\n", path)
+		}
+	}
+	return clips
+}
+
+// narrationHold returns the shortest hold that lets a photo's narration finish: the
+// transition in, the narration, narrationPadding and the transition out. It is zero
+// for clips, cards and photos without narration.
+func narrationHold(media MediaInput, fadeSec float64) float64 {
+	if !media.IsImage || media.NarrationLength <= 0 {
+		return 0
+	}
+	return media.NarrationLength + narrationPadding + 2*fadeSec
+}
+
+// extendItemsForNarration keeps each photo up until its narration finishes (see
+// narrationHold). Clips keep their length. It returns how many items were extended.
+func extendItemsForNarration(mediaInputs []MediaInput, fadeSec float64) int {
+	extended := 0
+	for i := range mediaInputs {
+		if needed := narrationHold(mediaInputs[i], fadeSec); needed > mediaInputs[i].SegmentDuration {
+			mediaInputs[i].SegmentDuration = needed
+			extended++
+		}
+	}
+	return extended
+}
+
+// placeNarration positions the narration on the final timeline: each item's recording
+// starts once its transition in is over, and the -narration track starts at its offset.
+func placeNarration(mediaInputs []MediaInput, clips []narrationClip, fadeSec float64) []narrationClip {
+	var placed []narrationClip
+	if file := strings.TrimSpace(activeOptions.Narration.File); file != "" {
+		placed = append(placed, narrationClip{Path: file, Start: math.Max(activeOptions.Narration.Offset, 0)})
+	}
+	offsets := buildTimelineOffsets(mediaInputs, fadeSec)
+	for i, clip := range clips {
+		if clip.Path == "" {
+			continue
+		}
+		start := offsets[i]
+		if i > 0 {
+			start += fadeSec
+		}
+		placed = append(placed, narrationClip{Path: clip.Path, Start: start, Duration: clip.Duration})
+	}
+	return placed
+}
+
+// prepareNarration places the narration of the run once item durations are final.
+// clips comes from collectItemNarration; photos that fit-audio or beat sync left too
+// short for their recording are extended first.
+func prepareNarration(mediaInputs []MediaInput, clips []narrationClip, fadeSec float64) []narrationClip {
+	if strings.TrimSpace(activeOptions.Narration.File) == "" && !activeOptions.Narration.PerItem {
+		return nil
+	}
+
+	if extended := extendItemsForNarration(mediaInputs, fadeSec); extended > 0 {
+		fmt.Printf("Narration: %d photo(s) held longer so their narration can finish\n", extended)
+	}
+	narration := placeNarration(mediaInputs, clips, fadeSec)
+	fmt.Printf("Narration: %d recording(s)\n", len(narration))
+	for _, clip := range narration {
+		fmt.Printf("  - %s at %s\n", clip.Path, formatSubtitleTimestamp(clip.Start, "."))
+	}
+	return narration
+}
+
+// buildNarrationFilter decodes the narration inputs starting at firstInput, delays each
+// to its start and mixes them into outputLabel, padded to finalLength.
+func buildNarrationFilter(firstInput int, narration []narrationClip, finalLength float64, outputLabel string) string {
+	var builder strings.Builder
+	labels := make([]string, 0, len(narration))
+	for i, clip := range narration {
+		delayMs := int(math.Round(clip.Start * 1000))
+		label := fmt.Sprintf("narration%d", i)
+		fmt.Fprintf(&builder, "[%d:a]aformat=sample_fmts=fltp:sample_rates=48000:channel_layouts=stereo,aresample=48000,adelay=%d|%d[%s]; ",
+			firstInput+i, delayMs, delayMs, label)
+		labels = append(labels, label)
+	}
+	source := labels[0]
+	if len(labels) > 1 {
+		fmt.Fprintf(&builder, "%samix=inputs=%d:duration=longest:normalize=0[narrationjoined]; ", joinFilterInputs(labels), len(labels))
+		source = "narrationjoined"
+	}
+	fmt.Fprintf(&builder, "[%s]apad,atrim=duration=%s,asetpts=PTS-STARTPTS[%s]; ", source, formatSeconds(finalLength), outputLabel)
+	return builder.String()
+}
@@ -0,0 +1,261 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	overlayRendererDrawtext = "drawtext"
+	overlayRendererImage    = "image"
+)
+
+// NormalizeOverlayRenderer validates an overlay renderer name.
+// "drawtext" uses FFmpeg's drawtext filter (needs libfreetype/fontconfig);
+// "image" pre-renders captions to transparent PNGs in Go with an embedded font.
+func NormalizeOverlayRenderer(renderer string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(renderer)) {
+	case "", overlayRendererDrawtext:
+		return overlayRendererDrawtext, nil
+	case overlayRendererImage, "png", "go":
+		return overlayRendererImage, nil
+	default:
+		return "", fmt.Errorf("invalid overlay renderer %q. Use drawtext or image", renderer)
+	}
+}
+
+// useImageOverlayRenderer reports whether captions are drawn in Go instead of with drawtext.
+func useImageOverlayRenderer() bool {
+	renderer, err := NormalizeOverlayRenderer(activeOptions.Overlay.Renderer)
+	return err == nil && renderer == overlayRendererImage
+}
+
+var (
+	overlayFontCache   = map[string]*opentype.Font{}
+	overlayFontCacheMu sync.Mutex
+)
+
+// loadOverlayFont parses the configured font file, or the embedded Go Regular font
+// when none is set. Parsed fonts are cached for the lifetime of the process.
+func loadOverlayFont(fontFile string) (*opentype.Font, error) {
+	overlayFontCacheMu.Lock()
+	defer overlayFontCacheMu.Unlock()
+
+	if cached, ok := overlayFontCache[fontFile]; ok {
+		return cached, nil
+	}
+
+	data := goregular.TTF
+	if fontFile != "" {
+		fileData, err := os.ReadFile(fontFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read overlay font %s: %v", fontFile, err)
+		}
+		data = fileData
+	}
+
+	parsed, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse overlay font: %v", err)
+	}
+	overlayFontCache[fontFile] = parsed
+	return parsed, nil
+}
+
+var namedOverlayColors = map[string]color.NRGBA{
+	"white":   {255, 255, 255, 255},
+	"black":   {0, 0, 0, 255},
+	"red":     {255, 0, 0, 255},
+	"green":   {0, 128, 0, 255},
+	"blue":    {0, 0, 255, 255},
+	"yellow":  {255, 255, 0, 255},
+	"orange":  {255, 165, 0, 255},
+	"gray":    {128, 128, 128, 255},
+	"grey":    {128, 128, 128, 255},
+	"silver":  {192, 192, 192, 255},
+	"navy":    {0, 0, 128, 255},
+	"gold":    {255, 215, 0, 255},
+	"cyan":    {0, 255, 255, 255},
+	"magenta": {255, 0, 255, 255},
+}
+
+// parseOverlayColor parses FFmpeg-style colours: a name or #RRGGBB / 0xRRGGBB[AA],
+// optionally followed by @opacity (e.g. "black@0.5").
+func parseOverlayColor(value string) (color.NRGBA, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	opacity := -1.0
+	if at := strings.LastIndex(value, "@"); at >= 0 {
+		parsed, err := strconv.ParseFloat(value[at+1:], 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return color.NRGBA{}, fmt.Errorf("invalid colour opacity in %q", value)
+		}
+		opacity = parsed
+		value = value[:at]
+	}
+
+	c, ok := namedOverlayColors[value]
+	if !ok {
+		hex := strings.TrimPrefix(strings.TrimPrefix(value, "#"), "0x")
+		if len(hex) != 6 && len(hex) != 8 {
+			return color.NRGBA{}, fmt.Errorf("unsupported colour %q", value)
+		}
+		n, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return color.NRGBA{}, fmt.Errorf("unsupported colour %q", value)
+		}
+		if len(hex) == 6 {
+			n = n<<8 | 0xff
+		}
+		c = color.NRGBA{R: uint8(n >> 24), G: uint8(n >> 16), B: uint8(n >> 8), A: uint8(n)}
+	}
+
+	if opacity >= 0 {
+		c.A = uint8(opacity*255 + 0.5)
+	}
+	return c, nil
+}
+
+// overlayColorOrDefault parses a configured colour, falling back to the default on error.
+func overlayColorOrDefault(value, fallback string) color.NRGBA {
+	if strings.TrimSpace(value) == "" {
+		value = fallback
+	}
+	c, err := parseOverlayColor(value)
+	if err != nil {
+		fmt.Printf("Warning: %v, using %s\n", err, fallback)
+		c, _ = parseOverlayColor(fallback)
+	}
+	return c
+}
+
+// renderCaptionImage draws caption text with the active overlay style into a
+// transparent PNG sized to the caption box. Lines are centred within the box.
+func renderCaptionImage(text string, fontSize int, outputPath string) error {
+	parsedFont, err := loadOverlayFont(strings.TrimSpace(activeOptions.Overlay.FontFile))
+	if err != nil {
+		return err
+	}
+	face, err := opentype.NewFace(parsedFont, &opentype.FaceOptions{Size: float64(fontSize), DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return fmt.Errorf("failed to create overlay font face: %v", err)
+	}
+	defer face.Close()
+
+	lines := strings.Split(text, "\n")
+	metrics := face.Metrics()
+	lineHeight := metrics.Height.Ceil()
+	ascent := metrics.Ascent.Ceil()
+
+	lineWidths := make([]int, len(lines))
+	textWidth := 0
+	for i, line := range lines {
+		lineWidths[i] = font.MeasureString(face, line).Ceil()
+		if lineWidths[i] > textWidth {
+			textWidth = lineWidths[i]
+		}
+	}
+
+	shadowOffset := 0
+	if activeOptions.Overlay.Shadow {
+		shadowOffset = overlayShadowOffset(fontSize)
+	}
+	boxWidth := textWidth + 2*overlayBoxPadding
+	boxHeight := len(lines)*lineHeight + 2*overlayBoxPadding
+
+	img := image.NewNRGBA(image.Rect(0, 0, boxWidth+shadowOffset, boxHeight+shadowOffset))
+
+	if !activeOptions.Overlay.DisableBox {
+		boxColor := overlayColorOrDefault(activeOptions.Overlay.BoxColor, defaultOverlayBoxColor)
+		boxOpacity := activeOptions.Overlay.BoxOpacity
+		if boxOpacity == 0 {
+			boxOpacity = defaultOverlayBoxOpacity
+		}
+		boxColor.A = uint8(boxOpacity*255 + 0.5)
+		draw.Draw(img, image.Rect(0, 0, boxWidth, boxHeight), &image.Uniform{boxColor}, image.Point{}, draw.Src)
+	}
+
+	drawLines := func(c color.NRGBA, offset int) {
+		drawer := &font.Drawer{Dst: img, Src: &image.Uniform{c}, Face: face}
+		for i, line := range lines {
+			x := overlayBoxPadding + (textWidth-lineWidths[i])/2 + offset
+			y := overlayBoxPadding + i*lineHeight + ascent + offset
+			drawer.Dot = fixed.P(x, y)
+			drawer.DrawString(line)
+		}
+	}
+
+	if shadowOffset > 0 {
+		drawLines(overlayColorOrDefault(activeOptions.Overlay.ShadowColor, defaultOverlayShadowColor), shadowOffset)
+	}
+	drawLines(overlayColorOrDefault(activeOptions.Overlay.FontColor, defaultOverlayFontColor), 0)
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create caption image %s: %v", outputPath, err)
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, img); err != nil {
+		return fmt.Errorf("failed to encode caption image %s: %v", outputPath, err)
+	}
+	return nil
+}
+
+// prepareCaptionImage renders the caption for a timeline item to converted/caption_<index>.png.
+// It returns the PNG path, or "" when the item has no caption or rendering failed.
+func prepareCaptionImage(data OverlayTemplateData, fontSize, index int) string {
+	text := overlayCaptionText(data)
+	if text == "" {
+		return ""
+	}
+
+	// Video-only timelines have no converted images, so the folder may not exist yet.
+	captionFile := generatedFile(fmt.Sprintf("converted/caption_%d.png", index))
+	if err := os.MkdirAll(filepath.Dir(captionFile), os.ModePerm); err != nil {
+		fmt.Printf("Warning: failed to create caption folder: %v\n", err)
+		return ""
+	}
+
+	if err := renderCaptionImage(text, fontSize, captionFile); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return ""
+	}
+	return captionFile
+}
+
+// buildCaptionOverlayFilter composites a pre-rendered caption input onto a base stream
+// at the configured overlay position.
+func buildCaptionOverlayFilter(baseLabel string, captionInputIndex int, duration, fadeDuration float64) string {
+	xPosition, yPosition := overlayPositionExpressions("W", "H", "w", "h")
+	return buildImageOverlayFilter(baseLabel, captionInputIndex, duration, fadeDuration, xPosition, yPosition)
+}
+
+// buildImageOverlayFilter composites a transparent PNG input onto a base stream at x/y.
+// The image fades in and out together with the crossfade when fading is enabled.
+func buildImageOverlayFilter(baseLabel string, inputIndex int, duration, fadeDuration float64, xPosition, yPosition string) string {
+	imageLabel := fmt.Sprintf("cap%d", inputIndex)
+	imageChain := fmt.Sprintf("[%d:v]fps=%d,settb=AVTB,format=rgba", inputIndex, activeFPS)
+	if activeOptions.Overlay.Fade && duration > 0 && fadeDuration > 0 {
+		if fadeDuration*2 > duration {
+			fadeDuration = duration / 2
+		}
+		imageChain += fmt.Sprintf(",fade=t=in:st=0:d=%s:alpha=1,fade=t=out:st=%s:d=%s:alpha=1",
+			formatSeconds(fadeDuration), formatSeconds(duration-fadeDuration), formatSeconds(fadeDuration))
+	}
+
+	return fmt.Sprintf("%s[%s]; [%s][%s]overlay=x=%s:y=%s:format=auto,format=yuv420p",
+		imageChain, imageLabel, baseLabel, imageLabel, xPosition, yPosition)
+}
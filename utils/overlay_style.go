@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	overlayPositionTopLeft     = "top-left"
+	overlayPositionTop         = "top"
+	overlayPositionTopRight    = "top-right"
+	overlayPositionLeft        = "left"
+	overlayPositionCenter      = "center"
+	overlayPositionRight       = "right"
+	overlayPositionBottomLeft  = "bottom-left"
+	overlayPositionBottom      = "bottom"
+	overlayPositionBottomRight = "bottom-right"
+
+	defaultOverlayFontColor   = "white"
+	defaultOverlayBoxColor    = "black"
+	defaultOverlayBoxOpacity  = 0.5
+	defaultOverlayShadowColor = "black@0.6"
+
+	// overlayBoxPadding is the space in pixels between the text and the box edge.
+	overlayBoxPadding = 5
+)
+
+// NormalizeOverlayPosition validates an overlay position name and returns its canonical form.
+// An empty value selects the default bottom-center placement.
+func NormalizeOverlayPosition(position string) (string, error) {
+	position = strings.ToLower(strings.TrimSpace(position))
+	position = strings.ReplaceAll(position, "_", "-")
+	switch position {
+	case "", overlayPositionBottom, "bottom-center":
+		return overlayPositionBottom, nil
+	case overlayPositionTop, "top-center":
+		return overlayPositionTop, nil
+	case overlayPositionCenter, "middle":
+		return overlayPositionCenter, nil
+	case overlayPositionTopLeft, overlayPositionTopRight,
+		overlayPositionLeft, overlayPositionRight,
+		overlayPositionBottomLeft, overlayPositionBottomRight:
+		return position, nil
+	default:
+		return "", fmt.Errorf("invalid overlay position %q. Use top-left, top, top-right, left, center, right, bottom-left, bottom, or bottom-right", position)
+	}
+}
+
+// validateOverlay checks the overlay template, position, renderer, box opacity and font file.
+func validateOverlay(opts OverlayOptions) error {
+	if _, err := ParseOverlayTemplate(opts.Template); err != nil {
+		return err
+	}
+	if _, err := NormalizeOverlayPosition(opts.Position); err != nil {
+		return err
+	}
+	if _, err := NormalizeOverlayRenderer(opts.Renderer); err != nil {
+		return err
+	}
+	if opts.BoxOpacity < 0 || opts.BoxOpacity > 1 {
+		return fmt.Errorf("overlay box opacity must be between 0 and 1, got %v", opts.BoxOpacity)
+	}
+	if opts.FontFile != "" {
+		if _, err := os.Stat(opts.FontFile); err != nil {
+			return fmt.Errorf("overlay font file not found: %s", opts.FontFile)
+		}
+	}
+	return nil
+}
+
+// defaultOverlayMargin returns the footer margin used when none is configured:
+// 40px in UHD, 30px in Full HD, 10px in previews.
+func defaultOverlayMargin() int {
+	if activeResolution == resolutionFullHD {
+		return 30
+	}
+	if activeResolution == resolutionPreview {
+		return 10
+	}
+	return 40
+}
+
+// overlayPositionExpressions returns x/y expressions for the active position and margins.
+// frameW/frameH and itemW/itemH are the variable names of the filter in use
+// (w, h, tw, th for drawtext; W, H, w, h for overlay).
+func overlayPositionExpressions(frameW, frameH, itemW, itemH string) (string, string) {
+	return positionExpressions(activeOptions.Overlay.Position, activeOptions.Overlay.MarginX, activeOptions.Overlay.MarginY, frameW, frameH, itemW, itemH)
+}
+
+// positionExpressions returns x/y expressions placing an item at a named position.
+// Invalid positions fall back to bottom; margins of zero use defaultOverlayMargin.
+func positionExpressions(position string, marginX, marginY int, frameW, frameH, itemW, itemH string) (string, string) {
+	position, err := NormalizeOverlayPosition(position)
+	if err != nil {
+		position = overlayPositionBottom
+	}
+
+	if marginX <= 0 {
+		marginX = defaultOverlayMargin()
+	}
+	if marginY <= 0 {
+		marginY = defaultOverlayMargin()
+	}
+
+	xPosition := fmt.Sprintf("(%s-%s)/2", frameW, itemW)
+	if strings.HasSuffix(position, "left") {
+		xPosition = strconv.Itoa(marginX)
+	} else if strings.HasSuffix(position, "right") {
+		xPosition = fmt.Sprintf("%s-%s-%d", frameW, itemW, marginX)
+	}
+
+	yPosition := fmt.Sprintf("(%s-%s)/2", frameH, itemH)
+	if strings.HasPrefix(position, "top") {
+		yPosition = strconv.Itoa(marginY)
+	} else if strings.HasPrefix(position, "bottom") {
+		yPosition = fmt.Sprintf("%s-%s-%d", frameH, itemH, marginY)
+	}
+
+	return xPosition, yPosition
+}
+
+// overlayFadeExpression returns a drawtext alpha expression that fades the caption
+// in and out together with the crossfade. It returns "" when fading does not apply.
+func overlayFadeExpression(duration, fadeDuration float64) string {
+	if !activeOptions.Overlay.Fade || duration <= 0 || fadeDuration <= 0 {
+		return ""
+	}
+	if fadeDuration*2 > duration {
+		fadeDuration = duration / 2
+	}
+	fade := formatSeconds(fadeDuration)
+	fadeOutStart := formatSeconds(duration - fadeDuration)
+	return fmt.Sprintf("if(lt(t,%s),t/%s,if(gt(t,%s),(%s-t)/%s,1))", fade, fade, fadeOutStart, formatSeconds(duration), fade)
+}
+
+// overlayShadowOffset returns the drop shadow distance in pixels for a font size.
+func overlayShadowOffset(fontSize int) int {
+	offset := fontSize / 16
+	if offset < 2 {
+		offset = 2
+	}
+	return offset
+}
+
+// buildDrawtextStyle returns the drawtext options shared by every caption:
+// font, colours, position, background box, shadow and fade.
+func buildDrawtextStyle(fontSize int, duration, fadeDuration float64) string {
+	xPosition, yPosition := overlayPositionExpressions("w", "h", "tw", "th")
+	return buildDrawtextStyleAt(fontSize, duration, fadeDuration, xPosition, yPosition)
+}
+
+// buildDrawtextStyleAt is buildDrawtextStyle with explicit x/y expressions.
+func buildDrawtextStyleAt(fontSize int, duration, fadeDuration float64, xPosition, yPosition string) string {
+	var options []string
+
+	if fontFile := strings.TrimSpace(activeOptions.Overlay.FontFile); fontFile != "" {
+		options = append(options, fmt.Sprintf("fontfile='%s'", escapeFilterPath(fontFile)))
+	}
+
+	fontColor := strings.TrimSpace(activeOptions.Overlay.FontColor)
+	if fontColor == "" {
+		fontColor = defaultOverlayFontColor
+	}
+	options = append(options,
+		fmt.Sprintf("fontsize=%d", fontSize),
+		"fontcolor="+fontColor,
+		"x="+xPosition,
+		"y="+yPosition,
+	)
+
+	if !activeOptions.Overlay.DisableBox {
+		boxColor := strings.TrimSpace(activeOptions.Overlay.BoxColor)
+		if boxColor == "" {
+			boxColor = defaultOverlayBoxColor
+		}
+		boxOpacity := activeOptions.Overlay.BoxOpacity
+		if boxOpacity == 0 {
+			boxOpacity = defaultOverlayBoxOpacity
+		}
+		options = append(options, "box=1", fmt.Sprintf("boxcolor=%s@%s", boxColor, strconv.FormatFloat(boxOpacity, 'f', -1, 64)), fmt.Sprintf("boxborderw=%d", overlayBoxPadding))
+	}
+
+	if activeOptions.Overlay.Shadow {
+		shadowColor := strings.TrimSpace(activeOptions.Overlay.ShadowColor)
+		if shadowColor == "" {
+			shadowColor = defaultOverlayShadowColor
+		}
+		offset := overlayShadowOffset(fontSize)
+		options = append(options, "shadowcolor="+shadowColor, fmt.Sprintf("shadowx=%d", offset), fmt.Sprintf("shadowy=%d", offset))
+	}
+
+	if alpha := overlayFadeExpression(duration, fadeDuration); alpha != "" {
+		options = append(options, fmt.Sprintf("alpha='%s'", alpha))
+	}
+
+	return strings.Join(options, ":")
+}
+
+// escapeDrawtextText escapes inline drawtext text for a filtergraph script.
+// Text is escaped once for the drawtext option parser (\ ' :) and once more for
+// the filtergraph parser (\ ' [ ] , ;), so any UTF-8 text including ':' and '/'
+// survives unchanged. Use together with expansion=none so '%' is not interpreted.
+func escapeDrawtextText(text string) string {
+	optionLevel := strings.NewReplacer(`\`, `\\`, "'", `\'`, ":", `\:`).Replace(text)
+	return strings.NewReplacer(`\`, `\\`, "'", `\'`, "[", `\[`, "]", `\]`, ",", `\,`, ";", `\;`).Replace(optionLevel)
+}
+
+// escapeFilterPath prepares a path for use inside a single-quoted filter option.
+func escapeFilterPath(path string) string {
+	path = strings.ReplaceAll(path, `\`, "/")
+	return strings.ReplaceAll(path, "'", `'\''`)
+}
@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// defaultOverlayTemplate reproduces the classic "Model - focal - f/ - shutter - ISO - date" caption.
+// Empty fields are skipped by join, so a photo without EXIF date simply has no date.
+const defaultOverlayTemplate = `{{join " - " .Model .FocalLength .FNumber .ExposureTime .ISO .DateTaken}}`
+
+// OverlayTemplateData is the value passed to the overlay text template.
+// Every CameraInfo field is available directly (e.g. {{.Make}}, {{.LensModel}}).
+type OverlayTemplateData struct {
+	CameraInfo
+	Lens     string // Alias for LensModel
+	Filename string // Original file name (without directory)
+	Index    int    // 1-based position in the timeline
+	Count    int    // Total number of items in the timeline
+	Place    string // Location name from XMP metadata, when present
+}
+
+// Date formats the capture time using a Go time layout.
+// It returns an empty string when the capture time is unknown.
+func (d OverlayTemplateData) Date(layout string) string {
+	if d.CapturedAt.IsZero() {
+		return ""
+	}
+	return d.CapturedAt.Format(layout)
+}
+
+var overlayTemplateFuncs = template.FuncMap{
+	// join concatenates the non-empty values with the given separator.
+	"join": func(sep string, values ...string) string {
+		var parts []string
+		for _, value := range values {
+			if strings.TrimSpace(value) != "" {
+				parts = append(parts, value)
+			}
+		}
+		return strings.Join(parts, sep)
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// ParseOverlayTemplate compiles an overlay text template.
+// An empty string selects the default camera layout.
+func ParseOverlayTemplate(text string) (*template.Template, error) {
+	if strings.TrimSpace(text) == "" {
+		text = defaultOverlayTemplate
+	}
+	tmpl, err := template.New("overlay").Funcs(overlayTemplateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid overlay template: %v", err)
+	}
+	return tmpl, nil
+}
+
+var (
+	overlayTemplateCache   = map[string]*template.Template{}
+	overlayTemplateCacheMu sync.Mutex
+)
+
+// cachedOverlayTemplate parses an overlay template once and reuses it for every item.
+func cachedOverlayTemplate(text string) (*template.Template, error) {
+	overlayTemplateCacheMu.Lock()
+	defer overlayTemplateCacheMu.Unlock()
+
+	if cached, ok := overlayTemplateCache[text]; ok {
+		return cached, nil
+	}
+	tmpl, err := ParseOverlayTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	overlayTemplateCache[text] = tmpl
+	return tmpl, nil
+}
+
+// renderOverlayText executes the active overlay template for a single item.
+// Runs of whitespace left behind by empty fields are collapsed.
+func renderOverlayText(data OverlayTemplateData) (string, error) {
+	tmpl, err := cachedOverlayTemplate(activeOptions.Overlay.Template)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("overlay template failed for %s: %v", data.Filename, err)
+	}
+
+	lines := strings.Split(buf.String(), "\n")
+	var kept []string
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n"), nil
+}
+
+// overlayCaptionText returns the caption for a timeline item, or "" when there is nothing to show.
+// Template errors are reported as warnings so a single bad item does not abort the render.
+func overlayCaptionText(data OverlayTemplateData) string {
+	// The default layout is anchored on the camera model; without it there is no caption.
+	if strings.TrimSpace(activeOptions.Overlay.Template) == "" && data.Model == "" {
+		return ""
+	}
+
+	text, err := renderOverlayText(data)
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		return ""
+	}
+	return text
+}
+
+// newOverlayTemplateData builds the template data for a timeline item.
+// index is 0-based and converted to the 1-based value exposed to templates.
+func newOverlayTemplateData(info *CameraInfo, originalFile string, index, count int) OverlayTemplateData {
+	data := OverlayTemplateData{
+		Index: index + 1,
+		Count: count,
+	}
+	if info != nil {
+		data.CameraInfo = *info
+		data.Lens = info.LensModel
+	}
+	if originalFile != "" {
+		data.Filename = filepath.Base(originalFile)
+		data.Place = readXMPPlace(originalFile)
+	}
+	return data
+}
+
+var (
+	xmpPacketPattern = regexp.MustCompile(`(?s)<x:xmpmeta.*?</x:xmpmeta>`)
+	xmpPlaceFields   = []string{"Iptc4xmpCore:Location", "photoshop:City", "photoshop:State", "photoshop:Country"}
+
+	xmpFieldPatterns   = map[string][2]*regexp.Regexp{}
+	xmpFieldPatternsMu sync.Mutex
+)
+
+// xmpFieldPattern returns the attribute and element patterns of an XMP property,
+// compiled once per name.
+func xmpFieldPattern(name string) [2]*regexp.Regexp {
+	xmpFieldPatternsMu.Lock()
+	defer xmpFieldPatternsMu.Unlock()
+
+	if patterns, ok := xmpFieldPatterns[name]; ok {
+		return patterns
+	}
+	quoted := regexp.QuoteMeta(name)
+	patterns := [2]*regexp.Regexp{
+		regexp.MustCompile(quoted + `="([^"]*)"`),
+		regexp.MustCompile(`(?s)<` + quoted + `>(.*?)</` + quoted + `>`),
+	}
+	xmpFieldPatterns[name] = patterns
+	return patterns
+}
+
+// metadataHeaderSize bounds how much of an image is scanned for embedded XMP and IPTC
+// metadata, which JPEGs keep in their APP segments at the start of the file.
+const metadataHeaderSize = 2 << 20
+
+// readFileHeader returns up to limit bytes from the start of a file.
+func readFileHeader(filename string, limit int64) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, limit))
+}
+
+// readXMPPacket returns the raw XMP packet embedded in the header of an image, or an
+// empty string.
+func readXMPPacket(filename string) string {
+	data, err := readFileHeader(filename, metadataHeaderSize)
+	if err != nil {
+		return ""
+	}
+	return string(xmpPacketPattern.Find(data))
+}
+
+// xmpSimpleValue extracts a simple XMP property written either as an attribute
+// (name="value") or as an element (<name>value</name>).
+func xmpSimpleValue(packet, name string) string {
+	for _, pattern := range xmpFieldPattern(name) {
+		if match := pattern.FindStringSubmatch(packet); match != nil {
+			return strings.TrimSpace(unescapeXML(match[1]))
+		}
+	}
+	return ""
+}
+
+func unescapeXML(value string) string {
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'", "&amp;", "&").Replace(value)
+}
+
+// readXMPPlace returns a human readable place name from the XMP location fields
+// (sublocation, city, state, country), skipping duplicates and empty values.
+func readXMPPlace(filename string) string {
+	packet := readXMPPacket(filename)
+	if packet == "" {
+		return ""
+	}
+
+	var parts []string
+	seen := map[string]bool{}
+	for _, field := range xmpPlaceFields {
+		value := xmpSimpleValue(packet, field)
+		if value == "" || seen[value] {
+			continue
+		}
+		seen[value] = true
+		parts = append(parts, value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatCoordinates renders a latitude/longitude pair as "38.6916°N, 9.2160°W".
+func formatCoordinates(lat, lon float64) string {
+	latRef, lonRef := "N", "E"
+	if lat < 0 {
+		latRef, lat = "S", -lat
+	}
+	if lon < 0 {
+		lonRef, lon = "W", -lon
+	}
+	return fmt.Sprintf("%.4f°%s, %.4f°%s", lat, latRef, lon, lonRef)
+}
+
+// parseEXIFDateTime parses the EXIF "2006:01:02 15:04:05" date format.
+func parseEXIFDateTime(value string) (time.Time, bool) {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	t, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	resolutionPreview  = "640x360"
+	outputVideoPreview = "video_preview.mp4"
+	previewFPS         = 30
+	previewCRF         = "28"
+)
+
+// ParsePreviewRange parses a preview window such as "60s-120s", "1:00-2:00" or "90-".
+// Each side is seconds (optional "s" suffix) or [H:]MM:SS; an empty end runs to the end
+// of the video, returned as 0.
+func ParsePreviewRange(value string) (float64, float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, 0, nil
+	}
+	startText, endText, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid preview range %q. Use start-end, e.g. 60s-120s or 1:00-2:00", value)
+	}
+
+	start, err := parsePreviewTime(startText)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid preview range start %q: %v", startText, err)
+	}
+	end := 0.0
+	if strings.TrimSpace(endText) != "" {
+		if end, err = parsePreviewTime(endText); err != nil {
+			return 0, 0, fmt.Errorf("invalid preview range end %q: %v", endText, err)
+		}
+		if end <= start {
+			return 0, 0, fmt.Errorf("invalid preview range %q: end must be after start", value)
+		}
+	}
+	return start, end, nil
+}
+
+// parsePreviewTime parses seconds ("75", "75s", "75.5s") or [H:]MM:SS ("1:15", "0:01:15").
+func parsePreviewTime(value string) (float64, error) {
+	value = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), "s")
+	if value == "" {
+		return 0, nil
+	}
+
+	seconds := 0.0
+	for _, part := range strings.Split(value, ":") {
+		number, err := strconv.ParseFloat(part, 64)
+		if err != nil || number < 0 {
+			return 0, fmt.Errorf("not a time value")
+		}
+		seconds = seconds*60 + number
+	}
+	return seconds, nil
+}
+
+// previewScaled converts a size in pixels of the requested resolution (font sizes,
+// margins) to the preview frame. Values of zero keep meaning "use the default".
+func previewScaled(value int) int {
+	if value <= 0 {
+		return value
+	}
+	sourceWidth := 3840
+	if activeSourceResolution == resolutionFullHD {
+		sourceWidth = 1920
+	}
+	previewWidth, _ := activeCanvasSize()
+	scaled := int(math.Round(float64(value) * float64(previewWidth) / float64(sourceWidth)))
+	if scaled < 1 {
+		scaled = 1
+	}
+	return scaled
+}
+
+// previewVideoSettings returns fast software encoder settings for preview renders.
+// Hardware detection is skipped: at 640x360 ultrafast libx264 is quicker than probing.
+func previewVideoSettings() []string {
+	fmt.Printf("Preview: libx264 ultrafast at %s, %d fps\n", activeResolution, activeFPS)
+	return []string{
+		"-pix_fmt", "yuv420p",
+		"-movflags", "+faststart",
+		"-r", strconv.Itoa(activeFPS),
+		"-s", activeResolution,
+		"-c:v", "libx264",
+		"-preset", "ultrafast",
+		"-crf", previewCRF,
+	}
+}
+
+// previewWindow returns the part of the timeline a preview renders: the -preview-range
+// clamped to the video. It returns zeros when no range is set and everything is rendered.
+func previewWindow(finalLength float64) (float64, float64, error) {
+	start, end, err := ParsePreviewRange(activeOptions.Preview.Range)
+	if err != nil || strings.TrimSpace(activeOptions.Preview.Range) == "" {
+		return 0, 0, err
+	}
+	if end == 0 || end > finalLength {
+		end = finalLength
+	}
+	if start >= end {
+		return 0, 0, fmt.Errorf("preview range starts at %s but the video is only %s long", formatSeconds(start), formatSeconds(finalLength))
+	}
+	fmt.Printf("Preview range: %s - %s\n", formatSubtitleTimestamp(start, "."), formatSubtitleTimestamp(end, "."))
+	return start, end, nil
+}
+
+// previewSkipsItem reports whether a timeline item lies entirely outside the preview
+// window, transitions included, so none of its frames are shown.
+func previewSkipsItem(offset, duration float64) bool {
+	if activeRun.PreviewEnd <= 0 {
+		return false
+	}
+	return offset+duration <= activeRun.PreviewStart || offset >= activeRun.PreviewEnd
+}
+
+// previewPlaceholderFilter stands in for an item outside the preview window: black frames
+// of the item's length keep the crossfade offsets in place without decoding the item or
+// running its Ken Burns, overlay and caption filters.
+func previewPlaceholderFilter(duration float64) string {
+	return fmt.Sprintf("color=c=black:s=%s:r=%d:d=%s,settb=AVTB,setsar=1,format=yuv420p", activeResolution, activeFPS, formatSeconds(duration))
+}
+
+// applyPreviewWindow trims the finished video and audio to the preview window inside the
+// filter graph and maps the trimmed streams instead. It returns the filter graph, the
+// map arguments and the length of the preview.
+func applyPreviewWindow(filterComplex string, mapArgs []string, hasAudio bool) (string, []string, float64) {
+	start, end := formatSeconds(activeRun.PreviewStart), formatSeconds(activeRun.PreviewEnd)
+	filterComplex += fmt.Sprintf("[xfout]trim=start=%s:end=%s,setpts=PTS-STARTPTS[xfpreview]; ", start, end)
+	if hasAudio {
+		filterComplex += fmt.Sprintf("[%s]atrim=start=%s:end=%s,asetpts=PTS-STARTPTS[audiopreview]; ", audioMixLabel, start, end)
+	}
+
+	windowed := make([]string, len(mapArgs))
+	for i, arg := range mapArgs {
+		switch arg {
+		case "[xfout]":
+			arg = "[xfpreview]"
+		case "[" + audioMixLabel + "]":
+			arg = "[audiopreview]"
+		}
+		windowed[i] = arg
+	}
+	return filterComplex, windowed, activeRun.PreviewEnd - activeRun.PreviewStart
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	subtitleTextCaption = "caption"
+	subtitleTextEXIF    = "exif"
+	subtitleTextBoth    = "both"
+)
+
+// subtitleCue is one timed subtitle entry, in seconds from the start of the video.
+type subtitleCue struct {
+	Start float64
+	End   float64
+	Text  string
+}
+
+// NormalizeSubtitleText validates which text goes into the subtitle track.
+// An empty value selects both the caption and the EXIF text.
+func NormalizeSubtitleText(text string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "", subtitleTextBoth, "all":
+		return subtitleTextBoth, nil
+	case subtitleTextCaption, "captions", "description":
+		return subtitleTextCaption, nil
+	case subtitleTextEXIF, "camera":
+		return subtitleTextEXIF, nil
+	default:
+		return "", fmt.Errorf("invalid subtitle text %q. Use caption, exif, or both", text)
+	}
+}
+
+// subtitleItemText returns the subtitle text of a timeline item: its description caption
+// and/or the EXIF overlay text, one per line. Cards have no subtitle.
+func subtitleItemText(media MediaInput, index, count int) string {
+	if media.IsCard {
+		return ""
+	}
+	mode, err := NormalizeSubtitleText(activeOptions.Subtitles.Text)
+	if err != nil {
+		mode = subtitleTextBoth
+	}
+
+	var parts []string
+	if mode != subtitleTextEXIF {
+		if caption := mediaCaptionText(media); caption != "" {
+			parts = append(parts, caption)
+		}
+	}
+	if mode != subtitleTextCaption {
+		if data, ok := mediaOverlayData(media, index, count); ok {
+			if text := overlayCaptionText(data); text != "" {
+				parts = append(parts, text)
+			}
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// buildSubtitleCues times one cue per item that has text. Cues change at the middle of each
+// crossfade (offsets from buildTimelineOffsets); the first starts at 0 and the last ends at finalLength.
+func buildSubtitleCues(mediaInputs []MediaInput, texts []string, fadeSec, finalLength float64) []subtitleCue {
+	offsets := buildTimelineOffsets(mediaInputs, fadeSec)
+	var cues []subtitleCue
+	for i, text := range texts {
+		if text == "" {
+			continue
+		}
+		start := 0.0
+		if i > 0 {
+			start = offsets[i] + fadeSec/2
+		}
+		end := finalLength
+		if i+1 < len(mediaInputs) {
+			end = offsets[i+1] + fadeSec/2
+		}
+		if end > finalLength {
+			end = finalLength
+		}
+		if end <= start {
+			continue
+		}
+		cues = append(cues, subtitleCue{Start: start, End: end, Text: text})
+	}
+	return cues
+}
+
+// formatSubtitleTimestamp formats seconds as HH:MM:SS<sep>mmm.
+func formatSubtitleTimestamp(seconds float64, separator string) string {
+	millis := int64(math.Round(seconds * 1000))
+	hours := millis / 3600000
+	minutes := (millis % 3600000) / 60000
+	secs := (millis % 60000) / 1000
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", hours, minutes, secs, separator, millis%1000)
+}
+
+// formatSRT renders cues as a SubRip file.
+func formatSRT(cues []subtitleCue) string {
+	var builder strings.Builder
+	for i, cue := range cues {
+		fmt.Fprintf(&builder, "%d\n%s --> %s\n%s\n\n", i+1,
+			formatSubtitleTimestamp(cue.Start, ","), formatSubtitleTimestamp(cue.End, ","), cue.Text)
+	}
+	return builder.String()
+}
+
+// formatWebVTT renders cues as a WebVTT file. Markup characters are escaped so
+// captions are shown verbatim.
+func formatWebVTT(cues []subtitleCue) string {
+	escaper := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	var builder strings.Builder
+	builder.WriteString("WEBVTT\n\n")
+	for _, cue := range cues {
+		fmt.Fprintf(&builder, "%s --> %s\n%s\n\n",
+			formatSubtitleTimestamp(cue.Start, "."), formatSubtitleTimestamp(cue.End, "."), escaper.Replace(cue.Text))
+	}
+	return builder.String()
+}
+
+// subtitleFilenames returns the SRT and WebVTT paths written next to the output video.
+func subtitleFilenames(outputFilename string) (string, string) {
+	base := strings.TrimSuffix(outputFilename, filepath.Ext(outputFilename))
+	return base + ".srt", base + ".vtt"
+}
+
+// prepareSubtitleTrack writes the SRT and WebVTT files for the timeline and returns the
+// extra FFmpeg input arguments (the SRT file) and output arguments that mux it as a
+// mov_text stream (SRT in MKV, WebVTT in WebM). inputIndex is the index the SRT input will have.
+// It returns nil slices when the subtitle track is off or no item has text.
+func prepareSubtitleTrack(mediaInputs []MediaInput, fadeSec, finalLength float64, outputFilename string, inputIndex int) ([]string, []string) {
+	if !activeOptions.Subtitles.Enabled {
+		return nil, nil
+	}
+
+	texts := make([]string, len(mediaInputs))
+	positions, itemCount := itemPositions(mediaInputs)
+	for i, media := range mediaInputs {
+		texts[i] = subtitleItemText(media, positions[i], itemCount)
+	}
+	cues := buildSubtitleCues(mediaInputs, texts, fadeSec, finalLength)
+	if len(cues) == 0 {
+		fmt.Printf("Subtitle track skipped: no item has caption or EXIF text.\n")
+		return nil, nil
+	}
+
+	srtFile, vttFile := subtitleFilenames(outputFilename)
+	srtFile, vttFile = generatedFile(srtFile), generatedFile(vttFile)
+	if err := os.WriteFile(vttFile, []byte(formatWebVTT(cues)), 0644); err != nil {
+		fmt.Printf("Warning: failed to write %s: %v\n", vttFile, err)
+	}
+	if err := os.WriteFile(srtFile, []byte(formatSRT(cues)), 0644); err != nil {
+		fmt.Printf("Warning: failed to write %s, video will have no subtitle track: %v\n", srtFile, err)
+		return nil, nil
+	}
+	fmt.Printf("Subtitle track: %d cues (%s, %s)\n", len(cues), srtFile, vttFile)
+
+	inputArgs := []string{"-f", "srt", "-i", srtFile}
+	outputArgs := []string{
+		"-map", strconv.Itoa(inputIndex) + ":s",
+		"-c:s", containerSubtitleCodec(),
+		"-metadata:s:s:0", "language=und",
+		"-metadata:s:s:0", "handler_name=Captions",
+	}
+	return inputArgs, outputArgs
+}
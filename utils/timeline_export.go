@@ -0,0 +1,532 @@
+package utils
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	exportFormatFCPXML = "fcpxml"
+	exportFormatEDL    = "edl"
+	exportFormatOTIO   = "otio"
+
+	// fcpCrossDissolveUID identifies Final Cut Pro's built-in Cross Dissolve.
+	fcpCrossDissolveUID = "FxPlug:4731E73A-8DAC-4113-9A30-AE85B1761265"
+)
+
+// NormalizeExportFormats parses a comma-separated list of timeline export formats
+// (fcpxml, edl, otio or all). An empty value disables exports.
+func NormalizeExportFormats(value string) ([]string, error) {
+	var formats []string
+	seen := map[string]bool{}
+	add := func(format string) {
+		if !seen[format] {
+			seen[format] = true
+			formats = append(formats, format)
+		}
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(part)) {
+		case "", "none":
+		case "all":
+			add(exportFormatFCPXML)
+			add(exportFormatEDL)
+			add(exportFormatOTIO)
+		case exportFormatFCPXML, "fcp", "xml":
+			add(exportFormatFCPXML)
+		case exportFormatEDL, "cmx3600":
+			add(exportFormatEDL)
+		case exportFormatOTIO, "opentimelineio":
+			add(exportFormatOTIO)
+		default:
+			return nil, fmt.Errorf("invalid export format %q. Use fcpxml, edl, otio, or all", strings.TrimSpace(part))
+		}
+	}
+	return formats, nil
+}
+
+// exportClip is a timeline item as seen by an editor: it occupies the record range
+// between the centres of its transitions and uses its source from SourceIn on.
+type exportClip struct {
+	Name           string
+	Path           string
+	IsStill        bool
+	HasAudio       bool
+	SourceDuration float64 // Length of the media (the segment length for stills)
+	Offset         float64 // Start of the item in the go24k timeline (see buildTimelineOffsets)
+	RecordStart    float64
+	RecordEnd      float64
+	SourceIn       float64
+}
+
+// exportAudio is a music track placed on the audio lane, played from SourceIn on.
+type exportAudio struct {
+	Name     string
+	Path     string
+	Start    float64
+	Duration float64
+	SourceIn float64
+}
+
+// exportTimeline is the editor-neutral description shared by every export format.
+type exportTimeline struct {
+	Name     string
+	FPS      int
+	Width    int
+	Height   int
+	Duration float64
+	Fade     float64
+	Clips    []exportClip
+	Music    []exportAudio
+}
+
+// buildExportTimeline converts the media timeline into editor terms. Cuts sit in the
+// middle of each crossfade, so every dissolve uses half the fade from each side.
+// musicDurations holds how long each music file plays and musicSourceIns where in the
+// file it starts; unknown (zero) lengths run to the end. Consecutive tracks overlap by
+// the music crossfade, and -music-tracks sections start at their first item.
+func buildExportTimeline(mediaInputs []MediaInput, fadeSec, finalLength float64, outputFilename string, musicFiles []string, musicDurations, musicSourceIns []float64) exportTimeline {
+	width, height := activeCanvasSize()
+	timeline := exportTimeline{
+		Name:     strings.TrimSuffix(filepath.Base(outputFilename), filepath.Ext(outputFilename)),
+		FPS:      activeFPS,
+		Width:    width,
+		Height:   height,
+		Duration: finalLength,
+		Fade:     fadeSec,
+	}
+
+	offsets := buildTimelineOffsets(mediaInputs, fadeSec)
+	for i, media := range mediaInputs {
+		clip := exportClip{
+			Name:           filepath.Base(media.Path),
+			Path:           media.Path,
+			IsStill:        media.IsImage,
+			HasAudio:       !media.IsImage && media.HasAudio,
+			SourceDuration: media.SegmentDuration,
+			Offset:         offsets[i],
+			RecordEnd:      finalLength,
+		}
+		if i > 0 {
+			clip.RecordStart = offsets[i] + fadeSec/2
+			clip.SourceIn = fadeSec / 2
+		}
+		if i+1 < len(mediaInputs) {
+			clip.RecordEnd = offsets[i+1] + fadeSec/2
+		}
+		timeline.Clips = append(timeline.Clips, clip)
+	}
+
+	sourceIn := func(i int) float64 {
+		if i < len(musicSourceIns) {
+			return musicSourceIns[i]
+		}
+		return 0
+	}
+	playLength := func(i int, limit float64) float64 {
+		if i < len(musicDurations) && musicDurations[i] > 0 && musicDurations[i] < limit {
+			return musicDurations[i]
+		}
+		return limit
+	}
+
+	if musicSectioned() {
+		// Sections were checked against the timeline in GenerateVideo.
+		if starts, end, err := musicSectionTimes(mediaInputs, fadeSec, finalLength); err == nil {
+			for i, file := range musicFiles {
+				sectionEnd := end
+				if i+1 < len(starts) {
+					sectionEnd = starts[i+1]
+				}
+				timeline.Music = append(timeline.Music, exportAudio{Name: filepath.Base(file), Path: file, Start: starts[i],
+					Duration: playLength(i, sectionEnd-starts[i]), SourceIn: sourceIn(i)})
+			}
+		}
+		return timeline
+	}
+
+	position := 0.0
+	for i, file := range musicFiles {
+		if position >= finalLength {
+			break
+		}
+		duration := playLength(i, finalLength-position)
+		timeline.Music = append(timeline.Music, exportAudio{Name: filepath.Base(file), Path: file, Start: position, Duration: duration, SourceIn: sourceIn(i)})
+		position += duration
+		if activeOptions.Music.Crossfade > 0 && i+1 < len(musicFiles) {
+			position -= activeOptions.Music.Crossfade
+		}
+	}
+	return timeline
+}
+
+// frames converts seconds to a whole number of frames at the timeline rate.
+func (t exportTimeline) frames(seconds float64) int64 {
+	return int64(math.Round(seconds * float64(t.FPS)))
+}
+
+// fileURL returns an absolute file:// URL for a media path.
+func fileURL(path string) string {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		absolute = path
+	}
+	absolute = filepath.ToSlash(absolute)
+	if !strings.HasPrefix(absolute, "/") {
+		absolute = "/" + absolute // Windows drive paths
+	}
+	return (&url.URL{Scheme: "file", Path: absolute}).String()
+}
+
+// edlTimecode formats a frame count as non-drop-frame HH:MM:SS:FF.
+func edlTimecode(frames int64, fps int) string {
+	rate := int64(fps)
+	return fmt.Sprintf("%02d:%02d:%02d:%02d", frames/(3600*rate), (frames/(60*rate))%60, (frames/rate)%60, frames%rate)
+}
+
+// formatEDL renders the timeline as a CMX3600 EDL. Each crossfade is a dissolve that
+// starts where the incoming item starts in the go24k timeline; music follows as audio events.
+func formatEDL(t exportTimeline) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "TITLE: %s\nFCM: NON-DROP FRAME\n\n", t.Name)
+
+	event := 1
+	writeComment := func(name, path string) {
+		fmt.Fprintf(&builder, "* FROM CLIP NAME: %s\n* SOURCE FILE: %s\n", name, filepath.ToSlash(path))
+	}
+	fadeFrames := t.frames(t.Fade)
+
+	for i, clip := range t.Clips {
+		recordIn := t.frames(clip.Offset)
+		recordOut := t.frames(t.Duration)
+		if i+1 < len(t.Clips) {
+			recordOut = t.frames(t.Clips[i+1].Offset)
+		}
+		sourceOut := recordOut - recordIn
+
+		if i == 0 {
+			fmt.Fprintf(&builder, "%03d  AX       V     C        %s %s %s %s\n", event,
+				edlTimecode(0, t.FPS), edlTimecode(sourceOut, t.FPS), edlTimecode(recordIn, t.FPS), edlTimecode(recordOut, t.FPS))
+			writeComment(clip.Name, clip.Path)
+		} else {
+			previous := t.Clips[i-1]
+			previousOut := recordIn - t.frames(previous.Offset)
+			fmt.Fprintf(&builder, "%03d  AX       V     C        %s %s %s %s\n", event,
+				edlTimecode(previousOut, t.FPS), edlTimecode(previousOut, t.FPS), edlTimecode(recordIn, t.FPS), edlTimecode(recordIn, t.FPS))
+			fmt.Fprintf(&builder, "%03d  AX       V     D    %03d %s %s %s %s\n", event, fadeFrames,
+				edlTimecode(0, t.FPS), edlTimecode(sourceOut, t.FPS), edlTimecode(recordIn, t.FPS), edlTimecode(recordOut, t.FPS))
+			writeComment(previous.Name, previous.Path)
+			fmt.Fprintf(&builder, "* TO CLIP NAME: %s\n* SOURCE FILE: %s\n", clip.Name, filepath.ToSlash(clip.Path))
+		}
+		builder.WriteString("\n")
+		event++
+	}
+
+	for _, music := range t.Music {
+		recordIn, duration, sourceIn := t.frames(music.Start), t.frames(music.Duration), t.frames(music.SourceIn)
+		fmt.Fprintf(&builder, "%03d  AX       AA    C        %s %s %s %s\n", event,
+			edlTimecode(sourceIn, t.FPS), edlTimecode(sourceIn+duration, t.FPS), edlTimecode(recordIn, t.FPS), edlTimecode(recordIn+duration, t.FPS))
+		writeComment(music.Name, music.Path)
+		builder.WriteString("\n")
+		event++
+	}
+	return builder.String()
+}
+
+// fcpTime formats seconds as an FCPXML rational time on the frame grid.
+func (t exportTimeline) fcpTime(seconds float64) string {
+	frames := t.frames(seconds)
+	if frames == 0 {
+		return "0s"
+	}
+	return fmt.Sprintf("%d/%ds", frames, t.FPS)
+}
+
+// formatFCPXML renders the timeline as FCPXML 1.9 for Final Cut Pro and DaVinci Resolve.
+// Items form the primary storyline joined by Cross Dissolves; music is a connected audio lane.
+func formatFCPXML(t exportTimeline) string {
+	var resources, spine strings.Builder
+	fmt.Fprintf(&resources, "    <format id=\"r0\" name=\"go24k %dx%d %dfps\" frameDuration=\"1/%ds\" width=\"%d\" height=\"%d\"/>\n",
+		t.Width, t.Height, t.FPS, t.FPS, t.Width, t.Height)
+	fmt.Fprintf(&resources, "    <format id=\"r1\" name=\"FFVideoFormatRateUndefined\" width=\"%d\" height=\"%d\"/>\n", t.Width, t.Height)
+	fmt.Fprintf(&resources, "    <effect id=\"r2\" name=\"Cross Dissolve\" uid=\"%s\"/>\n", fcpCrossDissolveUID)
+
+	nextID := 3
+	assetIDs := map[string]string{}
+	addAsset := func(name, path, attributes string) string {
+		if id, ok := assetIDs[path]; ok {
+			return id
+		}
+		id := fmt.Sprintf("r%d", nextID)
+		nextID++
+		assetIDs[path] = id
+		fmt.Fprintf(&resources, "    <asset id=\"%s\" name=\"%s\" start=\"0s\" %s>\n      <media-rep kind=\"original-media\" src=\"%s\"/>\n    </asset>\n",
+			id, xmlEscape(name), attributes, xmlEscape(fileURL(path)))
+		return id
+	}
+
+	var music strings.Builder
+	for _, track := range t.Music {
+		id := addAsset(track.Name, track.Path, fmt.Sprintf("duration=\"%s\" hasAudio=\"1\" audioSources=\"1\" audioChannels=\"2\" audioRate=\"48000\"", t.fcpTime(track.SourceIn+track.Duration)))
+		fmt.Fprintf(&music, "              <asset-clip ref=\"%s\" lane=\"-1\" offset=\"%s\" name=\"%s\" start=\"%s\" duration=\"%s\" audioRole=\"music\"/>\n",
+			id, t.fcpTime(track.Start), xmlEscape(track.Name), t.fcpTime(track.SourceIn), t.fcpTime(track.Duration))
+	}
+
+	for i, clip := range t.Clips {
+		if i > 0 {
+			fmt.Fprintf(&spine, "            <transition name=\"Cross Dissolve\" offset=\"%s\" duration=\"%s\">\n              <filter-video ref=\"r2\" name=\"Cross Dissolve\"/>\n            </transition>\n",
+				t.fcpTime(clip.RecordStart-t.Fade/2), t.fcpTime(t.Fade))
+		}
+
+		element := "asset-clip"
+		var id string
+		if clip.IsStill {
+			element = "video"
+			id = addAsset(clip.Name, clip.Path, "duration=\"0s\" hasVideo=\"1\" format=\"r1\" videoSources=\"1\"")
+		} else {
+			attributes := fmt.Sprintf("duration=\"%s\" hasVideo=\"1\" format=\"r0\" videoSources=\"1\"", t.fcpTime(clip.SourceDuration))
+			if clip.HasAudio {
+				attributes += " hasAudio=\"1\" audioSources=\"1\" audioChannels=\"2\""
+			}
+			id = addAsset(clip.Name, clip.Path, attributes)
+		}
+
+		fmt.Fprintf(&spine, "            <%s ref=\"%s\" offset=\"%s\" name=\"%s\" start=\"%s\" duration=\"%s\"",
+			element, id, t.fcpTime(clip.RecordStart), xmlEscape(clip.Name), t.fcpTime(clip.SourceIn), t.fcpTime(clip.RecordEnd-clip.RecordStart))
+		if i == 0 && music.Len() > 0 {
+			// Connected clips live inside the first storyline item, whose local time starts at 0.
+			spine.WriteString(">\n")
+			spine.WriteString(music.String())
+			fmt.Fprintf(&spine, "            </%s>\n", element)
+		} else {
+			spine.WriteString("/>\n")
+		}
+	}
+
+	var builder strings.Builder
+	builder.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<!DOCTYPE fcpxml>\n<fcpxml version=\"1.9\">\n  <resources>\n")
+	builder.WriteString(resources.String())
+	builder.WriteString("  </resources>\n  <library>\n    <event name=\"go24k\">\n")
+	fmt.Fprintf(&builder, "      <project name=\"%s\">\n        <sequence format=\"r0\" duration=\"%s\" tcStart=\"0s\" tcFormat=\"NDF\">\n          <spine>\n",
+		xmlEscape(t.Name), t.fcpTime(t.Duration))
+	builder.WriteString(spine.String())
+	builder.WriteString("          </spine>\n        </sequence>\n      </project>\n    </event>\n  </library>\n</fcpxml>\n")
+	return builder.String()
+}
+
+// xmlEscape escapes text for XML attribute values.
+func xmlEscape(value string) string {
+	var builder strings.Builder
+	if err := xml.EscapeText(&builder, []byte(value)); err != nil {
+		return value
+	}
+	return builder.String()
+}
+
+// OpenTimelineIO JSON schema objects (OTIO_SCHEMA names are part of the file format).
+type otioRationalTime struct {
+	Schema string  `json:"OTIO_SCHEMA"`
+	Rate   float64 `json:"rate"`
+	Value  float64 `json:"value"`
+}
+
+type otioTimeRange struct {
+	Schema    string           `json:"OTIO_SCHEMA"`
+	StartTime otioRationalTime `json:"start_time"`
+	Duration  otioRationalTime `json:"duration"`
+}
+
+type otioExternalReference struct {
+	Schema         string         `json:"OTIO_SCHEMA"`
+	Name           string         `json:"name"`
+	TargetURL      string         `json:"target_url"`
+	AvailableRange *otioTimeRange `json:"available_range"`
+	Metadata       map[string]any `json:"metadata"`
+}
+
+type otioClip struct {
+	Schema         string                `json:"OTIO_SCHEMA"`
+	Name           string                `json:"name"`
+	SourceRange    otioTimeRange         `json:"source_range"`
+	MediaReference otioExternalReference `json:"media_reference"`
+	Effects        []any                 `json:"effects"`
+	Markers        []any                 `json:"markers"`
+	Enabled        bool                  `json:"enabled"`
+	Metadata       map[string]any        `json:"metadata"`
+}
+
+type otioGap struct {
+	Schema      string         `json:"OTIO_SCHEMA"`
+	Name        string         `json:"name"`
+	SourceRange otioTimeRange  `json:"source_range"`
+	Effects     []any          `json:"effects"`
+	Markers     []any          `json:"markers"`
+	Enabled     bool           `json:"enabled"`
+	Metadata    map[string]any `json:"metadata"`
+}
+
+type otioTransition struct {
+	Schema         string           `json:"OTIO_SCHEMA"`
+	Name           string           `json:"name"`
+	TransitionType string           `json:"transition_type"`
+	InOffset       otioRationalTime `json:"in_offset"`
+	OutOffset      otioRationalTime `json:"out_offset"`
+	Metadata       map[string]any   `json:"metadata"`
+}
+
+type otioComposition struct {
+	Schema   string         `json:"OTIO_SCHEMA"`
+	Name     string         `json:"name"`
+	Kind     string         `json:"kind,omitempty"`
+	Children []any          `json:"children"`
+	Effects  []any          `json:"effects"`
+	Markers  []any          `json:"markers"`
+	Enabled  bool           `json:"enabled"`
+	Metadata map[string]any `json:"metadata"`
+}
+
+type otioTimeline struct {
+	Schema          string          `json:"OTIO_SCHEMA"`
+	Name            string          `json:"name"`
+	GlobalStartTime any             `json:"global_start_time"`
+	Tracks          otioComposition `json:"tracks"`
+	Metadata        map[string]any  `json:"metadata"`
+}
+
+func (t exportTimeline) otioTime(seconds float64) otioRationalTime {
+	return otioRationalTime{Schema: "RationalTime.1", Rate: float64(t.FPS), Value: float64(t.frames(seconds))}
+}
+
+func (t exportTimeline) otioRange(start, duration float64) otioTimeRange {
+	return otioTimeRange{Schema: "TimeRange.1", StartTime: t.otioTime(start), Duration: t.otioTime(duration)}
+}
+
+func (t exportTimeline) otioClip(name, path string, sourceIn, duration float64, available *otioTimeRange) otioClip {
+	return otioClip{
+		Schema:      "Clip.1",
+		Name:        name,
+		SourceRange: t.otioRange(sourceIn, duration),
+		MediaReference: otioExternalReference{
+			Schema:         "ExternalReference.1",
+			TargetURL:      fileURL(path),
+			AvailableRange: available,
+			Metadata:       map[string]any{},
+		},
+		Effects:  []any{},
+		Markers:  []any{},
+		Enabled:  true,
+		Metadata: map[string]any{},
+	}
+}
+
+// formatOTIO renders the timeline as OpenTimelineIO JSON with a video track of clips
+// and SMPTE dissolves and an audio track with the music.
+func formatOTIO(t exportTimeline) (string, error) {
+	newTrack := func(name, kind string) otioComposition {
+		return otioComposition{Schema: "Track.1", Name: name, Kind: kind, Children: []any{}, Effects: []any{}, Markers: []any{}, Enabled: true, Metadata: map[string]any{}}
+	}
+
+	video := newTrack("V1", "Video")
+	for i, clip := range t.Clips {
+		if i > 0 {
+			video.Children = append(video.Children, otioTransition{
+				Schema:         "Transition.1",
+				Name:           "Cross Dissolve",
+				TransitionType: "SMPTE_Dissolve",
+				InOffset:       t.otioTime(t.Fade / 2),
+				OutOffset:      t.otioTime(t.Fade / 2),
+				Metadata:       map[string]any{},
+			})
+		}
+		var available *otioTimeRange
+		if !clip.IsStill {
+			r := t.otioRange(0, clip.SourceDuration)
+			available = &r
+		}
+		video.Children = append(video.Children, t.otioClip(clip.Name, clip.Path, clip.SourceIn, clip.RecordEnd-clip.RecordStart, available))
+	}
+
+	tracks := otioComposition{Schema: "Stack.1", Name: "tracks", Children: []any{video}, Effects: []any{}, Markers: []any{}, Enabled: true, Metadata: map[string]any{}}
+	if len(t.Music) > 0 {
+		audio := newTrack("Music", "Audio")
+		position := 0.0
+		for _, music := range t.Music {
+			// Tracks are sequential in OTIO: a gap places a section at its start.
+			if gap := t.frames(music.Start) - t.frames(position); gap > 0 {
+				audio.Children = append(audio.Children, otioGap{
+					Schema:      "Gap.1",
+					Name:        "",
+					SourceRange: t.otioRange(0, music.Start-position),
+					Effects:     []any{},
+					Markers:     []any{},
+					Enabled:     true,
+					Metadata:    map[string]any{},
+				})
+			}
+			audio.Children = append(audio.Children, t.otioClip(music.Name, music.Path, music.SourceIn, music.Duration, nil))
+			position = music.Start + music.Duration
+		}
+		tracks.Children = append(tracks.Children, audio)
+	}
+
+	timeline := otioTimeline{
+		Schema:   "Timeline.1",
+		Name:     t.Name,
+		Tracks:   tracks,
+		Metadata: map[string]any{"go24k": map[string]any{"resolution": fmt.Sprintf("%dx%d", t.Width, t.Height), "fps": t.FPS}},
+	}
+	data, err := json.MarshalIndent(timeline, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode OTIO timeline: %v", err)
+	}
+	return string(data) + "\n", nil
+}
+
+// exportTimelineFiles writes the requested interchange files next to the output video.
+func exportTimelineFiles(mediaInputs []MediaInput, fadeSec, finalLength float64, outputFilename string, musicFiles []string) {
+	formats, err := NormalizeExportFormats(activeOptions.Export.Formats)
+	if err != nil || len(formats) == 0 {
+		return
+	}
+
+	musicDurations := make([]float64, len(musicFiles))
+	musicSourceIns := make([]float64, len(musicFiles))
+	for i, file := range musicFiles {
+		// The same excerpt and length the mix plays, so later tracks start where they are heard
+		if sourceIn, length, err := musicTrackPlayback(i, file); err == nil {
+			musicSourceIns[i], musicDurations[i] = sourceIn, length
+		}
+	}
+	timeline := buildExportTimeline(mediaInputs, fadeSec, finalLength, outputFilename, musicFiles, musicDurations, musicSourceIns)
+	base := strings.TrimSuffix(outputFilename, filepath.Ext(outputFilename))
+
+	for _, format := range formats {
+		var content string
+		var exportErr error
+		switch format {
+		case exportFormatFCPXML:
+			content = formatFCPXML(timeline)
+		case exportFormatEDL:
+			content = formatEDL(timeline)
+		case exportFormatOTIO:
+			content, exportErr = formatOTIO(timeline)
+		}
+		if exportErr != nil {
+			fmt.Printf("Warning: %v\n", exportErr)
+			continue
+		}
+		exportFile := base + "." + format
+		if err := os.WriteFile(exportFile, []byte(content), 0644); err != nil {
+			fmt.Printf("Warning: failed to write %s: %v\n", exportFile, err)
+			continue
+		}
+		fmt.Printf("Timeline exported to %s\n", exportFile)
+	}
+}
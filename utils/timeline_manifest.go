@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TimelineManifest describes a rendered video: which source file is shown when,
+// and how it was animated. It is written next to the output as <video>.timeline.json.
+type TimelineManifest struct {
+	Output     string                 `json:"output"`
+	Resolution string                 `json:"resolution"`
+	FPS        int                    `json:"fps"`
+	Duration   float64                `json:"duration"`
+	Transition TimelineTransition     `json:"transition"`
+	Items      []TimelineManifestItem `json:"items"`
+}
+
+// TimelineTransition is the transition used between every pair of items.
+type TimelineTransition struct {
+	Type     string  `json:"type"`
+	Duration float64 `json:"duration"`
+}
+
+// TimelineManifestItem is one timeline entry. Start and End are seconds in the final
+// video; consecutive items overlap by the transition duration.
+type TimelineManifestItem struct {
+	Index           int     `json:"index"`
+	Type            string  `json:"type"` // image, video, title or credits
+	Source          string  `json:"source"`
+	Original        string  `json:"original,omitempty"`
+	Start           float64 `json:"start"`
+	End             float64 `json:"end"`
+	StartTimecode   string  `json:"startTimecode"`
+	EndTimecode     string  `json:"endTimecode"`
+	TransitionIn    string  `json:"transitionIn"`
+	TransitionOut   string  `json:"transitionOut"`
+	KenBurns        string  `json:"kenBurns,omitempty"`
+	CapturedAt      string  `json:"capturedAt,omitempty"`
+	KeepsClipAudio  bool    `json:"keepsClipAudio,omitempty"`
+	SegmentDuration float64 `json:"segmentDuration"`
+}
+
+// timelineManifestFilename returns the manifest path for an output video.
+func timelineManifestFilename(outputFilename string) string {
+	return strings.TrimSuffix(outputFilename, filepath.Ext(outputFilename)) + ".timeline.json"
+}
+
+// timelineItemType classifies a timeline item for the manifest.
+func timelineItemType(media MediaInput) string {
+	switch {
+	case media.IsCard && filepath.Base(media.Path) == filepath.Base(creditsCardFile):
+		return "credits"
+	case media.IsCard:
+		return "title"
+	case media.IsImage:
+		return "image"
+	default:
+		return "video"
+	}
+}
+
+// roundSeconds keeps manifest times at millisecond precision so renders diff cleanly.
+func roundSeconds(seconds float64) float64 {
+	return float64(int64(seconds*1000+0.5)) / 1000
+}
+
+// buildTimelineManifest describes the timeline using the offsets from buildTimelineOffsets.
+// applyKenBurns reports whether photos were animated; keepVideoAudio whether clip audio was kept.
+func buildTimelineManifest(mediaInputs []MediaInput, fadeSec, finalLength float64, outputFilename string, applyKenBurns, keepVideoAudio bool) TimelineManifest {
+	offsets := buildTimelineOffsets(mediaInputs, fadeSec)
+	manifest := TimelineManifest{
+		Output:     outputFilename,
+		Resolution: activeResolution,
+		FPS:        activeFPS,
+		Duration:   roundSeconds(finalLength),
+		Transition: TimelineTransition{Type: crossfadeTransition, Duration: roundSeconds(fadeSec)},
+		Items:      make([]TimelineManifestItem, 0, len(mediaInputs)),
+	}
+
+	for i, media := range mediaInputs {
+		end := offsets[i] + media.SegmentDuration
+		if end > finalLength {
+			end = finalLength
+		}
+		item := TimelineManifestItem{
+			Index:           i + 1,
+			Type:            timelineItemType(media),
+			Source:          filepath.ToSlash(media.Path),
+			Start:           roundSeconds(offsets[i]),
+			End:             roundSeconds(end),
+			StartTimecode:   formatSubtitleTimestamp(offsets[i], "."),
+			EndTimecode:     formatSubtitleTimestamp(end, "."),
+			TransitionIn:    crossfadeTransition,
+			TransitionOut:   crossfadeTransition,
+			SegmentDuration: roundSeconds(media.SegmentDuration),
+		}
+		if i == 0 {
+			item.TransitionIn = "fade-in"
+		}
+		if i == len(mediaInputs)-1 {
+			item.TransitionOut = "fade-out"
+		}
+
+		if !media.IsCard {
+			item.Original = mediaSourcePath(media)
+			if media.IsImage && applyKenBurns {
+				item.KenBurns = media.KenBurnsVariant
+			}
+			if !media.IsImage && keepVideoAudio && media.HasAudio {
+				item.KeepsClipAudio = true
+			}
+		}
+		if media.HasCapturedAt {
+			item.CapturedAt = media.CapturedAt.Format(time.RFC3339)
+		}
+		manifest.Items = append(manifest.Items, item)
+	}
+	return manifest
+}
+
+// writeTimelineManifest writes the timeline manifest next to the output video.
+func writeTimelineManifest(manifest TimelineManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode timeline manifest: %v", err)
+	}
+	manifestFile := timelineManifestFilename(manifest.Output)
+	if err := os.WriteFile(manifestFile, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write timeline manifest %s: %v", manifestFile, err)
+	}
+	fmt.Printf("Timeline manifest written to %s\n", manifestFile)
+	return nil
+}
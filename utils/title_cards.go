@@ -0,0 +1,328 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// CreditsAuto generates the closing card from the timeline: cameras used,
+// date range and music track titles.
+const CreditsAuto = "auto"
+
+const (
+	titleCardFile   = "converted/title_card.png"
+	creditsCardFile = "converted/credits_card.png"
+)
+
+// cardLine is a single line of text on a title or credits card.
+type cardLine struct {
+	Text   string
+	Size   int  // Font size in pixels
+	Dimmed bool // Drawn at reduced opacity (section headings)
+}
+
+// addTitleAndCreditsCards renders the opening title and closing credits cards
+// and inserts them into the timeline as still images of the given duration.
+// When no card is configured the timeline is returned unchanged.
+func addTitleAndCreditsCards(mediaInputs []MediaInput, duration float64, musicFiles []string) ([]MediaInput, error) {
+	if strings.TrimSpace(activeOptions.Cards.Title) == "" && strings.TrimSpace(activeOptions.Cards.Subtitle) == "" && strings.TrimSpace(activeOptions.Cards.Credits) == "" {
+		return mediaInputs, nil
+	}
+	titleFile, creditsFile := generatedFile(titleCardFile), generatedFile(creditsCardFile)
+	if err := os.MkdirAll(filepath.Dir(titleFile), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create card folder: %v", err)
+	}
+
+	_, canvasH := activeCanvasSize()
+	result := make([]MediaInput, 0, len(mediaInputs)+2)
+
+	if lines := titleCardLines(activeOptions.Cards.Title, activeOptions.Cards.Subtitle, canvasH); len(lines) > 0 {
+		if err := renderCardImage(lines, titleFile); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Adding title card\n")
+		result = append(result, MediaInput{Path: titleFile, IsImage: true, IsCard: true, SegmentDuration: duration, SortName: "title"})
+	}
+
+	result = append(result, mediaInputs...)
+
+	credits := strings.TrimSpace(activeOptions.Cards.Credits)
+	if credits != "" {
+		var lines []cardLine
+		if strings.EqualFold(credits, CreditsAuto) {
+			lines = autoCreditsLines(collectTimelineCameras(mediaInputs), timelineDateRange(mediaInputs), collectMusicTitles(musicFiles), canvasH)
+		} else {
+			lines = textCardLines(credits, canvasH/24)
+		}
+		if len(lines) > 0 {
+			if err := renderCardImage(lines, creditsFile); err != nil {
+				return nil, err
+			}
+			fmt.Printf("Adding credits card\n")
+			result = append(result, MediaInput{Path: creditsFile, IsImage: true, IsCard: true, SegmentDuration: duration, SortName: "credits"})
+		}
+	}
+
+	return result, nil
+}
+
+// titleCardLines lays out the title in large type with the subtitle below it.
+func titleCardLines(title, subtitle string, canvasH int) []cardLine {
+	lines := textCardLines(title, canvasH/10)
+	if sub := textCardLines(subtitle, canvasH/22); len(sub) > 0 {
+		if len(lines) > 0 {
+			lines = append(lines, cardLine{Size: canvasH / 22})
+		}
+		lines = append(lines, sub...)
+	}
+	return lines
+}
+
+// textCardLines splits user text into card lines. A literal "\n" also starts a new line,
+// so multi-line text can be given on the command line.
+func textCardLines(text string, size int) []cardLine {
+	text = normalizeCaptionText(strings.ReplaceAll(text, `\n`, "\n"))
+	if text == "" {
+		return nil
+	}
+	var lines []cardLine
+	for _, line := range strings.Split(text, "\n") {
+		lines = append(lines, cardLine{Text: line, Size: size})
+	}
+	return lines
+}
+
+// autoCreditsLines builds the automatic credits: cameras, date range and music.
+func autoCreditsLines(cameras []string, dateRange string, tracks []string, canvasH int) []cardLine {
+	headingSize, textSize := canvasH/30, canvasH/24
+	var lines []cardLine
+	addSection := func(heading string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		if len(lines) > 0 {
+			lines = append(lines, cardLine{Size: textSize})
+		}
+		lines = append(lines, cardLine{Text: heading, Size: headingSize, Dimmed: true})
+		for _, value := range values {
+			lines = append(lines, cardLine{Text: value, Size: textSize})
+		}
+	}
+
+	addSection("Filmed with", cameras)
+	if dateRange != "" {
+		addSection("Recorded", []string{dateRange})
+	}
+	addSection("Music", tracks)
+	return lines
+}
+
+// cameraDisplayName joins make and model, avoiding "Canon Canon EOS R5".
+func cameraDisplayName(info *CameraInfo) string {
+	if info == nil {
+		return ""
+	}
+	model := strings.TrimSpace(info.Model)
+	brand := strings.TrimSpace(info.Make)
+	if brand == "" || strings.HasPrefix(strings.ToLower(model), strings.ToLower(brand)) {
+		return model
+	}
+	return strings.TrimSpace(brand + " " + model)
+}
+
+// collectTimelineCameras lists the distinct cameras used in the timeline, in order of first appearance.
+func collectTimelineCameras(mediaInputs []MediaInput) []string {
+	var cameras []string
+	seen := map[string]bool{}
+	for _, media := range mediaInputs {
+		if media.IsCard {
+			continue
+		}
+		var info *CameraInfo
+		if media.IsImage {
+			if originalFile := originalFilename(media.Path); originalFile != "" {
+				info, _ = ExtractCameraInfo(originalFile)
+			}
+		} else {
+			info, _ = ExtractVideoCameraInfo(media.Path)
+		}
+		name := cameraDisplayName(info)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		cameras = append(cameras, name)
+	}
+	return cameras
+}
+
+// timelineDateRange formats the first and last capture dates of the timeline.
+// A single day is shown once; it returns "" when no item has a capture time.
+func timelineDateRange(mediaInputs []MediaInput) string {
+	var first, last time.Time
+	for _, media := range mediaInputs {
+		if !media.HasCapturedAt || media.IsCard {
+			continue
+		}
+		if first.IsZero() || media.CapturedAt.Before(first) {
+			first = media.CapturedAt
+		}
+		if last.IsZero() || media.CapturedAt.After(last) {
+			last = media.CapturedAt
+		}
+	}
+	if first.IsZero() {
+		return ""
+	}
+	start, end := first.Format("02/01/2006"), last.Format("02/01/2006")
+	if start == end {
+		return start
+	}
+	return start + " – " + end
+}
+
+// collectMusicTitles returns "Artist – Title" for each music file from its tags,
+// falling back to the file name.
+func collectMusicTitles(musicFiles []string) []string {
+	var titles []string
+	for _, file := range musicFiles {
+		titles = append(titles, readMusicTrackTitle(file))
+	}
+	return titles
+}
+
+// readMusicTrackTitle reads the title and artist tags of a music file with ffprobe.
+func readMusicTrackTitle(filename string) string {
+	fallback := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	cmd := newExecCommand("ffprobe", "-v", "error", "-show_entries", "format_tags=title,artist:stream_tags=title,artist", "-of", "json", filename)
+	output, err := cmd.Output()
+	if err != nil {
+		return fallback
+	}
+	return parseMusicTrackTitle(output, fallback)
+}
+
+// parseMusicTrackTitle converts ffprobe tag JSON into a display title. Format tags
+// (MP3, M4A, FLAC) win over stream tags (Ogg Vorbis, Opus).
+func parseMusicTrackTitle(output []byte, fallback string) string {
+	var probe ffprobeTags
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return fallback
+	}
+	tags := map[string]string{}
+	addTags := func(source map[string]string) {
+		for key, value := range source {
+			key = strings.ToLower(key)
+			if value = strings.TrimSpace(value); value != "" && tags[key] == "" {
+				tags[key] = value
+			}
+		}
+	}
+	addTags(probe.Format.Tags)
+	for _, stream := range probe.Streams {
+		addTags(stream.Tags)
+	}
+	title, artist := tags["title"], tags["artist"]
+	switch {
+	case title != "" && artist != "":
+		return artist + " – " + title
+	case title != "":
+		return title
+	default:
+		return fallback
+	}
+}
+
+// renderCardImage draws centred card lines on a black canvas of the active output size.
+// Lines wider than the caption width are wrapped.
+func renderCardImage(lines []cardLine, outputPath string) error {
+	parsedFont, err := loadOverlayFont(strings.TrimSpace(activeOptions.Overlay.FontFile))
+	if err != nil {
+		return err
+	}
+
+	canvasW, canvasH := activeCanvasSize()
+	maxWidth := int(float64(canvasW) * captionWidthRatio)
+
+	type placedLine struct {
+		text   string
+		face   font.Face
+		height int
+		dimmed bool
+	}
+	faces := map[int]font.Face{}
+	defer func() {
+		for _, face := range faces {
+			face.Close()
+		}
+	}()
+
+	var placed []placedLine
+	totalHeight := 0
+	for _, line := range lines {
+		face, ok := faces[line.Size]
+		if !ok {
+			face, err = opentype.NewFace(parsedFont, &opentype.FaceOptions{Size: float64(line.Size), DPI: 72, Hinting: font.HintingFull})
+			if err != nil {
+				return fmt.Errorf("failed to create card font face: %v", err)
+			}
+			faces[line.Size] = face
+		}
+		height := int(float64(line.Size)*captionLineSpacing + 0.5)
+		wrapped := []string{line.Text}
+		if line.Text != "" {
+			wrapped = wrapCaptionText(line.Text, maxWidth, func(s string) int { return font.MeasureString(face, s).Ceil() })
+		}
+		for _, text := range wrapped {
+			placed = append(placed, placedLine{text: text, face: face, height: height, dimmed: line.Dimmed})
+			totalHeight += height
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, canvasW, canvasH))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.NRGBA{0, 0, 0, 255}}, image.Point{}, draw.Src)
+
+	textColor := overlayColorOrDefault(activeOptions.Overlay.FontColor, defaultOverlayFontColor)
+	dimmedColor := textColor
+	dimmedColor.A = uint8(float64(textColor.A) * 0.7)
+
+	y := (canvasH - totalHeight) / 2
+	for _, line := range placed {
+		if line.text != "" {
+			c := textColor
+			if line.dimmed {
+				c = dimmedColor
+			}
+			metrics := line.face.Metrics()
+			// Centre the glyph box vertically within the line height.
+			baseline := y + (line.height-metrics.Height.Ceil())/2 + metrics.Ascent.Ceil()
+			width := font.MeasureString(line.face, line.text).Ceil()
+			drawer := &font.Drawer{Dst: img, Src: &image.Uniform{c}, Face: line.face, Dot: fixed.P((canvasW-width)/2, baseline)}
+			drawer.DrawString(line.text)
+		}
+		y += line.height
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create card image %s: %v", outputPath, err)
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, img); err != nil {
+		return fmt.Errorf("failed to encode card image %s: %v", outputPath, err)
+	}
+	return nil
+}
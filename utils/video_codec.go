@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	videoCodecH264 = "h264"
+	videoCodecHEVC = "hevc"
+	videoCodecAV1  = "av1"
+	videoCodecVP9  = "vp9"
+
+	containerMP4  = "mp4"
+	containerMKV  = "mkv"
+	containerWebM = "webm"
+)
+
+// VideoOptions selects the video codec and the container of the final render.
+type VideoOptions struct {
+	Codec     string // h264 (default), hevc, av1 or vp9
+	Container string // mp4, mkv or webm; empty picks one for the codec
+}
+
+// videoEncoder is one encoder of a codec with the options used for it.
+type videoEncoder struct {
+	Name        string
+	Description string // Printed when the encoder is selected
+	Args        []string
+}
+
+// NormalizeVideoCodec validates an output video codec. An empty value uses H.264.
+func NormalizeVideoCodec(codec string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(codec)) {
+	case "", videoCodecH264, "avc", "x264":
+		return videoCodecH264, nil
+	case videoCodecHEVC, "h265", "x265":
+		return videoCodecHEVC, nil
+	case videoCodecAV1:
+		return videoCodecAV1, nil
+	case videoCodecVP9:
+		return videoCodecVP9, nil
+	default:
+		return "", fmt.Errorf("invalid video codec %q. Use h264, hevc, av1, or vp9", codec)
+	}
+}
+
+// NormalizeContainer validates an output container. An empty value picks one for the codec.
+func NormalizeContainer(container string) (string, error) {
+	switch strings.ToLower(strings.TrimPrefix(strings.TrimSpace(container), ".")) {
+	case "", "auto":
+		return "", nil
+	case containerMP4, "m4v":
+		return containerMP4, nil
+	case containerMKV, "matroska":
+		return containerMKV, nil
+	case containerWebM:
+		return containerWebM, nil
+	default:
+		return "", fmt.Errorf("invalid container %q. Use mp4, mkv, or webm", container)
+	}
+}
+
+// validateVideoOptions rejects codec and container combinations the muxers cannot write.
+// WebM only carries VP9 or AV1 video with Opus audio.
+func validateVideoOptions(video VideoOptions, audio AudioOptions) error {
+	codec, err := NormalizeVideoCodec(video.Codec)
+	if err != nil {
+		return err
+	}
+	container, err := NormalizeContainer(video.Container)
+	if err != nil {
+		return err
+	}
+	if container != containerWebM {
+		return nil
+	}
+	if codec != videoCodecVP9 && codec != videoCodecAV1 {
+		return fmt.Errorf("WebM only carries VP9 or AV1 video, not %s. Use -codec vp9 or -container mkv", codec)
+	}
+	if audioCodec, _ := NormalizeAudioCodec(audio.Codec); audioCodec != audioCodecOpus {
+		return fmt.Errorf("WebM only carries Opus audio, not %s. Use -audio-codec opus", audioCodec)
+	}
+	return nil
+}
+
+// outputVideoCodec returns the video codec of the current run.
+func outputVideoCodec() string {
+	codec, _ := NormalizeVideoCodec(activeOptions.Video.Codec)
+	return codec
+}
+
+// outputContainer returns the container of the current run. Without -container,
+// H.264, HEVC and AV1 go to MP4, and VP9 to WebM when the audio is Opus or to MKV
+// otherwise. Previews are always MP4.
+func outputContainer() string {
+	if activeOptions.Preview.Enabled {
+		return containerMP4
+	}
+	if container, _ := NormalizeContainer(activeOptions.Video.Container); container != "" {
+		return container
+	}
+	if outputVideoCodec() != videoCodecVP9 {
+		return containerMP4
+	}
+	if audioCodec, _ := NormalizeAudioCodec(activeOptions.Audio.Codec); audioCodec == audioCodecOpus {
+		return containerWebM
+	}
+	return containerMKV
+}
+
+// withContainerExtension replaces the .mp4 extension of an output name with the
+// extension of the container.
+func withContainerExtension(filename, container string) string {
+	return strings.TrimSuffix(filename, ".mp4") + "." + container
+}
+
+// containerSubtitleCodec returns the soft subtitle format the container accepts.
+func containerSubtitleCodec() string {
+	switch outputContainer() {
+	case containerMKV:
+		return "srt"
+	case containerWebM:
+		return "webvtt"
+	default:
+		return "mov_text"
+	}
+}
+
+// videoEncoders lists the encoders of a non-H.264 codec in order of preference: the
+// hardware encoders first and the software encoder last. Quality defaults are set per
+// codec for a similar result to CRF 21 in libx264. VAAPI is left out: it needs a device
+// and hwupload in the filter graph, which the software frames of the graph don't have.
+func videoEncoders(codec string) []videoEncoder {
+	switch codec {
+	case videoCodecHEVC:
+		return []videoEncoder{
+			{"hevc_nvenc", "Hardware: NVIDIA NVENC detected - using GPU HEVC encoding",
+				[]string{"-preset", "slow", "-profile:v", "main", "-rc:v", "vbr", "-cq:v", "24", "-b:v", "0", "-maxrate", "12M", "-bufsize", "24M"}},
+			{"hevc_videotoolbox", "Hardware: VideoToolbox detected - using Apple HEVC encoding",
+				[]string{"-profile:v", "main", "-realtime", "false", "-b:v", "8M", "-maxrate", "12M", "-bufsize", "24M"}},
+			{"hevc_mf", "Hardware: Media Foundation detected - using Windows HEVC encoding",
+				[]string{"-quality", "quality", "-rate_control", "quality", "-b:v", "8M", "-maxrate", "12M", "-bufsize", "24M"}},
+			{"hevc_qsv", "Hardware: Intel QSV detected - using Intel HEVC encoding",
+				[]string{"-preset", "slower", "-profile:v", "main", "-global_quality", "24", "-look_ahead", "1", "-maxrate", "10M", "-bufsize", "20M"}},
+			{"hevc_amf", "Hardware: AMD AMF detected - using AMD HEVC encoding",
+				[]string{"-quality", "quality", "-rc", "cqp", "-qp_i", "24", "-qp_p", "24", "-profile:v", "main", "-maxrate", "10M", "-bufsize", "20M"}},
+			{"libx265", "CPU: Using libx265 software encoding",
+				[]string{"-preset", "slow", "-crf", "24", "-x265-params", "log-level=error"}},
+		}
+	case videoCodecAV1:
+		return []videoEncoder{
+			{"av1_nvenc", "Hardware: NVIDIA NVENC detected - using GPU AV1 encoding",
+				[]string{"-preset", "p6", "-rc:v", "vbr", "-cq:v", "30", "-b:v", "0", "-maxrate", "10M", "-bufsize", "20M"}},
+			{"av1_qsv", "Hardware: Intel QSV detected - using Intel AV1 encoding",
+				[]string{"-preset", "slower", "-global_quality", "30", "-look_ahead_depth", "40", "-maxrate", "8M", "-bufsize", "16M"}},
+			{"av1_amf", "Hardware: AMD AMF detected - using AMD AV1 encoding",
+				[]string{"-quality", "quality", "-rc", "cqp", "-qp_i", "30", "-qp_p", "30", "-maxrate", "8M", "-bufsize", "16M"}},
+			{"libsvtav1", "CPU: Using libsvtav1 software encoding",
+				[]string{"-preset", "6", "-crf", "30", "-svtav1-params", "tune=0"}},
+		}
+	case videoCodecVP9:
+		return []videoEncoder{
+			{"vp9_qsv", "Hardware: Intel QSV detected - using Intel VP9 encoding",
+				[]string{"-preset", "slower", "-global_quality", "31", "-maxrate", "12M", "-bufsize", "24M"}},
+			{"libvpx-vp9", "CPU: Using libvpx-vp9 software encoding",
+				[]string{"-crf", "31", "-b:v", "0", "-deadline", "good", "-cpu-used", "2", "-row-mt", "1", "-tile-columns", "2"}},
+		}
+	}
+	return nil
+}
+
+// checkHardwareEncoder reports whether the encoder is in the ffmpeg -encoders listing
+// and can be opened. VideoToolbox is trusted when listed, like the H.264 check.
+func checkHardwareEncoder(name, encoderList string) bool {
+	if !strings.Contains(encoderList, name) {
+		return false
+	}
+	if strings.HasSuffix(name, "_videotoolbox") {
+		return true
+	}
+	testCmd := newExecCommand("ffmpeg", "-f", "lavfi", "-i", "testsrc=duration=0.1:size=320x240:rate=1",
+		"-c:v", name, "-f", "null", "-")
+	return testCmd.Run() == nil
+}
+
+// codecVideoSettings picks the encoder of a non-H.264 codec and returns its options.
+// HEVC in MP4 is tagged hvc1 so Apple devices play it.
+func codecVideoSettings(codec string) []string {
+	encoders := videoEncoders(codec)
+	selected := encoders[len(encoders)-1]
+	// The listing is read once; without ffmpeg the software encoder is kept.
+	if output, err := newExecCommand("ffmpeg", "-encoders").Output(); err == nil {
+		for _, encoder := range encoders[:len(encoders)-1] {
+			if checkHardwareEncoder(encoder.Name, string(output)) {
+				selected = encoder
+				break
+			}
+		}
+	}
+	fmt.Printf("%s\n", selected.Description)
+
+	settings := append([]string{"-c:v", selected.Name}, selected.Args...)
+	if codec == videoCodecHEVC && outputContainer() == containerMP4 {
+		settings = append(settings, "-tag:v", "hvc1")
+	}
+	return settings
+}
@@ -17,7 +17,11 @@ func formatSeconds(seconds float64) string {
 }
 
 // supersampledResolution returns a 2x upscaled version of activeResolution.
+// Previews skip supersampling and return activeResolution unchanged.
 func supersampledResolution() string {
+	if activeOptions.Preview.Enabled {
+		return activeResolution
+	}
 	parts := strings.SplitN(activeResolution, "x", 2)
 	if len(parts) != 2 {
 		return activeResolution
@@ -31,14 +35,16 @@ func supersampledResolution() string {
 }
 
 // processImageFilter creates the video filter for a single image.
-func processImageFilter(file string, index int, duration, fadeDuration float64, applyKenBurns, exifOverlay bool, fontSize int) string {
+// count is the number of timeline items, exposed to the overlay template.
+// kenBurnsVariant selects the motion (see kenBurnsVariants); empty picks one at random.
+func processImageFilter(file string, index, itemIndex, itemCount int, duration, fadeDuration float64, applyKenBurns, exifOverlay bool, fontSize int, kenBurnsVariant string) string {
 	var videoFilter string
 
 	if applyKenBurns {
 		superRes := supersampledResolution()
 		superResScale := strings.Replace(superRes, "x", ":", 1)
 		activeResScale := strings.Replace(activeResolution, "x", ":", 1)
-		effect := getKenBurnsEffect(duration)
+		effect := getKenBurnsEffectVariant(duration, kenBurnsVariant)
 		if index == 0 {
 			videoFilter = fmt.Sprintf("[0:v]scale=%s,%s,scale=%s,fade=t=in:st=0:d=%s,fps=%d,settb=AVTB,setsar=1,format=yuv420p", superResScale, effect, activeResScale, formatSeconds(fadeDuration), activeFPS)
 		} else {
@@ -50,16 +56,18 @@ func processImageFilter(file string, index int, duration, fadeDuration float64,
 		} else {
 			videoFilter = fmt.Sprintf("[%d:v]fps=%d,settb=AVTB,setsar=1,format=yuv420p", index, activeFPS)
 		}
+		if activeOptions.Preview.Enabled {
+			// Converted images keep the full resolution; bring them down to the preview frame.
+			videoFilter = strings.Replace(videoFilter, ":v]", ":v]scale="+strings.Replace(activeResolution, "x", ":", 1)+",", 1)
+		}
 	}
 
-	if exifOverlay {
-		originalFile := GetOriginalFilename(file)
-		if originalFile != "" {
-			if cameraInfo, err := ExtractCameraInfo(originalFile); err == nil && cameraInfo != nil {
-				drawtextFilter := FormatCameraInfoOverlay(cameraInfo, fontSize, index)
-				if drawtextFilter != "" {
-					videoFilter += drawtextFilter
-				}
+	// Pre-rendered PNG captions are composited by buildVideoFilterGraph instead.
+	if exifOverlay && !useImageOverlayRenderer() {
+		if data, ok := imageOverlayData(file, itemIndex, itemCount); ok {
+			drawtextFilter := formatOverlayDrawtext(data, fontSize, index, duration, fadeDuration)
+			if drawtextFilter != "" {
+				videoFilter += drawtextFilter
 			}
 		}
 	}
@@ -67,6 +75,31 @@ func processImageFilter(file string, index int, duration, fadeDuration float64,
 	return videoFilter
 }
 
+// imageOverlayData loads the overlay template data for a converted image from its original file.
+func imageOverlayData(file string, index, count int) (OverlayTemplateData, bool) {
+	originalFile := originalFilename(file)
+	if originalFile == "" {
+		return OverlayTemplateData{}, false
+	}
+	cameraInfo, err := ExtractCameraInfo(originalFile)
+	if err != nil || cameraInfo == nil {
+		return OverlayTemplateData{}, false
+	}
+	return newOverlayTemplateData(cameraInfo, originalFile, index, count), true
+}
+
+// mediaOverlayData loads the overlay template data for any timeline item:
+// EXIF of the original photo for images, container metadata for video clips.
+func mediaOverlayData(media MediaInput, index, count int) (OverlayTemplateData, bool) {
+	if media.IsImage {
+		return imageOverlayData(media.Path, index, count)
+	}
+	return videoOverlayData(media.Path, index, count)
+}
+
+// crossfadeTransition is the xfade transition used between timeline items.
+const crossfadeTransition = "fade"
+
 // buildCrossfadeFilters creates crossfade transitions for variable media segment lengths.
 func buildCrossfadeFilters(segmentDurations []float64, fadeDuration float64) string {
 	var filterComplex string
@@ -81,9 +114,9 @@ func buildCrossfadeFilters(segmentDurations []float64, fadeDuration float64) str
 		next := i + 1
 		offset := cumulative - (float64(i+1) * fadeDuration)
 		if i == 0 {
-			filterComplex += fmt.Sprintf("[v%d][v%d]xfade=transition=fade:duration=%s:offset=%s[x%d]; ", i, next, formatSeconds(fadeDuration), formatSeconds(offset), next)
+			filterComplex += fmt.Sprintf("[v%d][v%d]xfade=transition=%s:duration=%s:offset=%s[x%d]; ", i, next, crossfadeTransition, formatSeconds(fadeDuration), formatSeconds(offset), next)
 		} else {
-			filterComplex += fmt.Sprintf("[x%d][v%d]xfade=transition=fade:duration=%s:offset=%s[x%d]; ", i, next, formatSeconds(fadeDuration), formatSeconds(offset), next)
+			filterComplex += fmt.Sprintf("[x%d][v%d]xfade=transition=%s:duration=%s:offset=%s[x%d]; ", i, next, crossfadeTransition, formatSeconds(fadeDuration), formatSeconds(offset), next)
 		}
 	}
 
@@ -103,6 +136,12 @@ func calculateFinalLength(segmentDurations []float64, fadeDuration float64) floa
 
 // buildFinalFilters creates the fade-out and trim filters.
 func buildFinalFilters(segmentDurations []float64, fadeDuration float64) (string, float64) {
+	return buildFinalFiltersTo(segmentDurations, fadeDuration, "xfout")
+}
+
+// buildFinalFiltersTo is buildFinalFilters with a custom output label, used when
+// more filters (such as the watermark) follow the fade-out.
+func buildFinalFiltersTo(segmentDurations []float64, fadeDuration float64, outputLabel string) (string, float64) {
 	numItems := len(segmentDurations)
 	finalLength := calculateFinalLength(segmentDurations, fadeDuration)
 	fadeOutStart := finalLength - fadeDuration
@@ -113,7 +152,7 @@ func buildFinalFilters(segmentDurations []float64, fadeDuration float64) (string
 		inputLabel = fmt.Sprintf("x%d", numItems-1)
 	}
 	filterComplex += fmt.Sprintf("[%s]trim=duration=%s,setpts=PTS-STARTPTS[xt]; ", inputLabel, formatSeconds(finalLength))
-	filterComplex += fmt.Sprintf("[xt]fade=t=out:st=%s:d=%s[xfout]; ", formatSeconds(fadeOutStart), formatSeconds(fadeDuration))
+	filterComplex += fmt.Sprintf("[xt]fade=t=out:st=%s:d=%s[%s]; ", formatSeconds(fadeOutStart), formatSeconds(fadeDuration), outputLabel)
 
 	return filterComplex, finalLength
 }
@@ -150,8 +189,42 @@ func normalizeKenBurnsMode(mode string) string {
 	}
 }
 
-// getKenBurnsEffect generates a Ken Burns effect using a fixed zoompan expression.
+// kenBurnsVariants names the zoompan motion variants built by getKenBurnsEffectVariant.
+var kenBurnsVariants = []string{
+	"zoom-pan-right",
+	"zoom-pan-left",
+	"zoom-pan-down",
+	"zoom-pan-up",
+	"zoom-pan-down-right",
+	"zoom-pan-down-left",
+	"zoom-pan-up-right",
+	"zoom-pan-up-left",
+}
+
+// randomKenBurnsVariant picks one of the Ken Burns motion variants.
+func randomKenBurnsVariant() string {
+	return kenBurnsVariants[rand.Intn(len(kenBurnsVariants))]
+}
+
+// assignKenBurnsVariants picks the motion variant of every photo up front, so the
+// choice can be reported (see the timeline manifest). Cards and videos get none.
+func assignKenBurnsVariants(mediaInputs []MediaInput) {
+	for i := range mediaInputs {
+		if mediaInputs[i].IsImage && !mediaInputs[i].IsCard {
+			mediaInputs[i].KenBurnsVariant = randomKenBurnsVariant()
+		}
+	}
+}
+
+// getKenBurnsEffect generates a Ken Burns effect using a fixed zoompan expression
+// with a randomly chosen motion variant.
 func getKenBurnsEffect(duration float64) string {
+	return getKenBurnsEffectVariant(duration, randomKenBurnsVariant())
+}
+
+// getKenBurnsEffectVariant generates the Ken Burns effect for a named motion variant.
+// Unknown or empty variants pick one at random.
+func getKenBurnsEffectVariant(duration float64, variant string) string {
 	totalFrames := int(math.Round(duration * float64(activeFPS)))
 	if totalFrames < 1 {
 		totalFrames = 1
@@ -190,6 +263,16 @@ func getKenBurnsEffect(duration float64) string {
 		}
 	}
 
+	if activeOptions.Preview.Enabled {
+		// Offsets above are tuned for the 2x supersampled UHD frame; previews pan the bare frame.
+		previewWidth, _ := activeCanvasSize()
+		scaleOffset := func(offset string) string {
+			value, _ := strconv.Atoi(offset)
+			return strconv.Itoa(int(math.Max(1, math.Round(float64(value)*float64(previewWidth)/7680))))
+		}
+		offsetX, offsetY = scaleOffset(offsetX), scaleOffset(offsetY)
+	}
+
 	superRes := supersampledResolution()
 	zoomStep := (endZoom - startZoom) / float64(totalFrames)
 	if zoomStep < 0.0001 {
@@ -210,16 +293,20 @@ func getKenBurnsEffect(duration float64) string {
 		)
 	}
 
-	variants := []string{
-		buildExpr("+"+offsetX, ""),
-		buildExpr("-"+offsetX, ""),
-		buildExpr("", "+"+offsetY),
-		buildExpr("", "-"+offsetY),
-		buildExpr("+"+offsetX, "+"+offsetY),
-		buildExpr("-"+offsetX, "+"+offsetY),
-		buildExpr("+"+offsetX, "-"+offsetY),
-		buildExpr("-"+offsetX, "-"+offsetY),
+	variants := map[string]string{
+		"zoom-pan-right":      buildExpr("+"+offsetX, ""),
+		"zoom-pan-left":       buildExpr("-"+offsetX, ""),
+		"zoom-pan-down":       buildExpr("", "+"+offsetY),
+		"zoom-pan-up":         buildExpr("", "-"+offsetY),
+		"zoom-pan-down-right": buildExpr("+"+offsetX, "+"+offsetY),
+		"zoom-pan-down-left":  buildExpr("-"+offsetX, "+"+offsetY),
+		"zoom-pan-up-right":   buildExpr("+"+offsetX, "-"+offsetY),
+		"zoom-pan-up-left":    buildExpr("-"+offsetX, "-"+offsetY),
 	}
 
-	return variants[rand.Intn(len(variants))]
+	effect, ok := variants[variant]
+	if !ok {
+		effect = variants[randomKenBurnsVariant()]
+	}
+	return effect
 }
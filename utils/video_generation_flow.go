@@ -1,6 +1,19 @@
 package utils
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// fitMinimumImageHold is the shortest photo hold fit-audio picks for mixed timelines,
+	// on top of the transition.
+	fitMinimumImageHold = 1.0
+	// defaultFitMinClipLength is the shortest a clip may be trimmed to by fit-audio.
+	defaultFitMinClipLength = 3.0
+)
 
 func validateMediaInputs(mediaInputs []MediaInput, fadeSec float64) (int, int, error) {
 	imageCount := 0
@@ -20,12 +33,47 @@ func validateMediaInputs(mediaInputs []MediaInput, fadeSec float64) (int, int, e
 	return imageCount, videoCount, nil
 }
 
-func setImageDurations(mediaInputs []MediaInput, durationSec float64) {
+// photoHoldMinimum returns the least time the photos may take together: each is held
+// at least minHold, and long enough for its narration (see narrationHold).
+func photoHoldMinimum(mediaInputs []MediaInput, fadeSec, minHold float64) float64 {
+	total := 0.0
+	for _, media := range mediaInputs {
+		if media.IsImage {
+			total += math.Max(minHold, narrationHold(media, fadeSec))
+		}
+	}
+	return total
+}
+
+// setPhotoHolds spreads photoTime over the photos: they share one hold, except photos
+// whose minimum (minHold or their narration hold) is longer, which keep that minimum.
+// photoTime must be at least photoHoldMinimum. It returns the shared hold.
+func setPhotoHolds(mediaInputs []MediaInput, fadeSec, minHold, photoTime float64) float64 {
+	minimums := []float64{}
+	for _, media := range mediaInputs {
+		if media.IsImage {
+			minimums = append(minimums, math.Max(minHold, narrationHold(media, fadeSec)))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(minimums)))
+
+	// Pin the longest minimums until the shared hold covers the rest
+	hold, remaining := minHold, photoTime
+	for i, minimum := range minimums {
+		hold = remaining / float64(len(minimums)-i)
+		if hold >= minimum {
+			break
+		}
+		remaining -= minimum
+	}
+	hold = math.Max(hold, minHold)
+
 	for i := range mediaInputs {
 		if mediaInputs[i].IsImage {
-			mediaInputs[i].SegmentDuration = durationSec
+			mediaInputs[i].SegmentDuration = math.Max(hold, narrationHold(mediaInputs[i], fadeSec))
 		}
 	}
+	return hold
 }
 
 func minimumAudioLength(itemCount int) float64 {
@@ -37,17 +85,31 @@ func applyFitAudioSettings(mediaInputs []MediaInput, durationSec, fadeSec float6
 		return durationSec, fadeSec, nil
 	}
 
-	if videoCount > 0 {
-		fmt.Printf("fit-audio with mixed images/videos keeps original video lengths and uses provided image/transition durations.\n")
-	}
 	if len(musicFiles) == 0 {
-		fmt.Printf("fit-audio requested but no MP3 found; using provided durations.\n")
+		fmt.Printf("fit-audio requested but no music file found; using provided durations.\n")
+		return durationSec, fadeSec, nil
+	}
+	if musicSectioned() {
+		fmt.Printf("fit-audio skipped: -music-tracks sections follow the timeline instead.\n")
 		return durationSec, fadeSec, nil
 	}
 
 	applyAdjustedDurations := func(audioSeconds float64, label string) (float64, float64, error) {
 		if videoCount > 0 {
-			fmt.Printf("fit-audio skipped in mixed media mode; keeping source video durations.\n")
+			oldDuration := durationSec
+			hold, trimmed, err := fitMixedTimelineToMusic(mediaInputs, fadeSec, audioSeconds)
+			if err != nil {
+				return 0, 0, err
+			}
+			if hold == 0 {
+				fmt.Printf("fit-audio: the timeline has no photos to stretch; keeping source video durations.\n")
+				return durationSec, fadeSec, nil
+			}
+			durationSec = hold
+			fmt.Printf("Auto-fit to music (%s, %d videos): photo duration %.2fs → %.2fs, transition %.2fs\n", label, videoCount, oldDuration, durationSec, fadeSec)
+			if trimmed > 0 {
+				fmt.Printf("Auto-fit trimmed %.2fs from the end of the clips so the video ends with the music\n", trimmed)
+			}
 			return durationSec, fadeSec, nil
 		}
 
@@ -58,8 +120,15 @@ func applyFitAudioSettings(mediaInputs []MediaInput, durationSec, fadeSec float6
 
 		oldDuration, oldFade := durationSec, fadeSec
 		durationSec, fadeSec, _ = adjustDurationsToMusic(durationSec, fadeSec, len(mediaInputs), audioSeconds)
+
+		// Photos with narration keep their narration hold; the others share what is left
+		photoTime := float64(len(mediaInputs)) * durationSec
+		minHold := fadeSec + 0.1
+		if photoMinimum := photoHoldMinimum(mediaInputs, fadeSec, minHold); photoMinimum > photoTime {
+			return 0, 0, fmt.Errorf("Audio duration (%.1fs) is too short for the narration of %d images.\nMinimum required: %.1fs. Use shorter narration, fewer images or more audio.", audioSeconds, len(mediaInputs), audioSeconds+photoMinimum-photoTime)
+		}
+		durationSec = setPhotoHolds(mediaInputs, fadeSec, minHold, photoTime)
 		fmt.Printf("Auto-fit to music (%s): duration %.2fs → %.2fs, transition %.2fs → %.2fs\n", label, oldDuration, durationSec, oldFade, fadeSec)
-		setImageDurations(mediaInputs, durationSec)
 		return durationSec, fadeSec, nil
 	}
 
@@ -72,7 +141,7 @@ func applyFitAudioSettings(mediaInputs []MediaInput, durationSec, fadeSec float6
 		return applyAdjustedDurations(audioSeconds, fmt.Sprintf("%.1fs total", audioSeconds))
 	}
 
-	audioSeconds, err := getAudioDurationSeconds(musicFiles[0])
+	audioSeconds, err := getTotalAudioDurationSeconds(musicFiles)
 	if err != nil || audioSeconds <= 0 {
 		fmt.Printf("fit-audio requested but could not read music duration; using provided durations.\n")
 		return durationSec, fadeSec, nil
@@ -80,27 +149,152 @@ func applyFitAudioSettings(mediaInputs []MediaInput, durationSec, fadeSec float6
 	return applyAdjustedDurations(audioSeconds, fmt.Sprintf("%.1fs", audioSeconds))
 }
 
+// fitMixedTimelineToMusic solves for the photo hold that makes a timeline with clips end
+// with the music: clips keep their length, transitions stay fadeSec, and every photo
+// (cards included) gets the same hold, except photos whose narration needs longer
+// (see setPhotoHolds). When the photos alone cannot absorb the
+// difference and activeOptions.Music.FitTrimClips is set, clips are shortened from the end in
+// proportion to their length above the minimum clip length. It updates mediaInputs and
+// returns the photo hold (zero when there are no photos and the music is longer, or
+// shorter without trimming) and the seconds trimmed from clips.
+func fitMixedTimelineToMusic(mediaInputs []MediaInput, fadeSec, audioSeconds float64) (float64, float64, error) {
+	imageCount := 0
+	clipTotal, clipSlack := 0.0, 0.0
+	minClip := activeOptions.Music.FitMinClip
+	if minClip <= 0 {
+		minClip = defaultFitMinClipLength
+	}
+	minClip = math.Max(minClip, fadeSec+0.1)
+	for _, media := range mediaInputs {
+		if media.IsImage {
+			imageCount++
+			continue
+		}
+		clipTotal += media.SegmentDuration
+		clipSlack += math.Max(media.SegmentDuration-minClip, 0)
+	}
+
+	// audio = photo holds + clips - transitions
+	transitions := float64(len(mediaInputs)-1) * fadeSec
+	minHold := fadeSec + fitMinimumImageHold
+	photoTime := audioSeconds + transitions - clipTotal
+	photoMinimum := photoHoldMinimum(mediaInputs, fadeSec, minHold)
+	if imageCount > 0 && photoTime >= photoMinimum {
+		return setPhotoHolds(mediaInputs, fadeSec, minHold, photoTime), 0, nil
+	}
+
+	// Photos at their minimum hold still run past the music: the clips have to give way.
+	excess := photoMinimum - photoTime
+	if excess <= 0 {
+		// Only clips, and the music is longer: there is nothing to stretch.
+		return 0, 0, nil
+	}
+	if imageCount == 0 && !activeOptions.Music.FitTrimClips {
+		// Only clips, and the music is shorter: planMusicFit truncates with a warning.
+		return 0, 0, nil
+	}
+	if !activeOptions.Music.FitTrimClips {
+		return 0, 0, fmt.Errorf("Audio duration (%.1fs) is too short for %d photos and %.1fs of video clips.\nMinimum required: %.1fs. Use -fit-audio-trim-clips, fewer items, or more audio.", audioSeconds, imageCount, clipTotal, audioSeconds+excess)
+	}
+	if excess > clipSlack {
+		return 0, 0, fmt.Errorf("Audio duration (%.1fs) is too short even with clips trimmed to %.1fs.\nMinimum required: %.1fs. Use fewer items or more audio.", audioSeconds, minClip, audioSeconds+excess-clipSlack)
+	}
+
+	ratio := excess / clipSlack
+	for i := range mediaInputs {
+		if media := mediaInputs[i]; !media.IsImage && media.SegmentDuration > minClip {
+			mediaInputs[i].SegmentDuration -= (media.SegmentDuration - minClip) * ratio
+		}
+	}
+	setPhotoHolds(mediaInputs, fadeSec, minHold, photoMinimum)
+	return minHold, excess, nil
+}
+
+// countFFmpegInputs returns the number of "-i" inputs in an FFmpeg argument list.
+func countFFmpegInputs(args []string) int {
+	count := 0
+	for _, arg := range args {
+		if arg == "-i" {
+			count++
+		}
+	}
+	return count
+}
+
 func buildVideoFilterGraph(mediaInputs []MediaInput, fadeSec float64, applyKenBurns, exifOverlay bool, fontSize int) ([]string, string, float64) {
 	inputs := []string{}
+	captionInputs := []string{}
 	filterComplex := ""
 	segmentDurations := make([]float64, 0, len(mediaInputs))
 
+	// Overlays number photos and clips only, so cards don't shift {{.Index}} and {{.Count}}
+	positions, itemCount := itemPositions(mediaInputs)
+	offsets := buildTimelineOffsets(mediaInputs, fadeSec)
 	for index, media := range mediaInputs {
 		var videoFilter string
+		if previewSkipsItem(offsets[index], media.SegmentDuration) {
+			// Outside the preview window: the input stays for stable indexes but is not decoded
+			if media.IsImage {
+				inputs = append(inputs, "-loop", "1", "-t", formatSeconds(media.SegmentDuration), "-i", media.Path)
+			} else {
+				inputs = append(inputs, "-i", media.Path)
+			}
+			segmentDurations = append(segmentDurations, media.SegmentDuration)
+			filterComplex += fmt.Sprintf("%s[v%d]; ", previewPlaceholderFilter(media.SegmentDuration), index)
+			continue
+		}
+		if media.IsCard {
+			inputs = append(inputs, "-loop", "1", "-t", formatSeconds(media.SegmentDuration), "-i", media.Path)
+			videoFilter = processImageFilter(media.Path, index, 0, 0, media.SegmentDuration, fadeSec, false, false, fontSize, "")
+			segmentDurations = append(segmentDurations, media.SegmentDuration)
+			filterComplex += fmt.Sprintf("%s[v%d]; ", videoFilter, index)
+			continue
+		}
+
 		if media.IsImage {
 			inputs = append(inputs, "-loop", "1", "-t", formatSeconds(media.SegmentDuration), "-i", media.Path)
-			videoFilter = processImageFilter(media.Path, index, media.SegmentDuration, fadeSec, applyKenBurns, exifOverlay, fontSize)
+			videoFilter = processImageFilter(media.Path, index, positions[index], itemCount, media.SegmentDuration, fadeSec, applyKenBurns, exifOverlay, fontSize, media.KenBurnsVariant)
 		} else {
 			inputs = append(inputs, "-i", media.Path)
 			videoFilter = processVideoFilter(index, fadeSec)
+			if exifOverlay && !useImageOverlayRenderer() {
+				if data, ok := videoOverlayData(media.Path, positions[index], itemCount); ok {
+					videoFilter += formatOverlayDrawtext(data, fontSize, index, media.SegmentDuration, fadeSec)
+				}
+			}
+		}
+
+		// Captions rendered in Go are extra looped PNG inputs placed after all media inputs.
+		if exifOverlay && useImageOverlayRenderer() {
+			if data, ok := mediaOverlayData(media, positions[index], itemCount); ok {
+				if captionFile := prepareCaptionImage(data, fontSize, index); captionFile != "" {
+					captionInputIndex := len(mediaInputs) + countFFmpegInputs(captionInputs)
+					captionInputs = append(captionInputs, "-loop", "1", "-t", formatSeconds(media.SegmentDuration), "-i", captionFile)
+					baseLabel := fmt.Sprintf("base%d", index)
+					videoFilter = fmt.Sprintf("%s[%s]; %s", videoFilter, baseLabel, buildCaptionOverlayFilter(baseLabel, captionInputIndex, media.SegmentDuration, fadeSec))
+				}
+			}
+		}
+		if activeOptions.Captions.Enabled {
+			videoFilter, captionInputs = applyDescriptionCaption(videoFilter, media, index, fadeSec, len(mediaInputs), captionInputs)
 		}
 		segmentDurations = append(segmentDurations, media.SegmentDuration)
 		filterComplex += fmt.Sprintf("%s[v%d]; ", videoFilter, index)
 	}
+	inputs = append(inputs, captionInputs...)
 
 	filterComplex += buildCrossfadeFilters(segmentDurations, fadeSec)
-	finalFilters, finalLength := buildFinalFilters(segmentDurations, fadeSec)
+	if strings.TrimSpace(activeOptions.Watermark.File) == "" {
+		finalFilters, finalLength := buildFinalFilters(segmentDurations, fadeSec)
+		return inputs, filterComplex + finalFilters, finalLength
+	}
+
+	// The watermark is composited on the faded output, after every crossfade.
+	finalFilters, finalLength := buildFinalFiltersTo(segmentDurations, fadeSec, "xfbase")
+	watermarkInputIndex := countFFmpegInputs(inputs)
+	inputs = append(inputs, "-loop", "1", "-t", formatSeconds(finalLength), "-i", activeOptions.Watermark.File)
 	filterComplex += finalFilters
+	filterComplex += buildWatermarkFilter("xfbase", watermarkInputIndex, finalLength, fadeSec, "xfout")
 
 	return inputs, filterComplex, finalLength
 }
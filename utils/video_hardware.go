@@ -115,7 +115,20 @@ type HardwareEncoder struct {
 }
 
 // getOptimalVideoSettings returns optimized FFmpeg settings based on environment and hardware.
+// Codecs other than H.264 are chosen in codecVideoSettings.
 func getOptimalVideoSettings() []string {
+	settings := []string{"-pix_fmt", "yuv420p"}
+	if outputContainer() == containerMP4 {
+		settings = append(settings, "-movflags", "+faststart")
+	}
+	settings = append(settings,
+		"-r", strconv.Itoa(activeFPS),
+		"-s", activeResolution,
+	)
+	if codec := outputVideoCodec(); codec != videoCodecH264 {
+		return append(settings, codecVideoSettings(codec)...)
+	}
+
 	hasNVENC := checkNVENCAvailable()
 	hasVideoToolbox := checkVideoToolboxAvailable()
 	hasQSV := checkQSVAvailable()
@@ -123,13 +136,6 @@ func getOptimalVideoSettings() []string {
 	hasMediaFoundation := checkMediaFoundationAvailable()
 	hasVAAPI := checkVAAPIAvailable()
 
-	settings := []string{
-		"-pix_fmt", "yuv420p",
-		"-movflags", "+faststart",
-		"-r", strconv.Itoa(activeFPS),
-		"-s", activeResolution,
-	}
-
 	h264Level := "5.1"
 	if activeResolution == resolution4K && activeFPS >= 60 {
 		h264Level = "5.2"
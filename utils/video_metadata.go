@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ffprobeTags is the subset of `ffprobe -show_entries format_tags:stream_tags -of json` output we read.
+type ffprobeTags struct {
+	Format struct {
+		Tags map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		Tags map[string]string `json:"tags"`
+	} `json:"streams"`
+}
+
+var (
+	videoMakeTags     = []string{"com.apple.quicktime.make", "make", "com.android.manufacturer", "manufacturer"}
+	videoModelTags    = []string{"com.apple.quicktime.model", "model", "com.android.model"}
+	videoDateTags     = []string{"com.apple.quicktime.creationdate", "creation_time", "date"}
+	videoLocationTags = []string{"com.apple.quicktime.location.iso6709", "location", "location-eng"}
+
+	iso6709Pattern = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)`)
+)
+
+// ExtractVideoCameraInfo reads the recording device, capture date and GPS location
+// of a video clip from its container metadata using ffprobe.
+func ExtractVideoCameraInfo(filename string) (*CameraInfo, error) {
+	cmd := newExecCommand("ffprobe", "-v", "error",
+		"-show_entries", "format_tags:stream_tags",
+		"-of", "json", filename)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe metadata failed: %v", err)
+	}
+	return parseVideoCameraInfo(output)
+}
+
+// parseVideoCameraInfo converts ffprobe tag JSON into CameraInfo.
+// Container (format) tags take precedence over per-stream tags.
+func parseVideoCameraInfo(output []byte) (*CameraInfo, error) {
+	var probe ffprobeTags
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("parse ffprobe metadata: %v", err)
+	}
+
+	tags := map[string]string{}
+	for _, stream := range probe.Streams {
+		for key, value := range stream.Tags {
+			tags[strings.ToLower(key)] = value
+		}
+	}
+	for key, value := range probe.Format.Tags {
+		tags[strings.ToLower(key)] = value
+	}
+
+	lookup := func(keys []string) string {
+		for _, key := range keys {
+			if value := strings.TrimSpace(tags[key]); value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	info := &CameraInfo{
+		Make:  simplifyBrandName(lookup(videoMakeTags)),
+		Model: lookup(videoModelTags),
+	}
+
+	for _, key := range videoDateTags {
+		if value := strings.TrimSpace(tags[key]); value != "" {
+			if t, ok := parseVideoMetadataTime(value); ok {
+				info.CapturedAt = t
+				info.DateTaken = t.Format("02/01/2006")
+				break
+			}
+		}
+	}
+
+	if lat, lon, ok := parseISO6709(lookup(videoLocationTags)); ok {
+		info.Location = formatCoordinates(lat, lon)
+	}
+
+	return info, nil
+}
+
+// parseVideoMetadataTime accepts the creation time formats written by cameras and phones,
+// including Apple's local-time "2006-01-02T15:04:05-0700".
+func parseVideoMetadataTime(value string) (time.Time, bool) {
+	if t, err := parseVideoCreationTime(value); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse("2006-01-02T15:04:05-0700", value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// parseISO6709 parses the leading latitude/longitude of an ISO 6709 string such as "+40.7128-074.0060+010.000/".
+func parseISO6709(value string) (float64, float64, bool) {
+	match := iso6709Pattern.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return 0, 0, false
+	}
+	lat, err1 := strconv.ParseFloat(match[1], 64)
+	lon, err2 := strconv.ParseFloat(match[2], 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
+
+// videoOverlayData loads the overlay template data for a video clip from its metadata.
+func videoOverlayData(file string, index, count int) (OverlayTemplateData, bool) {
+	info, err := ExtractVideoCameraInfo(file)
+	if err != nil || info == nil {
+		return OverlayTemplateData{}, false
+	}
+	data := newOverlayTemplateData(info, "", index, count)
+	data.Filename = filepath.Base(file)
+	return data, true
+}
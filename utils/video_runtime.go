@@ -241,6 +241,8 @@ func displayVideoInfo(outputFilename string, finalLength float64) {
 	resLabel := "4K UHD"
 	if activeResolution == resolutionFullHD {
 		resLabel = "Full HD"
+	} else if activeResolution == resolutionPreview {
+		resLabel = "preview"
 	}
 	fmt.Printf("\n=== Video generated successfully! ===\n")
 	fmt.Printf("File: %s\n", outputFilename)
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const defaultWatermarkPosition = overlayPositionBottomRight
+
+// validateWatermark checks the watermark settings before rendering starts.
+func validateWatermark(options WatermarkOptions) error {
+	if strings.TrimSpace(options.File) == "" {
+		return nil
+	}
+	if _, err := os.Stat(options.File); err != nil {
+		return fmt.Errorf("watermark file not found: %s", options.File)
+	}
+	if _, err := NormalizeOverlayPosition(options.Position); err != nil {
+		return fmt.Errorf("invalid watermark position: %v", err)
+	}
+	if options.Scale <= 0 || options.Scale > 1 {
+		return fmt.Errorf("watermark scale must be above 0 and at most 1, got %v", options.Scale)
+	}
+	if options.Opacity <= 0 || options.Opacity > 1 {
+		return fmt.Errorf("watermark opacity must be above 0 and at most 1, got %v", options.Opacity)
+	}
+	return nil
+}
+
+// watermarkWidth returns the logo width in pixels: a share of the output width, kept even.
+func watermarkWidth() int {
+	canvasWidth, _ := activeCanvasSize()
+	width := int(float64(canvasWidth)*activeOptions.Watermark.Scale) &^ 1
+	if width < 2 {
+		width = 2
+	}
+	return width
+}
+
+// buildWatermarkFilter overlays the logo input on the finished video stream. It is applied
+// after the crossfade chain and fade-out, so the logo stays steady across transitions.
+// With Fade the logo fades in and out together with the start and end of the video.
+func buildWatermarkFilter(baseLabel string, inputIndex int, finalLength, fadeDuration float64, outputLabel string) string {
+	opacity := activeOptions.Watermark.Opacity
+	position := activeOptions.Watermark.Position
+	if strings.TrimSpace(position) == "" {
+		position = defaultWatermarkPosition
+	}
+
+	logoChain := fmt.Sprintf("[%d:v]fps=%d,settb=AVTB,format=rgba,scale=%d:-1:flags=lanczos", inputIndex, activeFPS, watermarkWidth())
+	if opacity < 1 {
+		logoChain += ",colorchannelmixer=aa=" + strconv.FormatFloat(opacity, 'f', -1, 64)
+	}
+	if activeOptions.Watermark.Fade && fadeDuration > 0 && finalLength > 2*fadeDuration {
+		logoChain += fmt.Sprintf(",fade=t=in:st=0:d=%s:alpha=1,fade=t=out:st=%s:d=%s:alpha=1",
+			formatSeconds(fadeDuration), formatSeconds(finalLength-fadeDuration), formatSeconds(fadeDuration))
+	}
+
+	xPosition, yPosition := positionExpressions(position, activeOptions.Watermark.Margin, activeOptions.Watermark.Margin, "W", "H", "w", "h")
+	return fmt.Sprintf("%s[wm]; [%s][wm]overlay=x=%s:y=%s:format=auto,format=yuv420p[%s]; ",
+		logoChain, baseLabel, xPosition, yPosition, outputLabel)
+}